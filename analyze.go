@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgtony/gcache/client_rest"
+	"github.com/dgtony/gcache/storage"
+	"io/ioutil"
+	"os"
+)
+
+// print analysis of snapshot file as JSON, the same as admin endpoint returns
+func analyzeSnapshot(file string, opts storage.AnalyzeOptions) error {
+	if opts.Top < 0 || opts.HotSample < 0 || opts.HotSample > 1 {
+		return fmt.Errorf("bad analysis options: %+v", opts)
+	}
+	snapshot, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	analysis, err := storage.AnalyzeSnapshot(snapshot, opts)
+	if err != nil {
+		return fmt.Errorf("cannot analyze snapshot %s: %s", file, err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(client_rest.NewAnalysisModel(analysis))
+}
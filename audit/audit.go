@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/dgtony/gcache/metrics"
+	"github.com/gobwas/glob"
+	"io"
+	"strings"
+	"time"
+)
+
+const DEFAULT_QUEUE_SIZE = 4096
+
+// key patterns match keys of named namespaces as "<namespace>/<key>"
+const NAMESPACE_DELIMITER = "/"
+
+var (
+	auditRecords = metrics.NewCounter(
+		"gcache_audit_records_total",
+		"Records written to audit log.")
+	auditDropped = metrics.NewCounter(
+		"gcache_audit_dropped_total",
+		"Audit records dropped on full queue.")
+)
+
+func init() {
+	metrics.Default.MustRegister(auditRecords, auditDropped)
+}
+
+// Audit log of the node, disabled if nil
+var Default *Log
+
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	ClientAddr string    `json:"client_addr"`
+	// authenticated identity name, empty if authentication is disabled
+	Identity string `json:"identity,omitempty"`
+	// empty for default namespace
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	// glob mask of bulk removal
+	Mask      string `json:"mask,omitempty"`
+	ValueSize int    `json:"value_size"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+/*
+Log writes entries as JSON lines in background,
+so that writing never blocks request processing:
+entries are dropped when queue is full.
+*/
+type Log struct {
+	// log all keys if empty
+	filters []glob.Glob
+	// literal prefixes of filters
+	prefixes []string
+	queue    chan Entry
+	out      io.WriteCloser
+	done     chan struct{}
+}
+
+func New(out io.WriteCloser, keyPatterns []string, queueSize int) (*Log, error) {
+	if queueSize < 1 {
+		queueSize = DEFAULT_QUEUE_SIZE
+	}
+	filters := make([]glob.Glob, len(keyPatterns))
+	prefixes := make([]string, len(keyPatterns))
+	for i, pattern := range keyPatterns {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = g
+		prefixes[i] = literalPrefix(pattern)
+	}
+
+	l := &Log{
+		filters:  filters,
+		prefixes: prefixes,
+		queue:    make(chan Entry, queueSize),
+		out:      out,
+		done:     make(chan struct{})}
+	go l.run()
+	return l, nil
+}
+
+// Key is logged if it matches any of patterns, see NAMESPACE_DELIMITER.
+func (l *Log) Matches(key string) bool {
+	if len(l.filters) == 0 {
+		return true
+	}
+	for _, g := range l.filters {
+		if g.Match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Mask is logged if it could select keys matching any of patterns:
+literal prefix of mask and the one of pattern must not diverge.
+*/
+func (l *Log) MatchesMask(mask string) bool {
+	if len(l.filters) == 0 {
+		return true
+	}
+	maskPrefix := literalPrefix(mask)
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(maskPrefix, prefix) || strings.HasPrefix(prefix, maskPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Queue entry for writing, no-op on disabled log. Entries without
+key and mask, e.g. flush, may affect any key and are always written.
+*/
+func (l *Log) Record(e Entry) {
+	if l == nil || !l.matches(e) {
+		return
+	}
+	select {
+	case l.queue <- e:
+	default:
+		auditDropped.With().Inc()
+	}
+}
+
+// Write queued entries and close output, log must not be used after.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	close(l.queue)
+	<-l.done
+	return l.out.Close()
+}
+
+/* internals */
+
+func (l *Log) matches(e Entry) bool {
+	prefix := ""
+	if e.Namespace != "" {
+		prefix = e.Namespace + NAMESPACE_DELIMITER
+	}
+	switch {
+	case e.Mask != "":
+		return l.MatchesMask(prefix + e.Mask)
+	case e.Key != "":
+		return l.Matches(prefix + e.Key)
+	}
+	return true
+}
+
+// part of glob pattern before the first special character
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[{\\"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+func (l *Log) run() {
+	defer close(l.done)
+	enc := json.NewEncoder(l.out)
+	for e := range l.queue {
+		if err := enc.Encode(&e); err != nil {
+			auditDropped.With().Inc()
+			continue
+		}
+		auditRecords.With().Inc()
+	}
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestAuditLog(t *testing.T) {
+	out := &bufferCloser{}
+	l, err := New(out, []string{"user:*", "session:?", "tenant/*"}, 16)
+	if err != nil {
+		t.Fatalf("create audit log: %s", err)
+	}
+
+	now := time.Now()
+	for _, key := range []string{"user:1", "item:1", "session:2", "session:22"} {
+		l.Record(Entry{Time: now, Operation: "SetItem", Key: key, ValueSize: 5, Status: 201})
+	}
+	// keyless entries are always logged, masks in both directions
+	for _, mask := range []string{"", "user:4*", "*", "item:*", "session:[12]"} {
+		l.Record(Entry{Time: now, Operation: "SetItem", Mask: mask, ValueSize: 5, Status: 201})
+	}
+	// namespace is matched along with key
+	for _, ns := range []string{"tenant", "user"} {
+		l.Record(Entry{Time: now, Operation: "SetItem", Namespace: ns, Key: "1", ValueSize: 5, Status: 201})
+		l.Record(Entry{Time: now, Operation: "SetItem", Namespace: ns, Mask: "*", ValueSize: 5, Status: 201})
+	}
+	if err := l.Close(); err != nil || !out.closed {
+		t.Fatalf("close audit log: %v", err)
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(&out.Buffer)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("bad entry %q: %s", scanner.Text(), err)
+		}
+		if e.Operation != "SetItem" || e.ValueSize != 5 || e.Status != 201 || !e.Time.Equal(now) {
+			t.Errorf("unexpected entry: %+v", e)
+		}
+		keys = append(keys, e.Namespace+e.Key+e.Mask)
+	}
+	if !reflect.DeepEqual(keys, []string{"user:1", "session:2", "", "user:4*", "*", "session:[12]", "tenant1", "tenant*"}) {
+		t.Errorf("unexpected keys logged: %v", keys)
+	}
+
+	// bad pattern
+	if _, err := New(out, []string{"user:["}, 16); err == nil {
+		t.Error("no error for bad key pattern")
+	}
+}
+
+func TestAuditLogNonBlocking(t *testing.T) {
+	blocked := make(chan struct{})
+	l := &Log{queue: make(chan Entry, 1), done: make(chan struct{})}
+	dropped := auditDropped.With().Value()
+
+	// nobody reads the queue
+	go func() {
+		l.Record(Entry{Key: "first"})
+		l.Record(Entry{Key: "second"})
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("record blocks on full queue")
+	}
+	if auditDropped.With().Value() != dropped+1 {
+		t.Error("dropped entry is not counted")
+	}
+
+	// disabled log
+	var disabled *Log
+	disabled.Record(Entry{Key: "key"})
+}
@@ -0,0 +1,258 @@
+package client_rest
+
+import (
+	"context"
+	"github.com/dgtony/gcache/metrics"
+	"github.com/dgtony/gcache/replicator"
+	"github.com/dgtony/gcache/storage"
+	"github.com/dgtony/gcache/utils"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"time"
+)
+
+/*
+Administrative endpoints are served on separate port,
+so that they could be closed from clients with firewall.
+*/
+
+var adminRoutes = Routes{
+	Route{
+		Name:       "Metrics",
+		Method:     "GET",
+		Pattern:    "metrics",
+		Permission: PERM_READ,
+		HandlerF:   MetricsHandler},
+
+	Route{
+		Name:       "Info",
+		Method:     "GET",
+		Pattern:    "info",
+		Permission: PERM_ADMIN,
+		HandlerF:   InfoHandler},
+
+	Route{
+		Name:       "SlowLog",
+		Method:     "GET",
+		Pattern:    "slowlog",
+		Permission: PERM_ADMIN,
+		HandlerF:   SlowLogHandler},
+
+	Route{
+		Name:       "ResetSlowLog",
+		Method:     "DELETE",
+		Pattern:    "slowlog",
+		Permission: PERM_ADMIN,
+		HandlerF:   ResetSlowLogHandler},
+
+	Route{
+		Name:       "ReloadConfig",
+		Method:     "POST",
+		Pattern:    "config/reload",
+		Permission: PERM_ADMIN,
+		HandlerF:   ReloadConfigHandler},
+
+	Route{
+		Name:       "Analyze",
+		Method:     "GET",
+		Pattern:    "analyze",
+		Permission: PERM_ADMIN,
+		HandlerF:   AnalyzeHandler},
+
+	Route{
+		Name:       "Flush",
+		Method:     "POST",
+		Pattern:    "flush",
+		Mutating:   true,
+		Permission: PERM_ADMIN,
+		HandlerF:   FlushHandler},
+
+	Route{
+		Name:       "RemoveKeys",
+		Method:     "DELETE",
+		Pattern:    "keys",
+		Mutating:   true,
+		Permission: PERM_ADMIN,
+		HandlerF:   RemoveKeysHandler}}
+
+func StartAdminServer(reloader *Reloader, stopCh chan struct{}) (*http.Server, error) {
+	init_logger()
+
+	conf := reloader.Config()
+	serverAddr := net.JoinHostPort(conf.Admin.Addr, conf.Admin.Port)
+
+	srv := &http.Server{
+		Handler:      NewAdminRouter(reloader),
+		Addr:         serverAddr,
+		ReadTimeout:  time.Duration(conf.ClientHTTP.IdleTimeout) * time.Second,
+		WriteTimeout: time.Duration(conf.ClientHTTP.IdleTimeout) * time.Second}
+
+	if err := serve(srv, "admin", false, stopCh); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+func NewAdminRouter(reloader *Reloader) *mux.Router {
+	router := mux.NewRouter().StrictSlash(true)
+	for _, route := range adminRoutes {
+		// slave data is replaced with the next master snapshot anyway
+		if reloader.Config().Replication.NodeRole == "slave" && route.Mutating {
+			continue
+		}
+
+		var handler http.HandlerFunc = route.HandlerF
+		wrapped := wrapAuth(wrapAdminEnv(handler, reloader), reloader.auth, requiredPermission(route))
+		wrapped = wrapRequestID(wrapRecovery(wrapped, route.Name), route.Name)
+		if route.Mutating {
+			wrapped = wrapAudit(wrapped, route.Name)
+		}
+
+		router.
+			Methods(route.Method).
+			Path("/" + route.Pattern).
+			Name(route.Name).
+			Handler(wrapped)
+	}
+
+	addHealthRoutes(router, reloader.rep)
+
+	router.NotFoundHandler = wrapRequestID(http.HandlerFunc(ResourceNotFound), "NotFound")
+	return router
+}
+
+func wrapAdminEnv(next http.HandlerFunc, reloader *Reloader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CTX_STORAGE_KEY, reloader.rep.Store)
+		ctx = context.WithValue(ctx, CTX_REPLICATOR_KEY, reloader.rep)
+		ctx = context.WithValue(ctx, CTX_RELOADER_KEY, reloader)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func GetReplicatorFromContext(ctx context.Context) (*replicator.Replicator, bool) {
+	rep, ok := ctx.Value(CTX_REPLICATOR_KEY).(*replicator.Replicator)
+	return rep, ok
+}
+
+func GetReloaderFromContext(ctx context.Context) (*Reloader, bool) {
+	reloader, ok := ctx.Value(CTX_RELOADER_KEY).(*Reloader)
+	return reloader, ok
+}
+
+// replicator of request, internal error is sent if handler is not wrapped with environment
+func requestReplicator(w http.ResponseWriter, r *http.Request) (*replicator.Replicator, bool) {
+	rep, ok := GetReplicatorFromContext(r.Context())
+	if !ok {
+		sendMissingContext(w, r, "replicator")
+	}
+	return rep, ok
+}
+
+func requestReloader(w http.ResponseWriter, r *http.Request) (*Reloader, bool) {
+	reloader, ok := GetReloaderFromContext(r.Context())
+	if !ok {
+		sendMissingContext(w, r, "reloader")
+	}
+	return reloader, ok
+}
+
+/* admin handlers */
+
+// expose metrics in Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := requestStorage(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", metrics.CONTENT_TYPE)
+	if err := metrics.Default.Collect(w); err != nil {
+		requestLogger(w).Errorf("cannot write metrics: %s", err)
+		return
+	}
+	for _, c := range store.CollectMetrics() {
+		if err := c.Collect(w); err != nil {
+			requestLogger(w).Errorf("cannot write metrics: %s", err)
+			return
+		}
+	}
+}
+
+// node and replication status report
+func InfoHandler(w http.ResponseWriter, r *http.Request) {
+	reloader, ok := requestReloader(w, r)
+	if !ok {
+		return
+	}
+	rep, ok := requestReplicator(w, r)
+	if !ok {
+		return
+	}
+	info := NodeInfo(reloader.Config(), rep)
+	sendJSONResponse(w, http.StatusOK, &info)
+}
+
+func NodeInfo(conf *utils.Config, rep *replicator.Replicator) InfoModel {
+	status := rep.Status()
+	info := InfoModel{
+		Role:      status.Role,
+		StartTime: status.StartTime,
+		Uptime:    int64(time.Since(status.StartTime).Seconds()),
+		Config: ConfigSummary{
+			Shards:              conf.Storage.NumShards,
+			KeyExpCheckInterval: conf.Storage.ExpiredKeyCheckInterval,
+			DumpUpdatePeriod:    conf.Replication.DumpUpdatePeriod,
+			SaveToFile:          conf.Replication.SaveCacheToFile,
+			ClientAddr:          net.JoinHostPort(conf.ClientHTTP.Addr, conf.ClientHTTP.Port),
+			ClientTLS:           conf.ClientHTTP.TLSCert != "",
+			ReplicationTLS:      conf.Replication.TLSEnabled,
+			AuthEnabled:         conf.Auth.Enabled},
+		Replication: ReplicationInfo{
+			LastDumpTime: optionalTime(status.LastDumpTime),
+			LastDumpSize: status.LastDumpSize,
+			MasterAddr:   status.MasterAddr,
+			LastSync:     optionalTime(status.LastSync)}}
+
+	if status.Role == "slave" {
+		info.Replication.MasterConnected = &status.MasterConnected
+		info.Replication.DisconnectedSince = optionalTime(status.DisconnectedSince)
+		info.Replication.ReconnectAttempts = status.ReconnectAttempts
+	}
+
+	if conf.Replication.SaveCacheToFile {
+		info.Config.CacheFile = conf.Replication.CacheFile
+	}
+
+	shards := rep.Store.ShardStats()
+	info.Storage.KeysPerShard = make([]int, len(shards))
+	for i, stats := range shards {
+		info.Storage.Keys += stats.Keys
+		info.Storage.KeysPerShard[i] = stats.Keys
+		info.Storage.MemoryEstimate += stats.Bytes
+	}
+	names := make([]string, len(conf.Storage.Namespaces))
+	for i, ns := range conf.Storage.Namespaces {
+		names[i] = ns.Name
+	}
+	info.Storage.KeysPerNamespace = storage.NamespaceKeys(rep.Store, names)
+
+	for _, slave := range status.Slaves {
+		info.Replication.Slaves = append(info.Replication.Slaves, SlaveInfo{
+			Addr:            slave.Addr,
+			ProtocolVersion: slave.ProtocolVersion,
+			ConnectedAt:     slave.ConnectedAt,
+			LastRequest:     slave.LastRequest})
+	}
+	return info
+}
+
+/* helpers */
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
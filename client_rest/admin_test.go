@@ -0,0 +1,286 @@
+package client_rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dgtony/gcache/replicator"
+	"github.com/dgtony/gcache/slowlog"
+	"github.com/dgtony/gcache/storage"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientRESTAdminMetrics(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	rep, store := runTestReplicator(t, conf)
+	store.Set("key", []byte("\"value\""), time.Minute)
+	store.Get("key")
+
+	// make some requests to client API
+	router := NewRouter(conf, store, nil, &Authenticator{})
+	for _, path := range []string{"/test/item", "/test/unknown"} {
+		r := httptest.NewRequest("GET", path, bytes.NewReader([]byte(`{"key":"key"}`)))
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	w := httptest.NewRecorder()
+	NewAdminRouter(NewReloader("", nil, conf, rep, &Authenticator{})).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected metrics status: %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, expected := range []string{
+		`gcache_http_requests_total{route="GetItem",code="200"}`,
+		`gcache_http_requests_total{route="NotFound",code="404"}`,
+		`gcache_http_request_duration_seconds_count{route="GetItem"}`,
+		`gcache_storage_operations_total{op="get"}`,
+		`gcache_storage_hits_total`,
+		`gcache_storage_keys{shard="0"}`,
+		`gcache_storage_bytes{shard="1"}`,
+		`gcache_replication_dump_bytes`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metric is not exposed: %s", expected)
+		}
+	}
+}
+
+func TestClientRESTAdminInfo(t *testing.T) {
+	masterConf := getTestConfig(2, "test")
+	masterConf.Replication.NodeRole = "master"
+	masterConf.Replication.MasterAddr = "127.0.0.1:12348"
+	masterConf.Replication.MasterSecret = "secret"
+	masterConf.Replication.DumpUpdatePeriod = 1
+	utils.SetupLoggers(masterConf)
+	master, store := runTestReplicator(t, masterConf)
+	store.Set("key", []byte("\"value\""), time.Minute)
+	time.Sleep(50 * time.Millisecond)
+
+	slaveConf := getTestConfig(2, "test")
+	slaveConf.Replication = masterConf.Replication
+	slaveConf.Replication.NodeRole = "slave"
+	slave, _ := runTestReplicator(t, slaveConf)
+	// initial sync is made in background
+	for i := 0; i < 100 && slave.Readiness() != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := master.Readiness(); err != nil {
+		t.Errorf("master is not ready: %s", err)
+	}
+	if err := slave.Readiness(); err != nil {
+		t.Errorf("slave is not ready: %s", err)
+	}
+
+	// master
+	info := getTestInfo(t, masterConf, master)
+	if info.Role != "master" || info.Storage.Keys != 1 || len(info.Storage.KeysPerShard) != 2 || info.Storage.MemoryEstimate == 0 {
+		t.Errorf("unexpected master info: %+v", info)
+	}
+	if info.Replication.LastDumpTime == nil || info.Replication.LastDumpSize == 0 {
+		t.Errorf("no master dump info: %+v", info.Replication)
+	}
+	if len(info.Replication.Slaves) != 1 || info.Replication.Slaves[0].LastRequest.IsZero() {
+		t.Errorf("unexpected connected slaves: %+v", info.Replication.Slaves)
+	}
+
+	// slave
+	info = getTestInfo(t, slaveConf, slave)
+	if info.Role != "slave" || info.Storage.Keys != 1 || info.Replication.MasterAddr != masterConf.Replication.MasterAddr || info.Replication.LastSync == nil ||
+		info.Replication.MasterConnected == nil || !*info.Replication.MasterConnected {
+		t.Errorf("unexpected slave info: %+v", info)
+	}
+}
+
+/* helpers */
+
+func getTestInfo(t *testing.T, conf *utils.Config, rep *replicator.Replicator) InfoModel {
+	w := httptest.NewRecorder()
+	NewAdminRouter(NewReloader("", nil, conf, rep, &Authenticator{})).ServeHTTP(w, httptest.NewRequest("GET", "/info", nil))
+
+	var info InfoModel
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || w.Code != http.StatusOK {
+		t.Errorf("cannot get node info => status: %d, error: %v", w.Code, err)
+	}
+	return info
+}
+
+func TestClientRESTAdminSlowLog(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	rep, store := runTestReplicator(t, conf)
+
+	// log everything
+	slowlog.Default.Configure(0, 16)
+	defer slowlog.Default.Configure(-1, slowlog.DEFAULT_MAX_LEN)
+	slowlog.Default.Reset()
+
+	store.Set("key", []byte("\"value\""), time.Minute)
+	r := httptest.NewRequest("GET", "/test/item", bytes.NewReader([]byte(`{"key":"key"}`)))
+	NewRouter(conf, store, nil, &Authenticator{}).ServeHTTP(httptest.NewRecorder(), r)
+
+	admin := NewAdminRouter(NewReloader("", nil, conf, rep, &Authenticator{}))
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/slowlog?count=2", nil))
+
+	var resp SlowLogModel
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("cannot get slow log => status: %d, error: %v", w.Code, err)
+	}
+	// newest first: request, then storage read inside
+	if resp.Len != 3 || len(resp.Entries) != 2 {
+		t.Fatalf("unexpected slow log: %+v", resp)
+	}
+	if e := resp.Entries[0]; e.Source != slowlog.SOURCE_REST || e.Operation != "GetItem" || e.Key != "key" || e.ClientAddr == "" ||
+		e.RequestID == "" {
+		t.Errorf("unexpected REST entry: %+v", e)
+	}
+	if e := resp.Entries[1]; e.Source != slowlog.SOURCE_STORAGE || e.Operation != "get" || e.Key != "key" || e.RequestID != "" {
+		t.Errorf("unexpected storage entry: %+v", e)
+	}
+
+	// reset
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/slowlog", nil))
+	if w.Code != http.StatusNoContent || slowlog.Default.Len() != 0 {
+		t.Errorf("slow log is not reset => status: %d, len: %d", w.Code, slowlog.Default.Len())
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/slowlog?count=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad count is accepted => status: %d", w.Code)
+	}
+}
+
+func TestClientRESTAdminFlush(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	conf.Storage.Namespaces = []utils.NamespaceSettings{{Name: "sessions"}}
+	utils.SetupLoggers(conf)
+	rep, store := runTestReplicator(t, conf)
+	sessions := storage.NewNamespace(store, "sessions")
+	for _, key := range []string{"user:42:name", "user:42:email", "user:43:name"} {
+		store.Set(key, []byte("\"value\""), time.Minute)
+		sessions.Set(key, []byte("\"value\""), time.Minute)
+	}
+	admin := NewAdminRouter(NewReloader("", nil, conf, rep, &Authenticator{}))
+
+	testCases := []struct {
+		Method  string
+		Path    string
+		Body    string
+		Status  int
+		Removed int
+	}{
+		{"DELETE", "/keys", `{"mask":"user:42:*"}`, http.StatusOK, 2},
+		{"DELETE", "/keys", `{"mask":"user:4[23]:name","namespace":"sessions"}`, http.StatusOK, 2},
+		{"DELETE", "/keys", `{"mask":"user:[42"}`, http.StatusBadRequest, 0},
+		{"DELETE", "/keys", `{}`, http.StatusBadRequest, 0},
+		{"DELETE", "/keys", `{"mask":"*","namespace":"unknown"}`, http.StatusNotFound, 0},
+		{"POST", "/flush", `{"namespace":"sessions"}`, http.StatusOK, 1},
+		{"POST", "/flush", ``, http.StatusOK, 1},
+	}
+	for _, c := range testCases {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(c.Method, c.Path, strings.NewReader(c.Body)))
+		var resp FlushModel
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != c.Status || resp.Removed != c.Removed {
+			t.Errorf("unexpected response to %s %s %s => status: %d, body: %s", c.Method, c.Path, c.Body, w.Code, w.Body.String())
+		}
+	}
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("keys left after flush: %v", keys)
+	}
+
+	// disabled on slaves
+	conf.Replication.NodeRole = "slave"
+	w := httptest.NewRecorder()
+	NewAdminRouter(NewReloader("", nil, conf, rep, &Authenticator{})).ServeHTTP(w, httptest.NewRequest("POST", "/flush", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("flush is served by slave => status: %d", w.Code)
+	}
+}
+
+func TestClientRESTAdminAnalyze(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	rep, store := runTestReplicator(t, conf)
+	store.Set("user:42:name", []byte("\"value\""), time.Minute)
+	store.Set("user:42:avatar", []byte(strings.Repeat("v", 1000)), time.Hour)
+	store.Set("session", []byte("\"value\""), time.Minute)
+	store.Get("session")
+	admin := NewAdminRouter(NewReloader("", nil, conf, rep, &Authenticator{}))
+
+	for _, path := range []string{"/analyze?top=-1", "/analyze?top=many", "/analyze?sample=2"} {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("bad options are accepted: %s => status: %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/analyze?top=1&delimiter=:&sample=1", nil))
+	var resp AnalysisModel
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("unexpected response => status: %d, body: %s", w.Code, w.Body.String())
+	}
+	if resp.Keys != 3 || len(resp.Biggest) != 1 || resp.Biggest[0].Key != "user:42:avatar" || resp.Biggest[0].Type != "string" {
+		t.Errorf("unexpected biggest keys: %+v", resp)
+	}
+	if len(resp.Prefixes) != 1 || resp.Prefixes[0].Prefix != "user" || resp.Prefixes[0].Keys != 2 {
+		t.Errorf("unexpected prefixes: %+v", resp.Prefixes)
+	}
+	if last := resp.TTL[len(resp.TTL)-1]; resp.TTL[1].MaxTTL == nil || *resp.TTL[1].MaxTTL != 60 ||
+		resp.TTL[1].Keys != 2 || last.MaxTTL != nil {
+		t.Errorf("unexpected TTL histogram: %s", w.Body.String())
+	}
+	if resp.Sampled != 3 || len(resp.Hottest) != 1 || resp.Hottest[0].Key != "session" || resp.Hottest[0].Hits != 1 {
+		t.Errorf("unexpected hottest keys: %+v", resp.Hottest)
+	}
+}
+
+func TestClientRESTHealth(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	conf.Replication.NodeRole = "slave"
+	utils.SetupLoggers(conf)
+	// slave is not synced yet
+	rep := &replicator.Replicator{Role: "slave"}
+	rep.SetPeriods(time.Second, time.Second)
+
+	router := NewRouter(conf, nil, nil, getAuthEnabled(t))
+	addHealthRoutes(router, rep)
+
+	for _, c := range []struct {
+		Path   string
+		Code   int
+		Status string
+	}{
+		{"/health/live", http.StatusOK, HEALTH_STATUS_OK},
+		{"/health/ready", http.StatusServiceUnavailable, HEALTH_STATUS_NOT_READY},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", c.Path, nil))
+		var resp HealthModel
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != c.Code || resp.Status != c.Status {
+			t.Errorf("unexpected response for %s => status: %d, body: %s", c.Path, w.Code, w.Body.String())
+		}
+	}
+}
+
+/* helpers */
+
+func getAuthEnabled(t *testing.T) *Authenticator {
+	auth, err := NewAuthenticator(getTestAuthConfig())
+	if err != nil {
+		t.Fatalf("create authenticator: %s", err)
+	}
+	return auth
+}
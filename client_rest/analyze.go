@@ -0,0 +1,79 @@
+package client_rest
+
+import (
+	"github.com/dgtony/gcache/storage"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/* memory analysis handler, admin only */
+
+// analyze stored keys, options are set with 'top', 'delimiter' and 'sample' query parameters
+func AnalyzeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := storage.AnalyzeOptions{Delimiter: query.Get("delimiter")}
+	if param := query.Get("top"); param != "" {
+		var err error
+		if opts.Top, err = strconv.Atoi(param); err != nil || opts.Top < 0 {
+			sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "bad number of top keys")
+			return
+		}
+	}
+	if param := query.Get("sample"); param != "" {
+		var err error
+		if opts.HotSample, err = strconv.ParseFloat(param, 64); err != nil || opts.HotSample < 0 || opts.HotSample > 1 {
+			sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "bad sample fraction")
+			return
+		}
+	}
+
+	store, ok := requestStorage(w, r)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	analysis := store.Analyze(opts)
+	requestLogger(w).Infof("analyzed %d keys in %s", analysis.Keys, time.Since(start))
+	sendJSONResponse(w, http.StatusOK, NewAnalysisModel(analysis))
+}
+
+// Response model of storage or snapshot analysis.
+func NewAnalysisModel(a *storage.Analysis) *AnalysisModel {
+	model := &AnalysisModel{
+		Keys:     a.Keys,
+		Bytes:    a.Bytes,
+		Biggest:  keyStatModels(a.Biggest),
+		Prefixes: make([]PrefixStatModel, len(a.Prefixes)),
+		TTL:      make([]TTLBucketModel, len(a.TTL)),
+		Hottest:  keyStatModels(a.Hottest),
+		Sampled:  a.Sampled}
+	for i, p := range a.Prefixes {
+		model.Prefixes[i] = PrefixStatModel{Namespace: p.Namespace, Prefix: p.Prefix, Keys: p.Keys, Bytes: p.Bytes}
+	}
+	for i, b := range a.TTL {
+		model.TTL[i] = TTLBucketModel{Keys: b.Keys, Bytes: b.Bytes}
+		if b.Max != math.MaxInt64 {
+			maxTTL := int(b.Max / time.Second)
+			model.TTL[i].MaxTTL = &maxTTL
+		}
+	}
+	return model
+}
+
+/* helpers */
+
+func keyStatModels(stats []storage.KeyStat) []KeyStatModel {
+	models := make([]KeyStatModel, len(stats))
+	for i, s := range stats {
+		models[i] = KeyStatModel{
+			Namespace: s.Namespace,
+			Key:       s.Key,
+			Type:      s.Type.String(),
+			Bytes:     s.Bytes,
+			Hits:      s.Hits,
+			TTL:       int(s.TTL / time.Second)}
+	}
+	return models
+}
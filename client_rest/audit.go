@@ -0,0 +1,63 @@
+package client_rest
+
+import (
+	"context"
+	"github.com/dgtony/gcache/audit"
+	"io"
+	"net/http"
+	"time"
+)
+
+/*
+Write data-modifying request in audit log, value size
+is the size of request payload unless set by handler.
+*/
+func wrapAudit(next http.Handler, routeName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if audit.Default == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		// admin routes are not wrapped with slow log collecting request details
+		info, ok := r.Context().Value(CTX_REQUEST_KEY).(*requestInfo)
+		if !ok {
+			info = &requestInfo{valueSize: -1}
+			r = r.WithContext(context.WithValue(r.Context(), CTX_REQUEST_KEY, info))
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(sw, r)
+
+		entry := audit.Entry{
+			Time:       start,
+			Operation:  routeName,
+			ClientAddr: r.RemoteAddr,
+			ValueSize:  body.n,
+			Status:     sw.status,
+			Namespace:  info.namespace,
+			Key:        info.key,
+			Mask:       info.mask,
+			RequestID:  w.Header().Get(REQUEST_ID_HEADER)}
+		if info.identity != nil {
+			entry.Identity = info.identity.Name
+		}
+		if info.valueSize >= 0 {
+			entry.ValueSize = info.valueSize
+		}
+		audit.Default.Record(entry)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += n
+	return n, err
+}
@@ -0,0 +1,100 @@
+package client_rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/dgtony/gcache/audit"
+	"github.com/dgtony/gcache/utils"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientRESTAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gcache-audit")
+	if err != nil {
+		t.Fatalf("create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	auditFile := filepath.Join(dir, "audit.log")
+	out, err := utils.OpenRotatingFile(auditFile, 0, 0)
+	if err != nil {
+		t.Fatalf("open audit file: %s", err)
+	}
+	if audit.Default, err = audit.New(out, []string{"session:*", "sessions/*"}, 16); err != nil {
+		t.Fatalf("create audit log: %s", err)
+	}
+
+	conf := getTestAuthConfig()
+	conf.General = getTestConfig(2, "test").General
+	conf.Storage = getTestConfig(2, "test").Storage
+	conf.Replication = getTestConfig(2, "test").Replication
+	conf.Storage.Namespaces = []utils.NamespaceSettings{{Name: "sessions"}}
+	conf.ClientHTTP.RoutePrefix = "test"
+	utils.SetupLoggers(conf)
+	auth, _ := NewAuthenticator(conf)
+	rep, store := runTestReplicator(t, conf)
+	router := NewRouter(conf, store, nil, auth)
+	admin := NewAdminRouter(NewReloader("", nil, conf, rep, auth))
+
+	for _, c := range []struct{ Method, Target, Body string }{
+		{"POST", "/test/item", `{"key":"session:1","value":"12345","ttl":60}`},
+		{"GET", "/test/item", `{"key":"session:1"}`},
+		{"POST", "/test/item", `{"key":"other","value":"1","ttl":60}`},
+		{"DELETE", "/test/item", `{"key":"session:1"}`},
+		{"POST", "/test/item?namespace=sessions", `{"key":"other","value":"1","ttl":60}`},
+	} {
+		r := httptest.NewRequest(c.Method, c.Target, bytes.NewReader([]byte(c.Body)))
+		r.Header.Set("Authorization", "Bearer admin-token")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	for _, c := range []struct{ Method, Path, Body string }{
+		{"DELETE", "/keys", `{"mask":"other*"}`},
+		{"DELETE", "/keys", `{"mask":"session:*"}`},
+		{"POST", "/flush", ``},
+	} {
+		r := httptest.NewRequest(c.Method, c.Path, bytes.NewReader([]byte(c.Body)))
+		r.Header.Set("Authorization", "Bearer admin-token")
+		admin.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// flush
+	audit.Default.Close()
+	audit.Default = nil
+
+	data, err := ioutil.ReadFile(auditFile)
+	if err != nil {
+		t.Fatalf("read audit file: %s", err)
+	}
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var e audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("bad audit entry %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("unexpected audit entries: %+v", entries)
+	}
+	if e := entries[0]; e.Operation != "SetItem" || e.Key != "session:1" || e.Identity != "admin" || e.ValueSize != 7 ||
+		e.Status != 201 || e.ClientAddr == "" || e.RequestID == "" {
+		t.Errorf("unexpected set entry: %+v", e)
+	}
+	if e := entries[1]; e.Operation != "RemoveItem" || e.Key != "session:1" || e.Status != 204 || e.ValueSize == 0 {
+		t.Errorf("unexpected remove entry: %+v", e)
+	}
+	if e := entries[2]; e.Operation != "SetItem" || e.Namespace != "sessions" || e.Key != "other" {
+		t.Errorf("unexpected namespace entry: %+v", e)
+	}
+	if e := entries[3]; e.Operation != "RemoveKeys" || e.Mask != "session:*" || e.Identity != "admin" || e.Status != 200 {
+		t.Errorf("unexpected remove keys entry: %+v", e)
+	}
+	if e := entries[4]; e.Operation != "Flush" || e.Key != "" || e.Mask != "" || e.Status != 200 {
+		t.Errorf("unexpected flush entry: %+v", e)
+	}
+}
@@ -0,0 +1,238 @@
+package client_rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgtony/gcache/storage"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Permission int
+
+const (
+	PERM_NONE Permission = iota
+	PERM_READ
+	PERM_READ_WRITE
+	PERM_ADMIN
+)
+
+var (
+	ErrNoCredentials = errors.New("no credentials provided")
+	ErrBadToken      = errors.New("invalid token")
+	ErrTokenExpired  = errors.New("token expired")
+)
+
+var permissionNames = map[string]Permission{
+	"read-only":  PERM_READ,
+	"read-write": PERM_READ_WRITE,
+	"admin":      PERM_ADMIN}
+
+func ParsePermission(name string) (Permission, error) {
+	perm, ok := permissionNames[name]
+	if !ok {
+		return PERM_NONE, fmt.Errorf("unknown permission: %q", name)
+	}
+	return perm, nil
+}
+
+func (p Permission) String() string {
+	for name, perm := range permissionNames {
+		if perm == p {
+			return name
+		}
+	}
+	return "none"
+}
+
+// Authenticated client
+type Identity struct {
+	Name       string
+	Permission Permission
+	// key prefixes available, all keys if empty
+	Prefixes []string
+	// named namespaces available besides default one, all if "*" is listed
+	Namespaces []string
+}
+
+// any namespace is available to token
+const NAMESPACE_ANY = "*"
+
+func (i *Identity) KeyAllowed(key string) bool {
+	if len(i.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range i.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Identity) NamespaceAllowed(name string) bool {
+	if name == storage.DEFAULT_NAMESPACE {
+		return true
+	}
+	for _, ns := range i.Namespaces {
+		if ns == name || ns == NAMESPACE_ANY {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Signed token format: <payload>.<signature>
+where payload is base64-encoded JSON with token claims
+and signature is base64-encoded HMAC-SHA256 of encoded payload.
+*/
+type TokenClaims struct {
+	Name       string   `json:"sub"`
+	Permission string   `json:"perm"`
+	Prefixes   []string `json:"prefixes,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// expiration time, unix seconds
+	Expire int64 `json:"exp,omitempty"`
+}
+
+type Authenticator struct {
+	enabled bool
+	// identities by SHA-256 of static token
+	tokens     map[string]*Identity
+	hmacSecret []byte
+	sync.RWMutex
+}
+
+func NewAuthenticator(conf *utils.Config) (*Authenticator, error) {
+	auth := &Authenticator{}
+	if err := auth.Reload(conf); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// Replace settings and tokens, previous ones are kept on error.
+func (a *Authenticator) Reload(conf *utils.Config) error {
+	tokens, err := buildTokens(conf)
+	if err != nil {
+		return err
+	}
+	a.set(conf, tokens)
+	return nil
+}
+
+func (a *Authenticator) set(conf *utils.Config, tokens map[string]*Identity) {
+	a.Lock()
+	a.enabled = conf.Auth.Enabled
+	a.tokens = tokens
+	a.hmacSecret = []byte(conf.Auth.HMACSecret)
+	a.Unlock()
+}
+
+// identities of static tokens by token hash
+func buildTokens(conf *utils.Config) (map[string]*Identity, error) {
+	tokens := make(map[string]*Identity)
+	for _, t := range conf.Auth.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("empty token: %q", t.Name)
+		}
+		perm, err := ParsePermission(t.Permission)
+		if err != nil {
+			return nil, fmt.Errorf("token %q: %s", t.Name, err)
+		}
+		tokens[tokenHash(t.Token)] = &Identity{Name: t.Name, Permission: perm, Prefixes: t.Prefixes, Namespaces: t.Namespaces}
+	}
+	return tokens, nil
+}
+
+func (a *Authenticator) Enabled() bool {
+	a.RLock()
+	defer a.RUnlock()
+	return a.enabled
+}
+
+// Obtain client identity from 'Authorization: Bearer <token>' header
+// or 'access_token' query parameter.
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := r.URL.Query().Get("access_token")
+	if header := r.Header.Get("Authorization"); header != "" {
+		if !strings.HasPrefix(header, "Bearer ") {
+			return nil, ErrBadToken
+		}
+		token = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	a.RLock()
+	identity, ok := a.tokens[tokenHash(token)]
+	secret := a.hmacSecret
+	a.RUnlock()
+
+	if ok {
+		return identity, nil
+	}
+	if len(secret) > 0 && strings.Contains(token, ".") {
+		return verifySigned(secret, token)
+	}
+	return nil, ErrBadToken
+}
+
+// Issue token signed with given secret
+func SignToken(secret []byte, claims TokenClaims) (string, error) {
+	data, err := json.Marshal(&claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature := base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+	return payload + "." + signature, nil
+}
+
+/* internals */
+
+func verifySigned(secret []byte, token string) (*Identity, error) {
+	parts := strings.SplitN(token, ".", 2)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, sign(secret, parts[0])) {
+		return nil, ErrBadToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrBadToken
+	}
+	if claims.Expire > 0 && time.Now().Unix() > claims.Expire {
+		return nil, ErrTokenExpired
+	}
+
+	perm, err := ParsePermission(claims.Permission)
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	return &Identity{Name: claims.Name, Permission: perm, Prefixes: claims.Prefixes, Namespaces: claims.Namespaces}, nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return string(hash[:])
+}
@@ -0,0 +1,171 @@
+package client_rest
+
+import (
+	"encoding/json"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientRESTAuthStaticTokens(t *testing.T) {
+	auth, err := NewAuthenticator(getTestAuthConfig())
+	if err != nil {
+		t.Fatalf("create authenticator: %s", err)
+	}
+
+	testCases := []struct {
+		Header     string
+		Err        error
+		Permission Permission
+	}{
+		{"", ErrNoCredentials, PERM_NONE},
+		{"Basic dXNlcjpwYXNz", ErrBadToken, PERM_NONE},
+		{"Bearer unknown", ErrBadToken, PERM_NONE},
+		{"Bearer reader-token", nil, PERM_READ},
+		{"Bearer admin-token", nil, PERM_ADMIN},
+	}
+	for _, c := range testCases {
+		r, _ := http.NewRequest("GET", "/item", nil)
+		if c.Header != "" {
+			r.Header.Set("Authorization", c.Header)
+		}
+		identity, err := auth.Authenticate(r)
+		if err != c.Err || (err == nil && identity.Permission != c.Permission) {
+			t.Errorf("unexpected authentication result for %q => identity: %+v, error: %v", c.Header, identity, err)
+		}
+	}
+
+	// token in query
+	r, _ := http.NewRequest("GET", "/subscribe?access_token=reader-token", nil)
+	if identity, err := auth.Authenticate(r); err != nil || identity.Name != "reader" {
+		t.Errorf("query token is not accepted => identity: %+v, error: %v", identity, err)
+	}
+
+	// bad settings
+	conf := getTestAuthConfig()
+	conf.Auth.Tokens[0].Permission = "superuser"
+	if _, err := NewAuthenticator(conf); err == nil {
+		t.Error("no error for unknown permission")
+	}
+}
+
+func TestClientRESTAuthSignedTokens(t *testing.T) {
+	conf := getTestAuthConfig()
+	auth, err := NewAuthenticator(conf)
+	if err != nil {
+		t.Fatalf("create authenticator: %s", err)
+	}
+	secret := []byte(conf.Auth.HMACSecret)
+
+	valid, _ := SignToken(secret, TokenClaims{Name: "svc", Permission: "read-write", Prefixes: []string{"svc:"}, Namespaces: []string{"svc"}})
+	expired, _ := SignToken(secret, TokenClaims{Name: "svc", Permission: "read-write", Expire: time.Now().Add(-time.Minute).Unix()})
+	forged, _ := SignToken([]byte("other secret"), TokenClaims{Name: "svc", Permission: "admin"})
+
+	testCases := []struct {
+		Token string
+		Err   error
+	}{
+		{valid, nil},
+		{expired, ErrTokenExpired},
+		{forged, ErrBadToken},
+		{valid[:len(valid)-2], ErrBadToken},
+	}
+	for _, c := range testCases {
+		r, _ := http.NewRequest("GET", "/item", nil)
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+		if _, err := auth.Authenticate(r); err != c.Err {
+			t.Errorf("unexpected signed token error => expected: %v, get: %v", c.Err, err)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/item", nil)
+	r.Header.Set("Authorization", "Bearer "+valid)
+	identity, _ := auth.Authenticate(r)
+	if identity == nil || identity.Permission != PERM_READ_WRITE || !identity.KeyAllowed("svc:1") || identity.KeyAllowed("other:1") ||
+		!identity.NamespaceAllowed("svc") || identity.NamespaceAllowed("other") || !identity.NamespaceAllowed("") {
+		t.Errorf("wrong signed token identity: %+v", identity)
+	}
+}
+
+func TestClientRESTAPIAuth(t *testing.T) {
+	routePrefix := "test"
+	conf := getTestConfig(2, routePrefix)
+	conf.Auth = getTestAuthConfig().Auth
+	conf.Storage.Namespaces = []utils.NamespaceSettings{{Name: "sessions"}, {Name: "tenant"}}
+	srv := startTestServer(conf)
+	defer srv.Shutdown(nil)
+
+	// no token
+	jsonPayload = []byte(`{"key":"session:1"}`)
+	checkRespErrorAuth(t, conf, "", "GET", "item", jsonPayload, http.StatusUnauthorized, ERR_CODE_UNAUTHORIZED)
+
+	// read-only token on mutating endpoint
+	jsonPayload = []byte(`{"key":"session:1", "value": "v", "ttl": 60}`)
+	checkRespErrorAuth(t, conf, "reader-token", "POST", "item", jsonPayload, http.StatusForbidden, ERR_CODE_FORBIDDEN)
+
+	// key out of scope
+	jsonPayload = []byte(`{"key":"user:1", "value": "v", "ttl": 60}`)
+	checkRespErrorAuth(t, conf, "sessions-token", "POST", "item", jsonPayload, http.StatusForbidden, ERR_CODE_FORBIDDEN)
+
+	// key in scope
+	jsonPayload = []byte(`{"key":"session:1", "value": "v", "ttl": 60}`)
+	code, _, err := makeRequestAuth(conf, "sessions-token", "POST", "item", jsonPayload)
+	if err != nil || code != http.StatusCreated {
+		t.Errorf("cannot set key in scope => status: %d, error: %v", code, err)
+	}
+	jsonPayload = []byte(`{"key":"other", "value": "v", "ttl": 60}`)
+	makeRequestAuth(conf, "admin-token", "POST", "item", jsonPayload)
+
+	// scoped keys listing
+	code, body, _ := makeRequestAuth(conf, "sessions-token", "GET", "keys", nil)
+	if code != http.StatusOK || string(body) != "{\"mask\":\"*\",\"keys\":[\"session:1\"]}\n" {
+		t.Errorf("unexpected scoped keys => status: %d, body: %s", code, body)
+	}
+
+	// namespaces out of scope, unknown ones are not disclosed
+	jsonPayload = []byte(`{"key":"session:1", "value": "v", "ttl": 60}`)
+	checkRespErrorAuth(t, conf, "sessions-token", "POST", "item?namespace=tenant", jsonPayload, http.StatusForbidden, ERR_CODE_FORBIDDEN)
+	checkRespErrorAuth(t, conf, "sessions-token", "GET", "keys?namespace=unknown", nil, http.StatusForbidden, ERR_CODE_FORBIDDEN)
+	checkRespErrorAuth(t, conf, "reader-token", "GET", "keys?namespace=sessions", nil, http.StatusForbidden, ERR_CODE_FORBIDDEN)
+
+	// namespaces in scope
+	if code, _, err := makeRequestAuth(conf, "sessions-token", "POST", "item?namespace=sessions", jsonPayload); err != nil || code != http.StatusCreated {
+		t.Errorf("cannot set key in namespace in scope => status: %d, error: %v", code, err)
+	}
+	code, body, _ = makeRequestAuth(conf, "admin-token", "GET", "keys?namespace=tenant", nil)
+	if code != http.StatusOK || string(body) != "{\"mask\":\"*\",\"keys\":[]}\n" {
+		t.Errorf("unexpected keys of any namespace => status: %d, body: %s", code, body)
+	}
+}
+
+/* helpers */
+
+func getTestAuthConfig() *utils.Config {
+	return &utils.Config{
+		Auth: utils.AuthSettings{
+			Enabled:    true,
+			HMACSecret: "hmac-secret",
+			Tokens: []utils.TokenSettings{
+				utils.TokenSettings{Name: "reader", Token: "reader-token", Permission: "read-only"},
+				utils.TokenSettings{Name: "admin", Token: "admin-token", Permission: "admin", Namespaces: []string{"*"}},
+				utils.TokenSettings{
+					Name:       "sessions",
+					Token:      "sessions-token",
+					Permission: "read-write",
+					Prefixes:   []string{"session:"},
+					Namespaces: []string{"sessions"}}}}}
+}
+
+func checkRespErrorAuth(t *testing.T, conf *utils.Config, token, method, endpoint string, jsonPayload []byte, expHTTPCode, expErrCode int) {
+	code, body, err := makeRequestAuth(conf, token, method, endpoint, jsonPayload)
+	if err != nil {
+		t.Errorf("make request: %s", err)
+	}
+	if err := json.Unmarshal(body, &decodedErr); err != nil {
+		t.Errorf("decoding response: %s", err)
+	}
+	if code != expHTTPCode || decodedErr.Code != expErrCode {
+		t.Errorf("unexpected response => status code: %d, error code: %d", code, decodedErr.Code)
+	}
+}
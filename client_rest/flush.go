@@ -0,0 +1,90 @@
+package client_rest
+
+import (
+	"github.com/dgtony/gcache/storage"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+)
+
+/* bulk removal handlers, admin only */
+
+// remove all keys, or keys of given namespace only
+func FlushHandler(w http.ResponseWriter, r *http.Request) {
+	var req FlushModel
+	// request body is optional
+	if r.ContentLength != 0 && !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	setRequestNamespace(r, req.Namespace)
+
+	store, ok := requestStorage(w, r)
+	if !ok {
+		return
+	}
+	reloader, ok := requestReloader(w, r)
+	if !ok {
+		return
+	}
+	if req.Namespace == "" {
+		req.Removed = store.Flush()
+	} else if knownNamespace(reloader.Config(), req.Namespace) {
+		req.Removed = storage.NewNamespace(store, req.Namespace).Flush()
+	} else {
+		sendErrorResponse(w, http.StatusNotFound, ERR_CODE_UNKNOWN_NAMESPACE, "unknown namespace")
+		return
+	}
+	requestLogger(w).Infof("flushed %d keys of namespace %q", req.Removed, req.Namespace)
+	sendJSONResponse(w, http.StatusOK, &req)
+}
+
+// remove keys matching glob mask, in default namespace unless specified
+func RemoveKeysHandler(w http.ResponseWriter, r *http.Request) {
+	var req FlushModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	if req.Mask == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_MASK, "no key mask provided")
+		return
+	}
+	setRequestMask(r, req.Mask)
+	setRequestNamespace(r, req.Namespace)
+	store, ok := requestStorage(w, r)
+	if !ok {
+		return
+	}
+	reloader, ok := requestReloader(w, r)
+	if !ok {
+		return
+	}
+	if !knownNamespace(reloader.Config(), req.Namespace) {
+		sendErrorResponse(w, http.StatusNotFound, ERR_CODE_UNKNOWN_NAMESPACE, "unknown namespace")
+		return
+	}
+
+	removed, ok := storage.NewNamespace(store, req.Namespace).RemoveMask(req.Mask)
+	if !ok {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_MASK, "bad key mask")
+		return
+	}
+	req.Removed = removed
+	requestLogger(w).Infof("removed %d keys matching %q in namespace %q", removed, req.Mask, req.Namespace)
+	sendJSONResponse(w, http.StatusOK, &req)
+}
+
+/* helpers */
+
+// default or configured namespace
+func knownNamespace(conf *utils.Config, name string) bool {
+	if name == storage.DEFAULT_NAMESPACE {
+		return true
+	}
+	for _, ns := range conf.Storage.Namespaces {
+		if ns.Name == name {
+			return true
+		}
+	}
+	return false
+}
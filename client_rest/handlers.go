@@ -2,6 +2,7 @@ package client_rest
 
 import (
 	"encoding/json"
+	"github.com/dgtony/gcache/storage"
 	"net/http"
 	"time"
 )
@@ -35,7 +36,14 @@ func GetItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	store := GetStorageFromContext(r.Context())
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
 	if req.SubKey != "" {
 		// get item from value dictionary
 		value, ok := GetDictItem(store, req.Key, req.SubKey)
@@ -58,6 +66,9 @@ func GetItemHandler(w http.ResponseWriter, r *http.Request) {
 		value, ok := store.Get(req.Key)
 		if ok {
 			sendItemResponse(w, http.StatusOK, &CacheItem{Key: req.Key, Value: value})
+		} else if store.Type(req.Key) != storage.TYPE_NONE {
+			// complex values have their own endpoints
+			sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_WRONG_TYPE, "wrong value type")
 		} else {
 			sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_FOUND, "value not found")
 		}
@@ -71,24 +82,33 @@ func SetItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// validate
+	// validate, namespace default is used for omitted TTL
+	req.TTL = requestTTL(r, req.TTL)
 	if req.Key == "" {
 		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
 		return
 	} else if len(req.Value) < 1 {
 		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_PROVIDED, "no value provided")
 		return
-	} else if req.TTL < KEY_TTL_MIN || req.TTL > KEY_TTL_MAX {
+	} else if !validTTL(req.TTL) {
 		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_TTL, "bad key TTL")
 		return
 	}
 
-	store := GetStorageFromContext(r.Context())
-	if store.Set(req.Key, req.Value, time.Duration(req.TTL)*time.Second) {
-		sendItemResponse(w, http.StatusCreated, req)
-	} else {
-		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_CANNOT_SET_KEY, "cannot save provided data")
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+	setRequestValueSize(r, len(req.Value))
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	if err := store.Set(req.Key, req.Value, time.Duration(req.TTL)*time.Second); err != nil {
+		sendStorageError(w, err)
+		return
 	}
+	sendItemResponse(w, http.StatusCreated, req)
 }
 
 func RemoveItemHandler(w http.ResponseWriter, r *http.Request) {
@@ -104,22 +124,34 @@ func RemoveItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	store := GetStorageFromContext(r.Context())
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
 	store.Remove(req.Key)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func GetKeysHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
 	req, ok := readKeysRequest(r.Body)
-	store := GetStorageFromContext(r.Context())
+	identity := GetIdentityFromContext(r.Context())
 	if !ok || req.Mask == "" {
 		// get all keys
-		sendKeysResponse(w, http.StatusOK, &KeysModel{Mask: "*", Keys: store.Keys()})
+		sendKeysResponse(w, http.StatusOK, &KeysModel{Mask: "*", Keys: filterKeyScope(identity, store.Keys())})
 	} else {
 		// get keys by mask
+		setRequestKey(r, req.Mask)
 		keys, ok := store.KeysMask(req.Mask)
 		if ok {
-			sendKeysResponse(w, http.StatusOK, &KeysModel{Mask: req.Mask, Keys: keys})
+			sendKeysResponse(w, http.StatusOK, &KeysModel{Mask: req.Mask, Keys: filterKeyScope(identity, keys)})
 		} else {
 			sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_MASK, "bad key mask")
 		}
@@ -128,6 +160,35 @@ func GetKeysHandler(w http.ResponseWriter, r *http.Request) {
 
 /* helpers */
 
+// check key against client key scope, send error response if key is not available
+func checkKeyScope(w http.ResponseWriter, r *http.Request, key string) bool {
+	// every key operation passes here
+	setRequestKey(r, key)
+	identity := GetIdentityFromContext(r.Context())
+	if identity != nil && !identity.KeyAllowed(key) {
+		sendErrorResponse(w, http.StatusForbidden, ERR_CODE_FORBIDDEN, "key is out of token scope")
+		return false
+	}
+	return true
+}
+
+func filterKeyScope(identity *Identity, keys []string) []string {
+	if identity == nil || len(identity.Prefixes) == 0 {
+		return keys
+	}
+	filtered := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if identity.KeyAllowed(k) {
+			filtered = append(filtered, k)
+		}
+	}
+	return filtered
+}
+
+func validTTL(ttl int) bool {
+	return ttl >= KEY_TTL_MIN && ttl <= KEY_TTL_MAX
+}
+
 func sendErrorResponse(w http.ResponseWriter, header_status int, err_code int, reason string) {
 	var response = ErrorResponse{
 		Code:   err_code,
@@ -135,20 +196,27 @@ func sendErrorResponse(w http.ResponseWriter, header_status int, err_code int, r
 
 	w.WriteHeader(header_status)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		logger.Errorf("cannot encode error message, code: %d, message: %s", err_code, reason)
+		requestLogger(w).Errorf("cannot encode error message, code: %d, message: %s", err_code, reason)
+	}
+}
+
+func sendJSONResponse(w http.ResponseWriter, header_status int, response interface{}) {
+	w.WriteHeader(header_status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		requestLogger(w).Errorf("cannot encode response: %+v", response)
 	}
 }
 
 func sendItemResponse(w http.ResponseWriter, header_status int, itemResponse *CacheItem) {
 	w.WriteHeader(header_status)
 	if !writeItemResponse(w, itemResponse) {
-		logger.Errorf("cannot encode item response: %+v", itemResponse)
+		requestLogger(w).Errorf("cannot encode item response: %+v", itemResponse)
 	}
 }
 
 func sendKeysResponse(w http.ResponseWriter, header_status int, keysResponse *KeysModel) {
 	w.WriteHeader(header_status)
 	if !writeKeysResponse(w, keysResponse) {
-		logger.Errorf("cannot encode item response: %+v", keysResponse)
+		requestLogger(w).Errorf("cannot encode item response: %+v", keysResponse)
 	}
 }
@@ -0,0 +1,140 @@
+package client_rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgtony/gcache/pubsub"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// comment line period keeping idle event stream alive
+	SSE_KEEPALIVE_PERIOD = 15 * time.Second
+)
+
+/* pub/sub handlers */
+
+func PublishHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := readPublishRequest(r.Body)
+	if !ok {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+
+	// validate
+	if req.Channel == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_CHANNEL, "no channel provided")
+		return
+	} else if len(req.Message) < 1 {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_PROVIDED, "no message provided")
+		return
+	}
+
+	if !checkKeyScope(w, r, req.Channel) {
+		return
+	}
+
+	broker, ok := requestBroker(w, r)
+	if !ok {
+		return
+	}
+	receivers := broker.Publish(req.Channel, req.Message)
+	sendJSONResponse(w, http.StatusOK, &PublishResponse{Channel: req.Channel, Receivers: receivers})
+}
+
+// Stream messages from channels and patterns given in query
+// parameters 'channel' and 'pattern' as server-sent events.
+func SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	channels, patterns := query["channel"], query["pattern"]
+	if len(channels) == 0 && len(patterns) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_CHANNEL, "no channel provided")
+		return
+	}
+
+	for _, channel := range channels {
+		if !checkKeyScope(w, r, channel) {
+			return
+		}
+	}
+	// pattern subscribers receive messages only from channels in scope
+	identity := GetIdentityFromContext(r.Context())
+	broker, ok := requestBroker(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendErrorResponse(w, http.StatusInternalServerError, ERR_CODE_BAD_REQ, "streaming is not supported")
+		return
+	}
+
+	// write timeout is meant for regular responses, stream lasts until client leaves
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		requestLogger(w).Warningf("cannot clear write deadline of event stream: %s", err)
+	}
+
+	sub := broker.NewSubscriber()
+	defer sub.Close()
+
+	if err := sub.PSubscribe(patterns...); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_CHANNEL_MASK, "bad channel pattern")
+		return
+	}
+	sub.Subscribe(channels...)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(SSE_KEEPALIVE_PERIOD)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			if identity != nil && !identity.KeyAllowed(msg.Channel) {
+				continue
+			}
+			if err := writeEvent(w, msg); err != nil {
+				requestLogger(w).Debugf("subscriber stream closed: %s", err)
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+/* helpers */
+
+func writeEvent(w io.Writer, msg pubsub.Message) error {
+	event := "message"
+	if msg.Pattern != "" {
+		event = "pmessage"
+	}
+
+	data, err := json.Marshal(&ChannelMessage{
+		Channel: msg.Channel,
+		Pattern: msg.Pattern,
+		Message: msg.Payload})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,425 @@
+package client_rest
+
+import (
+	"encoding/json"
+	"github.com/dgtony/gcache/storage"
+	"math"
+	"net/http"
+	"time"
+)
+
+/* complex value handlers */
+
+func GetTypeHandler(w http.ResponseWriter, r *http.Request) {
+	var req TypeModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, &TypeModel{Key: req.Key, Type: store.Type(req.Key).String()})
+}
+
+// key metadata, reading it doesn't count as key access
+func GetObjectHandler(w http.ResponseWriter, r *http.Request) {
+	var req ObjectModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	info, ok := store.Object(req.Key)
+	if !ok {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_FOUND, "value not found")
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, &ObjectModel{
+		Key:        req.Key,
+		Type:       info.Type.String(),
+		Created:    &info.Created,
+		Updated:    &info.Updated,
+		Accessed:   &info.Accessed,
+		Hits:       info.Hits,
+		Size:       info.Size,
+		Compressed: info.Compressed,
+		TTL:        int(info.TTL / time.Second)})
+}
+
+func LPushHandler(w http.ResponseWriter, r *http.Request) {
+	pushHandler(w, r, true)
+}
+
+func RPushHandler(w http.ResponseWriter, r *http.Request) {
+	pushHandler(w, r, false)
+}
+
+func LRangeHandler(w http.ResponseWriter, r *http.Request) {
+	var req ListModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	}
+
+	stop := -1
+	if req.Stop != nil {
+		stop = *req.Stop
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	values, err := store.LRange(req.Key, req.Start, stop)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, &ListModel{Key: req.Key, Values: rawValues(values), Start: req.Start, Stop: &stop})
+}
+
+func HSetHandler(w http.ResponseWriter, r *http.Request) {
+	var req HashModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+
+	// validate, namespace default is used for omitted TTL
+	req.TTL = requestTTL(r, req.TTL)
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	} else if len(req.Fields) < 1 {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_PROVIDED, "no value provided")
+		return
+	} else if !validTTL(req.TTL) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_TTL, "bad key TTL")
+		return
+	}
+
+	fields := make(map[string][]byte, len(req.Fields))
+	for field, value := range req.Fields {
+		fields[field] = value
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	added, err := store.HSet(req.Key, time.Duration(req.TTL)*time.Second, fields)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, &StructureUpdate{Key: req.Key, Count: added})
+}
+
+// return single field with 'field' parameter, or entire hash otherwise
+func HGetHandler(w http.ResponseWriter, r *http.Request) {
+	var req HashModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	if req.Field != "" {
+		value, ok, err := store.HGet(req.Key, req.Field)
+		if err != nil {
+			sendStorageError(w, err)
+		} else if !ok {
+			sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_FOUND, "value not found")
+		} else {
+			sendJSONResponse(w, http.StatusOK, &HashModel{Key: req.Key, Field: req.Field, Value: value})
+		}
+		return
+	}
+
+	fields, err := store.HGetAll(req.Key)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	rawFields := make(map[string]json.RawMessage, len(fields))
+	for field, value := range fields {
+		rawFields[field] = value
+	}
+	sendJSONResponse(w, http.StatusOK, &HashModel{Key: req.Key, Fields: rawFields})
+}
+
+func SAddHandler(w http.ResponseWriter, r *http.Request) {
+	var req SetModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+
+	// validate, namespace default is used for omitted TTL
+	req.TTL = requestTTL(r, req.TTL)
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	} else if len(req.Members) < 1 {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_PROVIDED, "no value provided")
+		return
+	} else if !validTTL(req.TTL) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_TTL, "bad key TTL")
+		return
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	added, err := store.SAdd(req.Key, time.Duration(req.TTL)*time.Second, req.Members...)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, &StructureUpdate{Key: req.Key, Count: added})
+}
+
+// check membership with 'member' parameter, or return all members otherwise
+func SGetHandler(w http.ResponseWriter, r *http.Request) {
+	var req SetModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	if req.Member != "" {
+		isMember, err := store.SIsMember(req.Key, req.Member)
+		if err != nil {
+			sendStorageError(w, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, &SetModel{Key: req.Key, Member: req.Member, IsMember: &isMember})
+		return
+	}
+
+	members, err := store.SMembers(req.Key)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, &SetModel{Key: req.Key, Members: members})
+}
+
+func ZAddHandler(w http.ResponseWriter, r *http.Request) {
+	var req ZSetModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+
+	// validate, namespace default is used for omitted TTL
+	req.TTL = requestTTL(r, req.TTL)
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	} else if len(req.Members) < 1 {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_PROVIDED, "no value provided")
+		return
+	} else if !validTTL(req.TTL) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_TTL, "bad key TTL")
+		return
+	}
+
+	members := make([]storage.ZMember, len(req.Members))
+	for i, m := range req.Members {
+		members[i] = storage.ZMember{Member: m.Member, Score: m.Score}
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	added, err := store.ZAdd(req.Key, time.Duration(req.TTL)*time.Second, members...)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, &StructureUpdate{Key: req.Key, Count: added})
+}
+
+func ZRangeByScoreHandler(w http.ResponseWriter, r *http.Request) {
+	var req ZSetModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	}
+
+	min, max := math.Inf(-1), math.Inf(1)
+	if req.Min != nil {
+		min = *req.Min
+	}
+	if req.Max != nil {
+		max = *req.Max
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	members, err := store.ZRangeByScore(req.Key, min, max)
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+
+	resp := &ZSetModel{Key: req.Key, Members: make([]ZSetMember, len(members)), Min: req.Min, Max: req.Max}
+	for i, m := range members {
+		resp.Members[i] = ZSetMember{Member: m.Member, Score: m.Score}
+	}
+	sendJSONResponse(w, http.StatusOK, resp)
+}
+
+/* helpers */
+
+func pushHandler(w http.ResponseWriter, r *http.Request, left bool) {
+	var req ListModel
+	if !readRequest(r.Body, &req) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "cannot decode request")
+		return
+	}
+
+	// validate, namespace default is used for omitted TTL
+	req.TTL = requestTTL(r, req.TTL)
+	if req.Key == "" {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+		return
+	} else if len(req.Values) < 1 {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_VALUE_PROVIDED, "no value provided")
+		return
+	} else if !validTTL(req.TTL) {
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_TTL, "bad key TTL")
+		return
+	}
+
+	values := make([][]byte, len(req.Values))
+	for i, v := range req.Values {
+		values[i] = v
+	}
+
+	if !checkKeyScope(w, r, req.Key) {
+		return
+	}
+
+	store, ok := requestKeyspace(w, r)
+	if !ok {
+		return
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	var length int
+	var err error
+	if left {
+		length, err = store.LPush(req.Key, ttl, values...)
+	} else {
+		length, err = store.RPush(req.Key, ttl, values...)
+	}
+	if err != nil {
+		sendStorageError(w, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusCreated, &StructureUpdate{Key: req.Key, Count: length})
+}
+
+func sendStorageError(w http.ResponseWriter, err error) {
+	switch err {
+	case storage.ErrWrongType:
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_WRONG_TYPE, "wrong value type")
+	case storage.ErrEmptyKey:
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_NO_KEY_PROVIDED, "no key provided")
+	case storage.ErrKeyTooLong:
+		sendErrorResponse(w, http.StatusRequestEntityTooLarge, ERR_CODE_KEY_TOO_LONG, "key is too long")
+	case storage.ErrValueTooBig:
+		sendErrorResponse(w, http.StatusRequestEntityTooLarge, ERR_CODE_VALUE_TOO_BIG, "value is too big")
+	case storage.ErrKeyChars:
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_KEY_CHARS, "invalid characters in key")
+	default:
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_CANNOT_SET_KEY, "cannot process provided data")
+	}
+}
+
+func rawValues(values [][]byte) []json.RawMessage {
+	res := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
@@ -0,0 +1,72 @@
+package client_rest
+
+import (
+	"context"
+	"github.com/dgtony/gcache/replicator"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+const (
+	HEALTH_STATUS_OK        = "ok"
+	HEALTH_STATUS_NOT_READY = "not ready"
+)
+
+// public endpoints, available without authentication
+var healthRoutes = Routes{
+	Route{
+		Name:     "Liveness",
+		Method:   "GET",
+		Pattern:  "health/live",
+		HandlerF: LivenessHandler},
+
+	Route{
+		Name:     "Readiness",
+		Method:   "GET",
+		Pattern:  "health/ready",
+		HandlerF: ReadinessHandler}}
+
+// add health routes to router, paths are not affected by client route prefix
+func addHealthRoutes(router *mux.Router, rep *replicator.Replicator) {
+	for _, route := range healthRoutes {
+		var handler http.HandlerFunc = route.HandlerF
+		wrapped := wrapRequestID(wrapRecovery(wrapHealthEnv(handler, rep), route.Name), route.Name)
+		wrapped = wrapMetrics(wrapped, route.Name)
+
+		router.
+			Methods(route.Method).
+			Path("/" + route.Pattern).
+			Name(route.Name).
+			Handler(wrapped)
+	}
+}
+
+func wrapHealthEnv(next http.HandlerFunc, rep *replicator.Replicator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), CTX_REPLICATOR_KEY, rep)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+/* health handlers */
+
+// node process is running and serving requests
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	sendJSONResponse(w, http.StatusOK, &HealthModel{Status: HEALTH_STATUS_OK})
+}
+
+// node is ready to serve clients according to its replication state
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	rep, ok := requestReplicator(w, r)
+	if !ok {
+		return
+	}
+	if err := rep.Readiness(); err != nil {
+		sendJSONResponse(w, http.StatusServiceUnavailable, &HealthModel{
+			Status: HEALTH_STATUS_NOT_READY,
+			Role:   rep.Role,
+			Reason: err.Error()})
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, &HealthModel{Status: HEALTH_STATUS_OK, Role: rep.Role})
+}
@@ -1,15 +1,20 @@
 package client_rest
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/dgtony/gcache/pubsub"
 	"github.com/dgtony/gcache/replicator"
+	"github.com/dgtony/gcache/storage"
 	"github.com/dgtony/gcache/utils"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -62,6 +67,7 @@ func TestClientRESTAPIBasic(t *testing.T) {
 func TestClientRESTAPISetItem(t *testing.T) {
 	routePrefix := "test"
 	conf := getTestConfig(2, routePrefix)
+	conf.Limits.Prefixes = []utils.PrefixLimits{{Prefix: "small:", KeyMaxLen: 10, ValueMaxSize: 8}}
 	srv := startTestServer(conf)
 	defer srv.Shutdown(nil)
 
@@ -84,6 +90,94 @@ func TestClientRESTAPISetItem(t *testing.T) {
 	// enormously huge ttl
 	jsonPayload = []byte(`{"key":"testkey", "value": "testval", "ttl": 20000000}`)
 	checkRespError(t, conf, "POST", "item", jsonPayload, http.StatusBadRequest, ERR_CODE_BAD_KEY_TTL)
+
+	// limits
+	jsonPayload = []byte(`{"key":"small:too-long", "value": "1", "ttl": 3600}`)
+	checkRespError(t, conf, "POST", "item", jsonPayload, http.StatusRequestEntityTooLarge, ERR_CODE_KEY_TOO_LONG)
+
+	jsonPayload = []byte(`{"key":"small:k", "value": "\"too big\"", "ttl": 3600}`)
+	checkRespError(t, conf, "POST", "item", jsonPayload, http.StatusRequestEntityTooLarge, ERR_CODE_VALUE_TOO_BIG)
+
+	jsonPayload = []byte(`{"key":"small:l", "values": ["\"too big\""], "ttl": 3600}`)
+	checkRespError(t, conf, "POST", "list/rpush", jsonPayload, http.StatusRequestEntityTooLarge, ERR_CODE_VALUE_TOO_BIG)
+
+	jsonPayload = []byte(`{"key":"bad\u0000key", "value": "1", "ttl": 3600}`)
+	checkRespError(t, conf, "POST", "item", jsonPayload, http.StatusBadRequest, ERR_CODE_BAD_KEY_CHARS)
+
+	// other keys use common limits
+	jsonPayload = []byte(`{"key":"large:key", "value": "\"not too big\"", "ttl": 3600}`)
+	checkRespItem(t, conf, "POST", "item", jsonPayload, http.StatusCreated)
+}
+
+func TestClientRESTAPINamespaces(t *testing.T) {
+	routePrefix := "test"
+	conf := getTestConfig(2, routePrefix)
+	conf.Storage.Namespaces = []utils.NamespaceSettings{{Name: "sessions", KeyMaxLen: 8, DefaultTTL: 60}}
+	srv := startTestServer(conf)
+	defer srv.Shutdown(nil)
+
+	jsonPayload = []byte(`{"key":"key", "value": "default", "ttl": 3600}`)
+	checkRespItem(t, conf, "POST", "item", jsonPayload, http.StatusCreated)
+
+	// namespace default TTL is used
+	jsonPayload = []byte(`{"key":"key", "value": "session"}`)
+	item := checkRespItem(t, conf, "POST", "item?namespace=sessions", jsonPayload, http.StatusCreated)
+	if item.TTL != 60 {
+		t.Errorf("default TTL is not applied: %d", item.TTL)
+	}
+
+	// the same key in different namespaces
+	jsonPayload = []byte(`{"key":"key"}`)
+	item = checkRespItem(t, conf, "GET", "item", jsonPayload, http.StatusOK)
+	if string(item.Value) != `"default"` {
+		t.Errorf("unexpected value in default namespace: %s", item.Value)
+	}
+	item = checkRespItem(t, conf, "GET", "item?namespace=sessions", jsonPayload, http.StatusOK)
+	if string(item.Value) != `"session"` {
+		t.Errorf("unexpected value in namespace: %s", item.Value)
+	}
+	keys := checkRespKeys(t, conf, "GET", "keys?namespace=sessions", nil, http.StatusOK)
+	if len(keys.Keys) != 1 || keys.Keys[0] != "key" {
+		t.Errorf("unexpected keys of namespace: %v", keys.Keys)
+	}
+
+	// namespace limits don't include its name
+	jsonPayload = []byte(`{"key":"too-long-key", "value": "1", "ttl": 3600}`)
+	checkRespError(t, conf, "POST", "item?namespace=sessions", jsonPayload, http.StatusRequestEntityTooLarge, ERR_CODE_KEY_TOO_LONG)
+
+	// header is the same as query parameter
+	code, body, err := makeRequestHeader(conf, NAMESPACE_HEADER, "sessions", "GET", "keys", nil)
+	if err != nil || code != http.StatusOK || !strings.Contains(string(body), `"key"`) {
+		t.Errorf("unexpected response to namespace header => code: %d, body: %s, err: %v", code, body, err)
+	}
+
+	// no default TTL in default namespace
+	jsonPayload = []byte(`{"key":"key", "value": "1"}`)
+	checkRespError(t, conf, "POST", "item", jsonPayload, http.StatusBadRequest, ERR_CODE_BAD_KEY_TTL)
+
+	jsonPayload = []byte(`{"key":"key"}`)
+	checkRespError(t, conf, "GET", "item?namespace=unknown", jsonPayload, http.StatusNotFound, ERR_CODE_UNKNOWN_NAMESPACE)
+}
+
+func TestClientRESTAPIObject(t *testing.T) {
+	routePrefix := "test"
+	conf := getTestConfig(2, routePrefix)
+	srv := startTestServer(conf)
+	defer srv.Shutdown(nil)
+
+	jsonPayload = []byte(`{"key":"key", "value": "value", "ttl": 3600}`)
+	checkRespItem(t, conf, "POST", "item", jsonPayload, http.StatusCreated)
+	jsonPayload = []byte(`{"key":"key"}`)
+	checkRespItem(t, conf, "GET", "item", jsonPayload, http.StatusOK)
+
+	var object ObjectModel
+	checkRespJSON(t, conf, "GET", "object", jsonPayload, http.StatusOK, &object)
+	if object.Type != "string" || object.Hits != 1 || object.Size != len(`"value"`) || object.TTL < 3590 || object.Created == nil {
+		t.Errorf("unexpected key metadata: %+v", object)
+	}
+
+	jsonPayload = []byte(`{"key":"missing"}`)
+	checkRespError(t, conf, "GET", "object", jsonPayload, http.StatusBadRequest, ERR_CODE_NO_VALUE_FOUND)
 }
 
 func TestClientRESTAPIKeys(t *testing.T) {
@@ -148,8 +242,162 @@ func TestClientRESTAPISubElements(t *testing.T) {
 	}
 }
 
+func TestClientRESTAPIPubSub(t *testing.T) {
+	routePrefix := "test"
+	conf := getTestConfig(2, routePrefix)
+	srv := startTestServer(conf)
+	// active event streams are closed forcibly
+	defer srv.Close()
+
+	// publish without channel
+	jsonPayload = []byte(`{"message": "hello"}`)
+	checkRespError(t, conf, "POST", "publish", jsonPayload, http.StatusBadRequest, ERR_CODE_NO_CHANNEL)
+
+	// subscribe with malformed pattern
+	checkRespError(t, conf, "GET", "subscribe?pattern=%5Bbad", nil, http.StatusBadRequest, ERR_CODE_BAD_CHANNEL_MASK)
+
+	// open event stream
+	resp, err := http.Get(buildURL(conf, "subscribe?channel=news&pattern=user:*"))
+	if err != nil {
+		t.Fatalf("subscribe request: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected subscribe response => status: %d, content type: %s",
+			resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// publish
+	var published PublishResponse
+	jsonPayload = []byte(`{"channel": "user:42", "message": {"action": "login"}}`)
+	code, body, err := makeRequest(conf, "POST", "publish", jsonPayload)
+	if err != nil {
+		t.Errorf("make request: %s", err)
+	}
+	if err := json.Unmarshal(body, &published); err != nil {
+		t.Errorf("decoding response: %s", err)
+	}
+	if code != http.StatusOK || published.Receivers != 1 {
+		t.Errorf("unexpected publish response => status: %d, response: %+v", code, published)
+	}
+
+	// read event
+	reader := bufio.NewReader(resp.Body)
+	event, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+	expData := `data: {"channel":"user:42","pattern":"user:*","message":{"action":"login"}}`
+	if strings.TrimSpace(event) != "event: pmessage" || strings.TrimSpace(data) != expData {
+		t.Errorf("unexpected event => %q, %q", event, data)
+	}
+}
+
+func TestClientRESTAPIPubSubIdleTimeout(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	conf.ClientHTTP.IdleTimeout = 1
+	srv := startTestServer(conf)
+	defer srv.Close()
+
+	resp, err := http.Get(buildURL(conf, "subscribe?channel=news"))
+	if err != nil {
+		t.Fatalf("subscribe request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// stream outlives server timeouts
+	time.Sleep(2 * time.Second)
+	jsonPayload = []byte(`{"channel": "news", "message": "late"}`)
+	if code, body, err := makeRequest(conf, "POST", "publish", jsonPayload); err != nil || code != http.StatusOK {
+		t.Fatalf("unexpected publish response => status: %d, body: %s, error: %v", code, body, err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	event, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(event) != "event: message" {
+		t.Errorf("event stream is closed by timeout => %q, %v", event, err)
+	}
+}
+
+func TestClientRESTAPIStructures(t *testing.T) {
+	routePrefix := "test"
+	conf := getTestConfig(2, routePrefix)
+	srv := startTestServer(conf)
+	defer srv.Shutdown(nil)
+
+	// list
+	var update StructureUpdate
+	jsonPayload = []byte(`{"key": "list", "values": [1, "two"], "ttl": 60}`)
+	checkRespJSON(t, conf, "POST", "list/rpush", jsonPayload, http.StatusCreated, &update)
+	jsonPayload = []byte(`{"key": "list", "values": [{"zero": 0}], "ttl": 60}`)
+	checkRespJSON(t, conf, "POST", "list/lpush", jsonPayload, http.StatusCreated, &update)
+	if update.Count != 3 {
+		t.Errorf("wrong list length => expected: 3, get: %d", update.Count)
+	}
+
+	var list ListModel
+	jsonPayload = []byte(`{"key": "list", "start": 1}`)
+	checkRespJSON(t, conf, "GET", "list", jsonPayload, http.StatusOK, &list)
+	if len(list.Values) != 2 || string(list.Values[0]) != "1" || string(list.Values[1]) != `"two"` {
+		t.Errorf("unexpected list range: %+v", list)
+	}
+
+	// hash
+	jsonPayload = []byte(`{"key": "hash", "fields": {"a": 1, "b": [2]}, "ttl": 60}`)
+	checkRespJSON(t, conf, "POST", "hash", jsonPayload, http.StatusCreated, &update)
+	var hash HashModel
+	jsonPayload = []byte(`{"key": "hash", "field": "b"}`)
+	checkRespJSON(t, conf, "GET", "hash", jsonPayload, http.StatusOK, &hash)
+	if string(hash.Value) != "[2]" {
+		t.Errorf("unexpected hash field: %+v", hash)
+	}
+
+	// set
+	var set SetModel
+	jsonPayload = []byte(`{"key": "set", "members": ["x", "y"], "ttl": 60}`)
+	checkRespJSON(t, conf, "POST", "set", jsonPayload, http.StatusCreated, &update)
+	jsonPayload = []byte(`{"key": "set", "member": "y"}`)
+	checkRespJSON(t, conf, "GET", "set", jsonPayload, http.StatusOK, &set)
+	if set.IsMember == nil || !*set.IsMember {
+		t.Errorf("unexpected set membership: %+v", set)
+	}
+
+	// sorted set
+	var zset ZSetModel
+	jsonPayload = []byte(`{"key": "zset", "members": [{"member": "b", "score": 2}, {"member": "a", "score": 1}], "ttl": 60}`)
+	checkRespJSON(t, conf, "POST", "zset", jsonPayload, http.StatusCreated, &update)
+	jsonPayload = []byte(`{"key": "zset", "max": 1.5}`)
+	checkRespJSON(t, conf, "GET", "zset", jsonPayload, http.StatusOK, &zset)
+	if len(zset.Members) != 1 || zset.Members[0].Member != "a" {
+		t.Errorf("unexpected sorted set range: %+v", zset)
+	}
+
+	// type
+	var valueType TypeModel
+	checkRespJSON(t, conf, "GET", "type", []byte(`{"key": "zset"}`), http.StatusOK, &valueType)
+	if valueType.Type != "zset" {
+		t.Errorf("unexpected value type: %+v", valueType)
+	}
+
+	// wrong types
+	jsonPayload = []byte(`{"key": "list"}`)
+	checkRespError(t, conf, "GET", "item", jsonPayload, http.StatusBadRequest, ERR_CODE_WRONG_TYPE)
+	jsonPayload = []byte(`{"key": "hash", "values": [1], "ttl": 60}`)
+	checkRespError(t, conf, "POST", "list/rpush", jsonPayload, http.StatusBadRequest, ERR_CODE_WRONG_TYPE)
+}
+
 /* helpers */
 
+func checkRespJSON(t *testing.T, conf *utils.Config, method, endpoint string, jsonPayload []byte, expHTTPCode int, decoded interface{}) {
+	code, body, err := makeRequest(conf, method, endpoint, jsonPayload)
+	if err != nil {
+		t.Errorf("make request: %s", err)
+	}
+	if err := json.Unmarshal(body, decoded); err != nil {
+		t.Errorf("decoding response: %s", err)
+	}
+	if code != expHTTPCode {
+		t.Errorf("unexpected response status => code: %d, body: %s", code, body)
+	}
+}
+
 func checkRespError(t *testing.T, conf *utils.Config, method, endpoint string, jsonPayload []byte, expHTTPCode, expErrCode int) {
 	code, body, err := makeRequest(conf, method, endpoint, jsonPayload)
 	if err != nil {
@@ -206,6 +454,8 @@ func getTestConfig(numShards int, routePrefix string) *utils.Config {
 		Storage: utils.StorageSettings{
 			NumShards:               numShards,
 			ExpiredKeyCheckInterval: 10},
+		Limits: utils.LimitsSettings{
+			KeyChars: "printable"},
 		ClientHTTP: utils.ClientHTTPSettings{
 			Port:        "12345",
 			RoutePrefix: routePrefix,
@@ -214,13 +464,46 @@ func getTestConfig(numShards int, routePrefix string) *utils.Config {
 
 func startTestServer(conf *utils.Config) *http.Server {
 	utils.SetupLoggers(conf)
-	_, store := replicator.RunReplicator(conf)
+	rep, _, err := replicator.RunReplicator(conf)
+	if err != nil {
+		panic(err)
+	}
+	broker := pubsub.NewBroker(conf)
 	stopCh := make(chan struct{})
-	return StartClientREST(conf, store, stopCh)
+	auth, err := NewAuthenticator(conf)
+	if err != nil {
+		panic(err)
+	}
+	srv, err := StartClientREST(conf, rep, broker, auth, stopCh)
+	if err != nil {
+		panic(err)
+	}
+	// keep-alive connections to previous test server are dead
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	return srv
+}
+
+func runTestReplicator(t *testing.T, conf *utils.Config) (*replicator.Replicator, storage.Store) {
+	rep, store, err := replicator.RunReplicator(conf)
+	if err != nil {
+		t.Fatalf("run replicator: %s", err)
+	}
+	return rep, store
 }
 
 // return status code, raw body and error
 func makeRequest(conf *utils.Config, method, endpoint string, jsonPayload []byte) (int, []byte, error) {
+	return makeRequestAuth(conf, "", method, endpoint, jsonPayload)
+}
+
+func makeRequestAuth(conf *utils.Config, token, method, endpoint string, jsonPayload []byte) (int, []byte, error) {
+	if token != "" {
+		return makeRequestHeader(conf, "Authorization", "Bearer "+token, method, endpoint, jsonPayload)
+	}
+	return makeRequestHeader(conf, "", "", method, endpoint, jsonPayload)
+}
+
+func makeRequestHeader(conf *utils.Config, header, value, method, endpoint string, jsonPayload []byte) (int, []byte, error) {
 	var req *http.Request
 	url := buildURL(conf, endpoint)
 	if jsonPayload != nil {
@@ -230,6 +513,9 @@ func makeRequest(conf *utils.Config, method, endpoint string, jsonPayload []byte
 		req, _ = http.NewRequest(method, url, nil)
 	}
 	req.Header.Set("Accept", "application/json")
+	if header != "" {
+		req.Header.Set(header, value)
+	}
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -0,0 +1,71 @@
+package client_rest
+
+import (
+	"github.com/dgtony/gcache/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec(
+		"gcache_http_requests_total",
+		"REST requests by route and status code.",
+		"route", "code")
+	httpDuration = metrics.NewHistogramVec(
+		"gcache_http_request_duration_seconds",
+		"REST request latency by route.",
+		metrics.DefBuckets,
+		"route")
+	recoveredPanics = metrics.NewCounterVec(
+		"gcache_http_panics_total",
+		"Handler panics turned into internal error responses, by route.",
+		"route")
+)
+
+func init() {
+	metrics.Default.MustRegister(httpRequests, httpDuration, recoveredPanics)
+}
+
+// record request latency and response status under given route name
+func wrapMetrics(next http.Handler, routeName string) http.Handler {
+	latency := httpDuration.With(routeName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		latency.Observe(time.Since(start).Seconds())
+		httpRequests.With(routeName, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+// ResponseWriter keeping status code, still usable for streaming
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// allows http.ResponseController to reach connection
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
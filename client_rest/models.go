@@ -2,22 +2,34 @@ package client_rest
 
 import (
 	"encoding/json"
+	"time"
 )
 
 const (
 	// general errors
 	ERR_CODE_ENDPOINT_NOT_FOUND = 1
 	ERR_CODE_BAD_REQ            = 2
+	ERR_CODE_UNAUTHORIZED       = 3
+	ERR_CODE_FORBIDDEN          = 4
+	ERR_CODE_BAD_CONFIG         = 5
+	ERR_CODE_INTERNAL           = 6
+	ERR_CODE_UNKNOWN_NAMESPACE  = 7
 
 	// request format errors
 	ERR_CODE_NO_KEY_PROVIDED   = 10
 	ERR_CODE_NO_VALUE_PROVIDED = 11
 	ERR_CODE_BAD_KEY_TTL       = 12
 	ERR_CODE_BAD_KEY_MASK      = 13
+	ERR_CODE_NO_CHANNEL        = 14
+	ERR_CODE_BAD_CHANNEL_MASK  = 15
+	ERR_CODE_KEY_TOO_LONG      = 16
+	ERR_CODE_VALUE_TOO_BIG     = 17
+	ERR_CODE_BAD_KEY_CHARS     = 18
 
 	// response errors
 	ERR_CODE_NO_VALUE_FOUND = 21
 	ERR_CODE_CANNOT_SET_KEY = 22
+	ERR_CODE_WRONG_TYPE     = 23
 )
 
 type CacheItem struct {
@@ -33,7 +45,216 @@ type KeysModel struct {
 	Keys []string `json:"keys"`
 }
 
+type TypeModel struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+}
+
+// key metadata, like Redis OBJECT
+type ObjectModel struct {
+	Key      string     `json:"key"`
+	Type     string     `json:"type,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	Accessed *time.Time `json:"accessed,omitempty"`
+	Hits     int64      `json:"hits"`
+	// stored bytes of value or structure elements
+	Size       int  `json:"size"`
+	Compressed bool `json:"compressed,omitempty"`
+	// seconds left before expiration
+	TTL int `json:"ttl"`
+}
+
+// response on complex value modification
+type StructureUpdate struct {
+	Key string `json:"key"`
+	// new length for lists, number of added elements for other types
+	Count int `json:"count"`
+}
+
+type ListModel struct {
+	Key    string            `json:"key"`
+	Values []json.RawMessage `json:"values"`
+	Start  int               `json:"start,omitempty"`
+	// last element by default
+	Stop *int `json:"stop,omitempty"`
+	TTL  int  `json:"ttl,omitempty"`
+}
+
+type HashModel struct {
+	Key    string                     `json:"key"`
+	Field  string                     `json:"field,omitempty"`
+	Value  json.RawMessage            `json:"value,omitempty"`
+	Fields map[string]json.RawMessage `json:"fields,omitempty"`
+	TTL    int                        `json:"ttl,omitempty"`
+}
+
+type SetModel struct {
+	Key      string   `json:"key"`
+	Member   string   `json:"member,omitempty"`
+	Members  []string `json:"members,omitempty"`
+	IsMember *bool    `json:"is_member,omitempty"`
+	TTL      int      `json:"ttl,omitempty"`
+}
+
+type ZSetMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+type ZSetModel struct {
+	Key     string       `json:"key"`
+	Members []ZSetMember `json:"members"`
+	// score range, unlimited by default
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	TTL int      `json:"ttl,omitempty"`
+}
+
 type ErrorResponse struct {
 	Code   int    `json:"code"`
 	Reason string `json:"reason"`
 }
+
+type PublishRequest struct {
+	Channel string          `json:"channel"`
+	Message json.RawMessage `json:"message"`
+}
+
+type PublishResponse struct {
+	Channel   string `json:"channel"`
+	Receivers int    `json:"receivers"`
+}
+
+type ChannelMessage struct {
+	Channel string          `json:"channel"`
+	Pattern string          `json:"pattern,omitempty"`
+	Message json.RawMessage `json:"message"`
+}
+
+type HealthModel struct {
+	Status string `json:"status"`
+	Role   string `json:"role,omitempty"`
+	// why node is not ready
+	Reason string `json:"reason,omitempty"`
+}
+
+/* admin models */
+
+type InfoModel struct {
+	Role      string    `json:"role"`
+	StartTime time.Time `json:"start_time"`
+	// seconds
+	Uptime      int64           `json:"uptime"`
+	Config      ConfigSummary   `json:"config"`
+	Storage     StorageInfo     `json:"storage"`
+	Replication ReplicationInfo `json:"replication"`
+}
+
+type ConfigSummary struct {
+	Shards              int    `json:"shards"`
+	KeyExpCheckInterval int    `json:"key_exp_check_interval"`
+	DumpUpdatePeriod    int    `json:"dump_update_period"`
+	SaveToFile          bool   `json:"save_to_file"`
+	CacheFile           string `json:"cache_file,omitempty"`
+	ClientAddr          string `json:"client_addr"`
+	ClientTLS           bool   `json:"client_tls"`
+	ReplicationTLS      bool   `json:"replication_tls"`
+	AuthEnabled         bool   `json:"auth_enabled"`
+}
+
+// Bulk removal request and result, namespace is optional.
+type FlushModel struct {
+	Mask      string `json:"mask,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Removed   int    `json:"removed"`
+}
+
+// Storage memory analysis, sizes are estimated in bytes.
+type AnalysisModel struct {
+	Keys     int               `json:"keys"`
+	Bytes    int               `json:"bytes"`
+	Biggest  []KeyStatModel    `json:"biggest_keys"`
+	Prefixes []PrefixStatModel `json:"prefixes"`
+	TTL      []TTLBucketModel  `json:"ttl"`
+	// reported with sampling only
+	Hottest []KeyStatModel `json:"hottest_keys,omitempty"`
+	Sampled int            `json:"sampled_keys,omitempty"`
+}
+
+type KeyStatModel struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Type      string `json:"type"`
+	Bytes     int    `json:"bytes"`
+	Hits      int64  `json:"hits"`
+	// seconds left before expiration
+	TTL int `json:"ttl"`
+}
+
+type PrefixStatModel struct {
+	Namespace string `json:"namespace,omitempty"`
+	Prefix    string `json:"prefix"`
+	Keys      int    `json:"keys"`
+	Bytes     int    `json:"bytes"`
+}
+
+type TTLBucketModel struct {
+	// upper bound in seconds, omitted for the last bucket
+	MaxTTL *int `json:"max_ttl,omitempty"`
+	Keys   int  `json:"keys"`
+	Bytes  int  `json:"bytes"`
+}
+
+type StorageInfo struct {
+	Keys         int   `json:"keys"`
+	KeysPerShard []int `json:"keys_per_shard"`
+	// default namespace has empty name
+	KeysPerNamespace map[string]int `json:"keys_per_namespace"`
+	// estimated size of keys and values, bytes
+	MemoryEstimate int `json:"memory_estimate"`
+}
+
+type ReplicationInfo struct {
+	LastDumpTime *time.Time `json:"last_dump_time,omitempty"`
+	LastDumpSize int        `json:"last_dump_size"`
+	// slave only
+	MasterAddr        string     `json:"master_addr,omitempty"`
+	LastSync          *time.Time `json:"last_sync,omitempty"`
+	MasterConnected   *bool      `json:"master_connected,omitempty"`
+	DisconnectedSince *time.Time `json:"disconnected_since,omitempty"`
+	ReconnectAttempts int        `json:"reconnect_attempts,omitempty"`
+	// master only
+	Slaves []SlaveInfo `json:"slaves,omitempty"`
+}
+
+type SlaveInfo struct {
+	Addr            string    `json:"addr"`
+	ProtocolVersion uint8     `json:"protocol_version"`
+	ConnectedAt     time.Time `json:"connected_at"`
+	LastRequest     time.Time `json:"last_request"`
+}
+
+type SlowLogModel struct {
+	// microseconds, disabled if negative
+	Threshold int64          `json:"threshold"`
+	Len       int            `json:"len"`
+	Entries   []SlowLogEntry `json:"entries"`
+}
+
+type SlowLogEntry struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	// microseconds
+	Duration   int64  `json:"duration"`
+	Source     string `json:"source"`
+	Operation  string `json:"operation"`
+	Key        string `json:"key,omitempty"`
+	ClientAddr string `json:"client_addr,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+type ReloadModel struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
@@ -0,0 +1,81 @@
+package client_rest
+
+import (
+	"context"
+	"github.com/dgtony/gcache/storage"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+)
+
+// request namespace is selected by header or query parameter, default one otherwise
+const (
+	NAMESPACE_HEADER = "X-Namespace"
+	NAMESPACE_PARAM  = "namespace"
+)
+
+// Namespace of request with its settings.
+type namespaceEnv struct {
+	keyspace *storage.Namespace
+	// seconds, zero means TTL is required
+	defaultTTL int
+}
+
+// configured namespaces by name, default one included
+type namespaces map[string]*namespaceEnv
+
+func newNamespaces(conf *utils.Config, store storage.Store) namespaces {
+	ns := namespaces{storage.DEFAULT_NAMESPACE: {keyspace: storage.NewNamespace(store, storage.DEFAULT_NAMESPACE)}}
+	for _, s := range conf.Storage.Namespaces {
+		ns[s.Name] = &namespaceEnv{keyspace: storage.NewNamespace(store, s.Name), defaultTTL: s.DefaultTTL}
+	}
+	return ns
+}
+
+// select namespace of request, unknown ones and ones out of token scope are rejected
+func wrapNamespace(next http.Handler, ns namespaces) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(NAMESPACE_HEADER)
+		if name == "" {
+			name = r.URL.Query().Get(NAMESPACE_PARAM)
+		}
+		// checked before existence, so that other namespaces are not disclosed
+		if identity := GetIdentityFromContext(r.Context()); identity != nil && !identity.NamespaceAllowed(name) {
+			sendErrorResponse(w, http.StatusForbidden, ERR_CODE_FORBIDDEN, "namespace is out of token scope")
+			return
+		}
+		env, ok := ns[name]
+		if !ok {
+			sendErrorResponse(w, http.StatusNotFound, ERR_CODE_UNKNOWN_NAMESPACE, "unknown namespace")
+			return
+		}
+		setRequestNamespace(r, name)
+		ctx := context.WithValue(r.Context(), CTX_NAMESPACE_KEY, env)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// keyspace of request namespace, not found outside of data handlers
+func GetKeyspaceFromContext(ctx context.Context) (storage.Keyspace, bool) {
+	env, ok := ctx.Value(CTX_NAMESPACE_KEY).(*namespaceEnv)
+	if !ok {
+		return nil, false
+	}
+	return env.keyspace, true
+}
+
+// keyspace of request, internal error is sent if handler is not wrapped with namespace
+func requestKeyspace(w http.ResponseWriter, r *http.Request) (storage.Keyspace, bool) {
+	store, ok := GetKeyspaceFromContext(r.Context())
+	if !ok {
+		sendMissingContext(w, r, "namespace")
+	}
+	return store, ok
+}
+
+// key TTL in seconds, namespace default is used if TTL is omitted
+func requestTTL(r *http.Request, ttl int) int {
+	if env, ok := r.Context().Value(CTX_NAMESPACE_KEY).(*namespaceEnv); ok && ttl == 0 {
+		return env.defaultTTL
+	}
+	return ttl
+}
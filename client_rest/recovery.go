@@ -0,0 +1,35 @@
+package client_rest
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+/*
+Turn handler panic into internal error response, so that
+single bad request doesn't bring the whole node down.
+Must be placed inside wrapRequestID to log request ID.
+*/
+func wrapRecovery(next http.Handler, routeName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				// deliberate abort, let net/http handle it
+				panic(err)
+			}
+			requestLogger(w).Errorf("%s handler panic: %v\n%s", routeName, err, debug.Stack())
+			recoveredPanics.With(routeName).Inc()
+			if sw.wroteHeader {
+				// response already started, nothing to fix
+				return
+			}
+			sendErrorResponse(sw, http.StatusInternalServerError, ERR_CODE_INTERNAL, "internal server error")
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,139 @@
+package client_rest
+
+import (
+	"github.com/dgtony/gcache/replicator"
+	"github.com/dgtony/gcache/slowlog"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// settings applied to running node, others require restart
+var liveSettings = map[string]bool{
+	"general.log_level":                  true,
+	"general.log_format":                 true,
+	"general.log_out":                    true,
+	"general.log_file":                   true,
+	"general.log_max_size":               true,
+	"general.log_max_backups":            true,
+	"general.log_modules":                true,
+	"storage.key_exp_check_interval":     true,
+	"replication.dump_update_period":     true,
+	"replication.file_write_period":      true,
+	"replication.master_secret":          true,
+	"replication.master_secret_previous": true,
+	"replication.secret_grace_period":    true,
+	"auth.enabled":                       true,
+	"auth.hmac_secret":                   true,
+	"auth.tokens":                        true,
+	"slowlog.enabled":                    true,
+	"slowlog.threshold":                  true,
+	"slowlog.max_len":                    true}
+
+type ReloadReport struct {
+	// changed settings in form "section.key"
+	Applied         []string
+	RestartRequired []string
+}
+
+/*
+Reloader keeps current node configuration
+and applies changes from config file to running node.
+*/
+type Reloader struct {
+	confFile  string
+	overrides utils.Overrides
+	conf      *utils.Config
+	rep       *replicator.Replicator
+	auth      *Authenticator
+	sync.Mutex
+}
+
+func NewReloader(confFile string, overrides utils.Overrides, conf *utils.Config, rep *replicator.Replicator, auth *Authenticator) *Reloader {
+	return &Reloader{confFile: confFile, overrides: overrides, conf: conf, rep: rep, auth: auth}
+}
+
+// configuration in effect
+func (r *Reloader) Config() *utils.Config {
+	r.Lock()
+	defer r.Unlock()
+	return r.conf
+}
+
+// Read config file and environment again and apply changes.
+func (r *Reloader) Reload() (*ReloadReport, error) {
+	conf, err := utils.ReadConfig(r.confFile, r.overrides)
+	if err != nil {
+		return nil, err
+	}
+	return r.Apply(conf)
+}
+
+// Apply live settings from given config, settings requiring
+// restart are reported and stay unchanged in current config.
+func (r *Reloader) Apply(conf *utils.Config) (*ReloadReport, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	report := &ReloadReport{Applied: []string{}, RestartRequired: []string{}}
+	for _, name := range utils.ChangedSettings(r.conf, conf) {
+		if liveSettings[name] {
+			report.Applied = append(report.Applied, name)
+		} else {
+			report.RestartRequired = append(report.RestartRequired, name)
+			utils.CopySetting(conf, r.conf, name)
+		}
+	}
+
+	// everything which could fail is built first, so that failed reload changes nothing
+	tokens, err := buildTokens(conf)
+	if err != nil {
+		return nil, err
+	}
+	logBackend, err := utils.NewLogBackend(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	r.auth.set(conf, tokens)
+	logBackend.Install()
+	configureSlowLog(conf)
+	r.rep.Store.SetSweepInterval(time.Duration(conf.Storage.ExpiredKeyCheckInterval) * time.Second)
+	r.rep.SetPeriods(
+		time.Duration(conf.Replication.DumpUpdatePeriod)*time.Second,
+		time.Duration(conf.Replication.FileWritePeriod)*time.Second)
+
+	rc, old := conf.Replication, r.conf.Replication
+	if rc.MasterSecret != old.MasterSecret || rc.MasterSecretPrevious != old.MasterSecretPrevious || rc.SecretGracePeriod != old.SecretGracePeriod {
+		r.rep.Secrets.Update(rc.MasterSecret, rc.MasterSecretPrevious, time.Duration(rc.SecretGracePeriod)*time.Second)
+	}
+
+	r.conf = conf
+	return report, nil
+}
+
+func configureSlowLog(conf *utils.Config) {
+	threshold := time.Duration(-1)
+	if conf.SlowLog.Enabled {
+		threshold = time.Duration(conf.SlowLog.Threshold) * time.Microsecond
+	}
+	slowlog.Default.Configure(threshold, conf.SlowLog.MaxLen)
+}
+
+/* admin handlers */
+
+func ReloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	reloader, ok := requestReloader(w, r)
+	if !ok {
+		return
+	}
+	report, err := reloader.Reload()
+	if err != nil {
+		requestLogger(w).Errorf("cannot reload configuration: %s", err)
+		sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_CONFIG, err.Error())
+		return
+	}
+	requestLogger(w).Infof("configuration reloaded, applied: %v, restart required: %v", report.Applied, report.RestartRequired)
+	sendJSONResponse(w, http.StatusOK, &ReloadModel{Applied: report.Applied, RestartRequired: report.RestartRequired})
+}
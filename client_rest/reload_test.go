@@ -0,0 +1,148 @@
+package client_rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgtony/gcache/slowlog"
+	"github.com/dgtony/gcache/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientRESTReloadApply(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	rep, _ := runTestReplicator(t, conf)
+	auth, _ := NewAuthenticator(conf)
+	reloader := NewReloader("", nil, conf, rep, auth)
+	defer slowlog.Default.Configure(-1, slowlog.DEFAULT_MAX_LEN)
+
+	updated := getTestConfig(4, "test")
+	updated.Auth = getTestAuthConfig().Auth
+	updated.Storage.ExpiredKeyCheckInterval = 3
+	updated.Replication.DumpUpdatePeriod = 5
+	updated.SlowLog = utils.SlowLogSettings{Enabled: true, Threshold: 100, MaxLen: 8}
+
+	report, err := reloader.Apply(updated)
+	if err != nil {
+		t.Fatalf("apply config: %s", err)
+	}
+	expApplied := []string{"auth.enabled", "auth.hmac_secret", "auth.tokens", "replication.dump_update_period",
+		"slowlog.enabled", "slowlog.max_len", "slowlog.threshold", "storage.key_exp_check_interval"}
+	if !reflect.DeepEqual(report.Applied, expApplied) || !reflect.DeepEqual(report.RestartRequired, []string{"storage.shards"}) {
+		t.Errorf("unexpected reload report: %+v", report)
+	}
+
+	// live settings are in effect
+	if !auth.Enabled() || rep.Store.SweepInterval() != 3*time.Second || slowlog.Default.Threshold() != 100*time.Microsecond {
+		t.Error("live settings are not applied")
+	}
+	if dumpUpdate, _ := rep.Periods(); dumpUpdate != 5*time.Second {
+		t.Errorf("unexpected dump update period: %s", dumpUpdate)
+	}
+	// restart required settings stay unchanged
+	if reloader.Config().Storage.NumShards != 2 {
+		t.Errorf("number of shards changed: %d", reloader.Config().Storage.NumShards)
+	}
+
+	// bad settings are rejected as a whole
+	bad := getTestConfig(2, "test")
+	bad.Auth = getTestAuthConfig().Auth
+	bad.Auth.Tokens[0].Permission = "superuser"
+	if _, err := reloader.Apply(bad); err == nil {
+		t.Error("no error for bad auth settings")
+	}
+	if reloader.Config().Storage.ExpiredKeyCheckInterval != 3 {
+		t.Error("config replaced after failed reload")
+	}
+}
+
+func TestClientRESTReloadFailedLoggers(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	rep, _ := runTestReplicator(t, conf)
+	auth, _ := NewAuthenticator(conf)
+	reloader := NewReloader("", nil, conf, rep, auth)
+
+	// valid auth settings are not applied when log file can't be opened
+	updated := getTestConfig(2, "test")
+	updated.Auth = getTestAuthConfig().Auth
+	updated.General.LogOut = "file"
+	updated.General.LogFile = "/nonexistent/gcache/gcache.log"
+	if _, err := reloader.Apply(updated); err == nil {
+		t.Fatal("no error for log file in missing directory")
+	}
+	auth.RLock()
+	tokens := len(auth.tokens)
+	auth.RUnlock()
+	if auth.Enabled() || tokens != 0 {
+		t.Errorf("auth settings changed after failed reload: enabled %t, %d tokens", auth.Enabled(), tokens)
+	}
+	if reloader.Config() != conf {
+		t.Error("config replaced after failed reload")
+	}
+}
+
+func TestClientRESTAdminReloadConfig(t *testing.T) {
+	confFile, err := ioutil.TempFile("", "gcache-config")
+	if err != nil {
+		t.Fatalf("create config file: %s", err)
+	}
+	defer os.Remove(confFile.Name())
+	writeTestConfigFile(t, confFile.Name(), 10, "12346")
+
+	conf, err := utils.ReadConfig(confFile.Name(), nil)
+	if err != nil {
+		t.Fatalf("read config: %s", err)
+	}
+	utils.SetupLoggers(conf)
+	rep, _ := runTestReplicator(t, conf)
+	admin := NewAdminRouter(NewReloader(confFile.Name(), nil, conf, rep, &Authenticator{}))
+	defer slowlog.Default.Configure(-1, slowlog.DEFAULT_MAX_LEN)
+
+	writeTestConfigFile(t, confFile.Name(), 7, "12347")
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("POST", "/config/reload", nil))
+
+	var resp ReloadModel
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("cannot reload config => status: %d, error: %v", w.Code, err)
+	}
+	if !reflect.DeepEqual(resp.Applied, []string{"storage.key_exp_check_interval"}) ||
+		!reflect.DeepEqual(resp.RestartRequired, []string{"client-HTTP.port"}) {
+		t.Errorf("unexpected reload response: %+v", resp)
+	}
+
+	// unreadable config
+	os.Remove(confFile.Name())
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("POST", "/config/reload", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status for missing config: %d", w.Code)
+	}
+}
+
+/* helpers */
+
+func writeTestConfigFile(t *testing.T, name string, expCheckInterval int, port string) {
+	data := fmt.Sprintf(`
+[general]
+log_level = "debug"
+
+[storage]
+shards = 2
+key_exp_check_interval = %d
+
+[client-HTTP]
+port = "%s"
+prefix = "test"
+`, expCheckInterval, port)
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatalf("write config file: %s", err)
+	}
+}
@@ -0,0 +1,82 @@
+package client_rest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+	"time"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+	// longer client IDs are replaced
+	REQUEST_ID_MAX_LEN = 128
+)
+
+// ResponseWriter carrying logger of current request
+type requestWriter struct {
+	http.ResponseWriter
+	log *utils.RequestLogger
+}
+
+func (w *requestWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *requestWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+/*
+Assign ID to request, taken from X-Request-ID header if present,
+and echo it in response. ID is added to all log records of the request.
+*/
+func wrapRequestID(next http.Handler, routeName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+
+		rw := &requestWriter{ResponseWriter: w, log: utils.NewRequestLogger("REST", requestID)}
+		next.ServeHTTP(rw, r)
+		rw.log.Debugf("%s %s %s from %s in %s", routeName, r.Method, r.URL.Path, r.RemoteAddr, time.Since(start))
+	})
+}
+
+// logger of current request, plain module logger outside request middleware
+func requestLogger(w http.ResponseWriter) *utils.RequestLogger {
+	for {
+		switch rw := w.(type) {
+		case *requestWriter:
+			return rw.log
+		case *statusWriter:
+			w = rw.ResponseWriter
+		default:
+			return utils.NewRequestLogger("REST", "")
+		}
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > REQUEST_ID_MAX_LEN {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
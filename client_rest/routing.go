@@ -2,6 +2,7 @@ package client_rest
 
 import (
 	"context"
+	"github.com/dgtony/gcache/pubsub"
 	"github.com/dgtony/gcache/storage"
 	"github.com/dgtony/gcache/utils"
 	"github.com/gorilla/mux"
@@ -10,14 +11,27 @@ import (
 )
 
 const (
-	CTX_STORAGE_KEY = 1
+	CTX_STORAGE_KEY  = 1
+	CTX_BROKER_KEY   = 2
+	CTX_IDENTITY_KEY = 3
+	CTX_REQUEST_KEY  = 6
+	// data endpoints only
+	CTX_NAMESPACE_KEY = 7
+	// admin endpoints only
+	CTX_REPLICATOR_KEY = 4
+	CTX_RELOADER_KEY   = 5
 )
 
 type Route struct {
-	Name     string
-	Method   string
-	Pattern  string
-	HandlerF http.HandlerFunc
+	Name    string
+	Method  string
+	Pattern string
+	// route changes stored data
+	Mutating bool
+	// permission required, by default read-write for mutating routes
+	// and read-only for others
+	Permission Permission
+	HandlerF   http.HandlerFunc
 }
 
 type Routes []Route
@@ -33,19 +47,105 @@ var routes = Routes{
 		Name:     "SetItem",
 		Method:   "POST",
 		Pattern:  "item",
+		Mutating: true,
 		HandlerF: SetItemHandler},
 
 	Route{
 		Name:     "RemoveItem",
 		Method:   "DELETE",
 		Pattern:  "item",
+		Mutating: true,
 		HandlerF: RemoveItemHandler},
 
 	Route{
 		Name:     "GetKeys",
 		Method:   "GET",
 		Pattern:  "keys",
-		HandlerF: GetKeysHandler}}
+		HandlerF: GetKeysHandler},
+
+	Route{
+		Name:     "GetType",
+		Method:   "GET",
+		Pattern:  "type",
+		HandlerF: GetTypeHandler},
+
+	Route{
+		Name:     "GetObject",
+		Method:   "GET",
+		Pattern:  "object",
+		HandlerF: GetObjectHandler},
+
+	Route{
+		Name:     "LPush",
+		Method:   "POST",
+		Pattern:  "list/lpush",
+		Mutating: true,
+		HandlerF: LPushHandler},
+
+	Route{
+		Name:     "RPush",
+		Method:   "POST",
+		Pattern:  "list/rpush",
+		Mutating: true,
+		HandlerF: RPushHandler},
+
+	Route{
+		Name:     "LRange",
+		Method:   "GET",
+		Pattern:  "list",
+		HandlerF: LRangeHandler},
+
+	Route{
+		Name:     "HSet",
+		Method:   "POST",
+		Pattern:  "hash",
+		Mutating: true,
+		HandlerF: HSetHandler},
+
+	Route{
+		Name:     "HGet",
+		Method:   "GET",
+		Pattern:  "hash",
+		HandlerF: HGetHandler},
+
+	Route{
+		Name:     "SAdd",
+		Method:   "POST",
+		Pattern:  "set",
+		Mutating: true,
+		HandlerF: SAddHandler},
+
+	Route{
+		Name:     "SGet",
+		Method:   "GET",
+		Pattern:  "set",
+		HandlerF: SGetHandler},
+
+	Route{
+		Name:     "ZAdd",
+		Method:   "POST",
+		Pattern:  "zset",
+		Mutating: true,
+		HandlerF: ZAddHandler},
+
+	Route{
+		Name:     "ZRangeByScore",
+		Method:   "GET",
+		Pattern:  "zset",
+		HandlerF: ZRangeByScoreHandler},
+
+	Route{
+		Name:       "Publish",
+		Method:     "POST",
+		Pattern:    "publish",
+		Permission: PERM_READ_WRITE,
+		HandlerF:   PublishHandler},
+
+	Route{
+		Name:     "Subscribe",
+		Method:   "GET",
+		Pattern:  "subscribe",
+		HandlerF: SubscribeHandler}}
 
 func supplementRoute(route string, conf *utils.Config) string {
 	var elems []string
@@ -59,28 +159,94 @@ func supplementRoute(route string, conf *utils.Config) string {
 	return strings.Join(elems, "/")
 }
 
-func wrapContextEnv(next http.HandlerFunc, store *storage.ConcurrentMap) http.Handler {
+func wrapContextEnv(next http.HandlerFunc, store storage.Store, broker *pubsub.Broker) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, CTX_STORAGE_KEY, store)
+		ctx = context.WithValue(ctx, CTX_BROKER_KEY, broker)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// check client credentials and permission before request processing
+func wrapAuth(next http.Handler, auth *Authenticator, perm Permission) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// could be switched on config reload
+		if !auth.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity, err := auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			sendErrorResponse(w, http.StatusUnauthorized, ERR_CODE_UNAUTHORIZED, err.Error())
+			return
+		}
+		if identity.Permission < perm {
+			sendErrorResponse(w, http.StatusForbidden, ERR_CODE_FORBIDDEN, "permission denied")
+			return
+		}
+		setRequestIdentity(r, identity)
+		ctx := context.WithValue(r.Context(), CTX_IDENTITY_KEY, identity)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func GetStorageFromContext(ctx context.Context) *storage.ConcurrentMap {
-	return ctx.Value(CTX_STORAGE_KEY).(*storage.ConcurrentMap)
+// return nil if authentication is disabled
+func GetIdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(CTX_IDENTITY_KEY).(*Identity)
+	return identity
+}
+
+func GetStorageFromContext(ctx context.Context) (storage.Store, bool) {
+	store, ok := ctx.Value(CTX_STORAGE_KEY).(storage.Store)
+	return store, ok
 }
 
-func NewRouter(conf *utils.Config, store *storage.ConcurrentMap) *mux.Router {
+func GetBrokerFromContext(ctx context.Context) (*pubsub.Broker, bool) {
+	broker, ok := ctx.Value(CTX_BROKER_KEY).(*pubsub.Broker)
+	return broker, ok
+}
+
+// storage of request, internal error is sent if handler is not wrapped with environment
+func requestStorage(w http.ResponseWriter, r *http.Request) (storage.Store, bool) {
+	store, ok := GetStorageFromContext(r.Context())
+	if !ok {
+		sendMissingContext(w, r, "storage")
+	}
+	return store, ok
+}
+
+func requestBroker(w http.ResponseWriter, r *http.Request) (*pubsub.Broker, bool) {
+	broker, ok := GetBrokerFromContext(r.Context())
+	if !ok {
+		sendMissingContext(w, r, "broker")
+	}
+	return broker, ok
+}
+
+// handler environment is missing, that's a routing bug
+func sendMissingContext(w http.ResponseWriter, r *http.Request, what string) {
+	requestLogger(w).Errorf("no %s in context of %s %s", what, r.Method, r.URL.Path)
+	sendErrorResponse(w, http.StatusInternalServerError, ERR_CODE_INTERNAL, "internal server error")
+}
+
+func NewRouter(conf *utils.Config, store storage.Store, broker *pubsub.Broker, auth *Authenticator) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
+	ns := newNamespaces(conf, store)
 	for _, route := range routes {
 		// disable data changing endpoints on slave nodes
-		if conf.Replication.NodeRole == "slave" && (route.Name == "SetItem" || route.Name == "RemoveItem") {
+		if conf.Replication.NodeRole == "slave" && route.Mutating {
 			continue
 		}
 
 		var handler http.HandlerFunc = route.HandlerF
-		wrapped := wrapContextEnv(handler, store)
+		wrapped := wrapAuth(wrapNamespace(wrapContextEnv(handler, store, broker), ns), auth, requiredPermission(route))
+		wrapped = wrapRequestID(wrapRecovery(wrapped, route.Name), route.Name)
+		if route.Mutating {
+			wrapped = wrapAudit(wrapped, route.Name)
+		}
+		wrapped = wrapMetrics(wrapSlowLog(wrapped, route.Name), route.Name)
 		fullRoute := supplementRoute(route.Pattern, conf)
 
 		router.
@@ -90,6 +256,16 @@ func NewRouter(conf *utils.Config, store *storage.ConcurrentMap) *mux.Router {
 			Handler(wrapped)
 	}
 
-	router.NotFoundHandler = http.HandlerFunc(ResourceNotFound)
+	router.NotFoundHandler = wrapMetrics(wrapRequestID(http.HandlerFunc(ResourceNotFound), "NotFound"), "NotFound")
 	return router
 }
+
+func requiredPermission(route Route) Permission {
+	if route.Permission != PERM_NONE {
+		return route.Permission
+	}
+	if route.Mutating {
+		return PERM_READ_WRITE
+	}
+	return PERM_READ
+}
@@ -1,7 +1,12 @@
 package client_rest
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -35,3 +40,117 @@ func TestClientRESTRoutingSupplementRoute(t *testing.T) {
 		}
 	}
 }
+
+func TestClientRESTRequestID(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	_, store := runTestReplicator(t, conf)
+	router := NewRouter(conf, store, nil, &Authenticator{})
+
+	// generated
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/test/keys", nil))
+	generated := w.Header().Get(REQUEST_ID_HEADER)
+	if len(generated) != 16 {
+		t.Errorf("unexpected generated request ID: %q", generated)
+	}
+
+	// taken from client
+	for _, path := range []string{"/test/keys", "/test/unknown"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set(REQUEST_ID_HEADER, "client-id-1")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if id := w.Header().Get(REQUEST_ID_HEADER); id != "client-id-1" {
+			t.Errorf("client request ID is not echoed for %s: %q", path, id)
+		}
+	}
+
+	// bad client ID is replaced
+	r := httptest.NewRequest("GET", "/test/keys", nil)
+	r.Header.Set(REQUEST_ID_HEADER, "bad id")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if id := w.Header().Get(REQUEST_ID_HEADER); id == "bad id" || id == generated || len(id) != 16 {
+		t.Errorf("bad client request ID is not replaced: %q", id)
+	}
+}
+
+func TestClientRESTRecovery(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler bug")
+	})
+	handler := wrapMetrics(wrapRequestID(wrapRecovery(panicking, "Panic"), "Panic"), "Panic")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status => expected: %d, got: %d", http.StatusInternalServerError, w.Code)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != ERR_CODE_INTERNAL {
+		t.Errorf("unexpected error response: %s", w.Body.String())
+	}
+	if w.Header().Get(REQUEST_ID_HEADER) == "" {
+		t.Error("request ID is not set on recovered request")
+	}
+
+	// response already started
+	partial := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("handler bug")
+	})
+	w = httptest.NewRecorder()
+	wrapRecovery(partial, "Panic").ServeHTTP(w, httptest.NewRequest("GET", "/test/panic", nil))
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Errorf("started response is changed: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestClientRESTMissingContext(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	utils.SetupLoggers(conf)
+	testCases := []struct {
+		Name    string
+		Handler http.HandlerFunc
+		Target  string
+		Body    string
+	}{
+		{"GetKeys", GetKeysHandler, "/keys", ""},
+		{"Publish", PublishHandler, "/publish", `{"channel":"news","message":"hello"}`},
+		{"Subscribe", SubscribeHandler, "/subscribe?channel=news", ""},
+		{"Flush", FlushHandler, "/flush", ""},
+		{"RemoveKeys", RemoveKeysHandler, "/keys", `{"mask":"*"}`},
+		{"Metrics", MetricsHandler, "/metrics", ""},
+		{"Info", InfoHandler, "/info", ""},
+		{"Analyze", AnalyzeHandler, "/analyze", ""},
+		{"ReloadConfig", ReloadConfigHandler, "/reload", ""},
+		{"Readiness", ReadinessHandler, "/health/ready", ""},
+	}
+	for _, c := range testCases {
+		// handler fails without panic
+		recovered := recoveredPanics.With(c.Name).Value()
+		w := httptest.NewRecorder()
+		wrapRecovery(c.Handler, c.Name).ServeHTTP(w, httptest.NewRequest("POST", c.Target, strings.NewReader(c.Body)))
+		var resp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusInternalServerError || resp.Code != ERR_CODE_INTERNAL || recoveredPanics.With(c.Name).Value() != recovered {
+			t.Errorf("unexpected response of %s handler without context: %d %s", c.Name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestClientRESTStartupError(t *testing.T) {
+	conf := getTestConfig(2, "test")
+	conf.ClientHTTP.Port = "12346"
+	srv := startTestServer(conf)
+	defer srv.Close()
+
+	rep, _ := runTestReplicator(t, conf)
+	_, err := StartClientREST(conf, rep, nil, &Authenticator{}, make(chan struct{}))
+	if startErr, ok := err.(*StartupError); !ok || startErr.Server != "client" {
+		t.Errorf("expected client startup error on busy port, got: %v", err)
+	}
+}
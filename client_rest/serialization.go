@@ -38,10 +38,26 @@ func writeKeysResponse(w io.Writer, keys *KeysModel) bool {
 	return true
 }
 
+// decode request of any model
+func readRequest(r io.Reader, req interface{}) bool {
+	if err := json.NewDecoder(r).Decode(req); err != nil {
+		return false
+	}
+	return true
+}
+
+func readPublishRequest(r io.Reader) (*PublishRequest, bool) {
+	var req PublishRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return nil, false
+	}
+	return &req, true
+}
+
 /* additional methods */
 
 // get value list item with index
-func GetListItem(s *storage.ConcurrentMap, key string, subIndex int) ([]byte, bool) {
+func GetListItem(s storage.Keyspace, key string, subIndex int) ([]byte, bool) {
 	if subIndex < 0 {
 		return nil, false
 	}
@@ -72,7 +88,7 @@ func GetListItem(s *storage.ConcurrentMap, key string, subIndex int) ([]byte, bo
 }
 
 // get value dictionary item with key
-func GetDictItem(s *storage.ConcurrentMap, key, subKey string) ([]byte, bool) {
+func GetDictItem(s storage.Keyspace, key, subKey string) ([]byte, bool) {
 	res, ok := s.Get(key)
 	if !ok {
 		return nil, false
@@ -1,22 +1,48 @@
 package client_rest
 
 import (
-	"github.com/dgtony/gcache/storage"
+	"fmt"
+	"github.com/dgtony/gcache/pubsub"
+	"github.com/dgtony/gcache/replicator"
 	"github.com/dgtony/gcache/utils"
 	"github.com/op/go-logging"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
 var logger *logging.Logger
 
-func StartClientREST(conf *utils.Config, store *storage.ConcurrentMap, stopCh chan struct{}) *http.Server {
-	logger = utils.GetLogger("REST")
+// loggers are shared by all nodes in process
+var loggerOnce sync.Once
+
+func init_logger() {
+	loggerOnce.Do(func() { logger = utils.GetLogger("REST") })
+}
+
+// REST server cannot be started, e.g. address in use or bad TLS files.
+type StartupError struct {
+	Server string
+	Addr   string
+	Err    error
+}
+
+func (e *StartupError) Error() string {
+	return fmt.Sprintf("cannot start %s server at %s: %s", e.Server, e.Addr, e.Err)
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
+
+func StartClientREST(conf *utils.Config, rep *replicator.Replicator, broker *pubsub.Broker, auth *Authenticator, stopCh chan struct{}) (*http.Server, error) {
+	init_logger()
 
 	serverAddr := net.JoinHostPort(conf.ClientHTTP.Addr, conf.ClientHTTP.Port)
-	logger.Infof("client started at %s", serverAddr)
-	router := NewRouter(conf, store)
+
+	router := NewRouter(conf, rep.Store, broker, auth)
+	addHealthRoutes(router, rep)
 
 	srv := &http.Server{
 		Handler:      router,
@@ -24,12 +50,55 @@ func StartClientREST(conf *utils.Config, store *storage.ConcurrentMap, stopCh ch
 		ReadTimeout:  time.Duration(conf.ClientHTTP.IdleTimeout) * time.Second,
 		WriteTimeout: time.Duration(conf.ClientHTTP.IdleTimeout) * time.Second}
 
+	tlsEnabled := conf.ClientHTTP.TLSCert != ""
+	var reloader *utils.CertReloader
+	if tlsEnabled {
+		tlsConf, r, err := utils.ServerTLSConfig(conf.ClientHTTP.TLSCert, conf.ClientHTTP.TLSKey, "", false)
+		if err != nil {
+			return nil, &StartupError{Server: "client", Addr: serverAddr, Err: err}
+		}
+		srv.TLSConfig = tlsConf
+		reloader = r
+		// stop watching certificate files with server
+		srv.RegisterOnShutdown(reloader.Close)
+	}
+
+	if err := serve(srv, "client", tlsEnabled, stopCh); err != nil {
+		reloader.Close()
+		return nil, err
+	}
+	return srv, nil
+}
+
+// Listen synchronously, so that bind errors are reported to caller, and serve in background.
+func serve(srv *http.Server, name string, tlsEnabled bool, stopCh chan struct{}) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return &StartupError{Server: name, Addr: srv.Addr, Err: err}
+	}
+	logger.Infof("%s server started at %s", name, srv.Addr)
+
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			logger.Warningf("client stopped, reason: %s", err)
-			stopCh <- struct{}{}
+		var err error
+		if tlsEnabled {
+			// certificate is provided by TLS config
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err == http.ErrServerClosed {
+			// shut down deliberately
+			logger.Infof("%s server at %s is shut down", name, srv.Addr)
+			return
+		}
+		if err != nil {
+			logger.Warningf("%s server stopped, reason: %s", name, err)
+			// single signal is enough, other failed server doesn't wait for the receiver
+			select {
+			case stopCh <- struct{}{}:
+			default:
+			}
 		}
 	}()
-
-	return srv
+	return nil
 }
@@ -0,0 +1,103 @@
+package client_rest
+
+import (
+	"context"
+	"github.com/dgtony/gcache/slowlog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// request details collected during processing
+type requestInfo struct {
+	key string
+	// glob mask of bulk removal
+	mask string
+	// empty for default namespace
+	namespace string
+	// set by authentication
+	identity *Identity
+	// size of value stored, -1 if unknown
+	valueSize int
+}
+
+// record slow requests under given route name
+func wrapSlowLog(next http.Handler, routeName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{valueSize: -1}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), CTX_REQUEST_KEY, info)))
+		slowlog.Default.RecordRequest(slowlog.SOURCE_REST, routeName, info.key, r.RemoteAddr,
+			w.Header().Get(REQUEST_ID_HEADER), start)
+	})
+}
+
+func setRequestKey(r *http.Request, key string) {
+	if info, ok := r.Context().Value(CTX_REQUEST_KEY).(*requestInfo); ok {
+		info.key = key
+	}
+}
+
+func setRequestMask(r *http.Request, mask string) {
+	if info, ok := r.Context().Value(CTX_REQUEST_KEY).(*requestInfo); ok {
+		info.mask = mask
+	}
+}
+
+func setRequestNamespace(r *http.Request, name string) {
+	if info, ok := r.Context().Value(CTX_REQUEST_KEY).(*requestInfo); ok {
+		info.namespace = name
+	}
+}
+
+func setRequestValueSize(r *http.Request, size int) {
+	if info, ok := r.Context().Value(CTX_REQUEST_KEY).(*requestInfo); ok {
+		info.valueSize = size
+	}
+}
+
+func setRequestIdentity(r *http.Request, identity *Identity) {
+	if info, ok := r.Context().Value(CTX_REQUEST_KEY).(*requestInfo); ok {
+		info.identity = identity
+	}
+}
+
+/* admin handlers */
+
+// return latest slow log entries, number of entries is limited with 'count' query parameter
+func SlowLogHandler(w http.ResponseWriter, r *http.Request) {
+	count := 0
+	if param := r.URL.Query().Get("count"); param != "" {
+		var err error
+		if count, err = strconv.Atoi(param); err != nil || count < 0 {
+			sendErrorResponse(w, http.StatusBadRequest, ERR_CODE_BAD_REQ, "bad entries count")
+			return
+		}
+	}
+
+	entries := slowlog.Default.Entries(count)
+	resp := SlowLogModel{
+		Threshold: int64(slowlog.Default.Threshold() / time.Microsecond),
+		Len:       slowlog.Default.Len(),
+		Entries:   make([]SlowLogEntry, len(entries))}
+	if resp.Threshold < 0 {
+		resp.Threshold = -1
+	}
+	for i, e := range entries {
+		resp.Entries[i] = SlowLogEntry{
+			ID:         e.ID,
+			Time:       e.Time,
+			Duration:   int64(e.Duration / time.Microsecond),
+			Source:     e.Source,
+			Operation:  e.Operation,
+			Key:        e.Key,
+			ClientAddr: e.ClientAddr,
+			RequestID:  e.RequestID}
+	}
+	sendJSONResponse(w, http.StatusOK, &resp)
+}
+
+func ResetSlowLogHandler(w http.ResponseWriter, r *http.Request) {
+	slowlog.Default.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package gcache
+
+import (
+	"github.com/dgtony/gcache/utils"
+	"net"
+	"strconv"
+)
+
+/*
+Option changes node settings. Options are applied on top of defaults,
+config file and GCACHE_* environment variables, in given order.
+*/
+type Option func(*Server) error
+
+// Read settings from TOML config file, it's re-read on configuration reload.
+func WithConfigFile(name string) Option {
+	return func(s *Server) error {
+		s.confFile = name
+		return nil
+	}
+}
+
+// Set settings by name in form "section.key", e.g. "storage.shards".
+func WithSettings(settings utils.Overrides) Option {
+	return func(s *Server) error {
+		for name, value := range settings {
+			s.overrides[name] = value
+		}
+		return nil
+	}
+}
+
+// Set single setting by name in form "section.key".
+func WithSetting(name, value string) Option {
+	return WithSettings(utils.Overrides{name: value})
+}
+
+// Node role: standalone, master or slave.
+func WithRole(role string) Option {
+	return WithSetting("replication.node_role", role)
+}
+
+// Storage engine: sharded or rcu, see storage.Store.
+func WithStorageEngine(engine string) Option {
+	return WithSetting("storage.engine", engine)
+}
+
+func WithShards(n int) Option {
+	return WithSetting("storage.shards", strconv.Itoa(n))
+}
+
+/*
+Replication address with shared secret: master listens for slaves
+at the address, while slave pulls snapshots from master there.
+*/
+func WithMaster(addr, secret string) Option {
+	return WithSettings(utils.Overrides{
+		"replication.master_addr":   addr,
+		"replication.master_secret": secret})
+}
+
+// Save snapshots to given file and restore storage from it on start.
+func WithPersistence(file string) Option {
+	return WithSettings(utils.Overrides{
+		"replication.cache_file":        file,
+		"replication.save_to_file":      "true",
+		"replication.restore_from_file": "true"})
+}
+
+// Serve REST API at given "host:port" address.
+func WithClientAddr(addr string) Option {
+	return func(s *Server) error {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		s.noClient = false
+		s.overrides["client-HTTP.address"] = host
+		s.overrides["client-HTTP.port"] = port
+		return nil
+	}
+}
+
+// Don't serve REST API, storage is accessed in-process only.
+func WithoutClient() Option {
+	return func(s *Server) error {
+		s.noClient = true
+		return nil
+	}
+}
+
+// Serve administrative endpoints at given "host:port" address,
+// admin server is disabled by default.
+func WithAdminAddr(addr string) Option {
+	return func(s *Server) error {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		s.overrides["admin.enabled"] = "true"
+		s.overrides["admin.address"] = host
+		s.overrides["admin.port"] = port
+		return nil
+	}
+}
+
+// Disable admin server enabled in config file.
+func WithoutAdmin() Option {
+	return WithSetting("admin.enabled", "false")
+}
+
+// Log level of all node modules: debug, info, notice, warning, error or critical.
+func WithLogLevel(level string) Option {
+	return WithSetting("general.log_level", level)
+}
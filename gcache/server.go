@@ -0,0 +1,366 @@
+/*
+Package gcache runs cache node in-process, e.g. embedded in other service
+or in integration tests. Node is configured with options instead of config file:
+
+	srv, err := gcache.New(gcache.WithRole("standalone"), gcache.WithoutClient())
+	...
+	if err := srv.Start(ctx); err != nil {
+		...
+	}
+	defer srv.Stop(context.Background())
+	srv.Storage().Set("key", []byte(`"value"`), time.Minute)
+
+Loggers, metrics, slow log and audit log are process-wide, so they are
+shared by all nodes running in the same process.
+*/
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dgtony/gcache/audit"
+	"github.com/dgtony/gcache/client_rest"
+	"github.com/dgtony/gcache/pubsub"
+	"github.com/dgtony/gcache/replicator"
+	"github.com/dgtony/gcache/slowlog"
+	"github.com/dgtony/gcache/storage"
+	"github.com/dgtony/gcache/utils"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStarted    = errors.New("node is already started")
+	ErrNotStarted = errors.New("node is not started")
+)
+
+// replaced in tests
+var runReplicator = replicator.RunReplicator
+
+// Cache node with explicit Start/Stop lifecycle.
+type Server struct {
+	confFile  string
+	overrides utils.Overrides
+	noClient  bool
+	conf      *utils.Config
+
+	// running node
+	rep      *replicator.Replicator
+	broker   *pubsub.Broker
+	reloader *client_rest.Reloader
+	client   *http.Server
+	admin    *http.Server
+	auditLog *audit.Log
+	stopCh   chan struct{}
+	done     chan struct{}
+	errs     chan error
+	// set while replication is starting without lock held
+	cancelStart context.CancelFunc
+	// closed when Start returns
+	startDone chan struct{}
+	mu        sync.Mutex
+}
+
+/*
+Create node with given options on top of default settings.
+Settings are validated, but nothing is started yet.
+*/
+func New(opts ...Option) (*Server, error) {
+	s := &Server{overrides: utils.Overrides{}}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	conf, err := utils.ReadConfig(s.confFile, s.overrides)
+	if err != nil {
+		return nil, err
+	}
+	s.conf = conf
+	return s, nil
+}
+
+/*
+Start storage, replication and servers. Slave node makes initial sync
+with master in background, see Readiness. Startup, e.g. restoring large
+snapshot file, is interrupted when context is done or Stop is called,
+then the context error is returned. Node could not be restarted after Stop.
+*/
+func (s *Server) Start(ctx context.Context) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rep != nil || s.cancelStart != nil {
+		return ErrStarted
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Stop interrupting startup waits until started parts are released
+	startDone := make(chan struct{})
+	s.startDone = startDone
+	defer close(startDone)
+	conf := s.conf
+
+	if err := utils.SetupLoggers(conf); err != nil {
+		return fmt.Errorf("cannot setup logging: %s", err)
+	}
+	// ignored while reading config, before logging is set up
+	for _, name := range utils.UnknownEnvVars(os.Environ()) {
+		utils.GetLogger("Cache").Warningf("unknown setting in environment variable %s is ignored", name)
+	}
+	if conf.SlowLog.Enabled {
+		threshold := time.Duration(conf.SlowLog.Threshold) * time.Microsecond
+		slowlog.Default.Configure(threshold, conf.SlowLog.MaxLen)
+	}
+
+	// release started parts on failure
+	defer func() {
+		if err != nil {
+			s.shutdown(context.Background())
+			s.rep = nil
+		}
+	}()
+
+	if conf.Audit.Enabled {
+		if s.auditLog, err = openAuditLog(conf); err != nil {
+			return fmt.Errorf("cannot open audit log: %s", err)
+		}
+		audit.Default = s.auditLog
+	}
+
+	auth, err := client_rest.NewAuthenticator(conf)
+	if err != nil {
+		return fmt.Errorf("bad authentication settings: %s", err)
+	}
+
+	rep, err := s.startReplicator(ctx, conf)
+	if err != nil {
+		return err
+	}
+	s.rep = rep
+	s.broker = pubsub.NewBroker(conf)
+	s.reloader = client_rest.NewReloader(s.confFile, s.overrides, conf, rep, auth)
+	// client and admin servers report failure without waiting for watcher
+	s.stopCh = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	s.errs = make(chan error, 1)
+
+	if !s.noClient {
+		if s.client, err = client_rest.StartClientREST(conf, rep, s.broker, auth, s.stopCh); err != nil {
+			return err
+		}
+	}
+	if conf.Admin.Enabled {
+		if s.admin, err = client_rest.StartAdminServer(s.reloader, s.stopCh); err != nil {
+			return err
+		}
+	}
+
+	go s.watch()
+	return nil
+}
+
+/*
+Gracefully shut down servers, waiting for active requests until
+context is done, then stop replication. Stored data is still accessible.
+*/
+func (s *Server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.cancelStart != nil {
+		// interrupted startup releases started parts itself
+		s.cancelStart()
+		startDone := s.startDone
+		s.mu.Unlock()
+		select {
+		case <-startDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer s.mu.Unlock()
+	if s.rep == nil {
+		return ErrNotStarted
+	}
+	return s.shutdown(ctx)
+}
+
+// Storage of running node, nil before start.
+func (s *Server) Storage() Storage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rep == nil {
+		return nil
+	}
+	return s.rep.Store
+}
+
+/*
+Keyspace of named namespace, nil before start. Storage returned by Storage()
+holds keys of all namespaces, while keys of namespace view are isolated
+from others. Limits are applied to namespaces listed in config only.
+*/
+func (s *Server) Namespace(name string) Storage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rep == nil {
+		return nil
+	}
+	return storage.NewNamespace(s.rep.Store, name)
+}
+
+// Configuration in effect.
+func (s *Server) Config() *utils.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reloader != nil {
+		return s.reloader.Config()
+	}
+	return s.conf
+}
+
+// Read config file and environment again, applying options on top, see client_rest.Reloader.
+func (s *Server) Reload() (*client_rest.ReloadReport, error) {
+	s.mu.Lock()
+	reloader := s.reloader
+	s.mu.Unlock()
+	if reloader == nil {
+		return nil, ErrNotStarted
+	}
+	return reloader.Reload()
+}
+
+// Replication status of running node.
+func (s *Server) Status() (replicator.Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rep == nil {
+		return replicator.Status{}, ErrNotStarted
+	}
+	return s.rep.Status(), nil
+}
+
+// Nil if node is ready to serve clients, the reason otherwise.
+func (s *Server) Readiness() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rep == nil {
+		return ErrNotStarted
+	}
+	return s.rep.Readiness()
+}
+
+/*
+Fatal failure of running node: server stopped unexpectedly or slave gave up
+on master. Only the first failure is reported, node should be stopped then.
+*/
+func (s *Server) Errors() <-chan error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.errs
+}
+
+/* internals */
+
+/*
+Run replicator with lock released, so that Stop could interrupt startup.
+Interrupted replicator is abandoned and stopped as soon as it's started.
+*/
+func (s *Server) startReplicator(ctx context.Context, conf *utils.Config) (*replicator.Replicator, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.cancelStart = cancel
+	s.mu.Unlock()
+
+	type result struct {
+		rep *replicator.Replicator
+		err error
+	}
+	started := make(chan result, 1)
+	go func() {
+		rep, _, err := runReplicator(conf)
+		started <- result{rep, err}
+	}()
+
+	var res result
+	select {
+	case res = <-started:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+		go func() {
+			if r := <-started; r.err == nil {
+				r.rep.Stop()
+			}
+		}()
+	}
+
+	s.mu.Lock()
+	s.cancelStart = nil
+	if res.err == nil && ctx.Err() != nil {
+		res.rep.Stop()
+		return nil, ctx.Err()
+	}
+	return res.rep, res.err
+}
+
+func (s *Server) watch() {
+	var err error
+	select {
+	case <-s.stopCh:
+		err = errors.New("REST server stopped")
+	case repErr := <-s.rep.Fatal():
+		err = fmt.Errorf("replication failed: %s", repErr)
+	case <-s.done:
+		return
+	}
+	s.errs <- err
+}
+
+// stop everything started, lock must be held
+func (s *Server) shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, srv := range []*http.Server{s.client, s.admin} {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			// drop long-living connections, e.g. event streams
+			srv.Close()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	s.client, s.admin = nil, nil
+
+	if s.rep != nil {
+		s.rep.Stop()
+	}
+	if s.done != nil {
+		select {
+		case <-s.done:
+		default:
+			close(s.done)
+		}
+	}
+	if s.auditLog != nil {
+		if audit.Default == s.auditLog {
+			audit.Default = nil
+		}
+		s.auditLog.Close()
+		s.auditLog = nil
+	}
+	return firstErr
+}
+
+func openAuditLog(conf *utils.Config) (*audit.Log, error) {
+	ac := conf.Audit
+	out, err := utils.OpenRotatingFile(ac.File, int64(ac.MaxSize)*1024*1024, ac.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return audit.New(out, ac.Keys, ac.QueueSize)
+}
@@ -0,0 +1,187 @@
+package gcache
+
+import (
+	"context"
+	"github.com/dgtony/gcache/replicator"
+	"github.com/dgtony/gcache/storage"
+	"github.com/dgtony/gcache/utils"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerEmbedded(t *testing.T) {
+	srv, err := New(WithShards(4), WithoutClient(), WithLogLevel("error"))
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	if srv.Config().Admin.Enabled {
+		t.Error("admin server is enabled by default")
+	}
+	if srv.Storage() != nil {
+		t.Error("storage is available before start")
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	if err := srv.Start(context.Background()); err != ErrStarted {
+		t.Errorf("second start is not rejected: %v", err)
+	}
+
+	if srv.admin != nil {
+		t.Error("admin server is started without opt-in")
+	}
+
+	store := srv.Storage()
+	store.Set("key", []byte(`"value"`), time.Minute)
+	if value, ok := store.Get("key"); !ok || string(value) != `"value"` {
+		t.Errorf("unexpected value: %s", value)
+	}
+	if _, err := store.HGetAll("key"); err != ErrWrongType {
+		t.Errorf("expected wrong type error, got: %v", err)
+	}
+	if srv.Config().Storage.NumShards != 4 {
+		t.Errorf("option is not applied: %d shards", srv.Config().Storage.NumShards)
+	}
+
+	if err := srv.Stop(context.Background()); err != nil {
+		t.Errorf("stop: %s", err)
+	}
+	if _, ok := srv.Storage().Get("key"); !ok {
+		t.Error("data is not accessible after stop")
+	}
+}
+
+func TestServerStartInterrupted(t *testing.T) {
+	srv, err := New(WithShards(4), WithoutClient(), WithLogLevel("error"))
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := srv.Start(ctx); err != context.Canceled {
+		t.Errorf("start with cancelled context: %v", err)
+	}
+
+	// replication startup blocks until released
+	entered, release := make(chan struct{}), make(chan struct{})
+	runReplicator = func(conf *utils.Config) (*replicator.Replicator, storage.Store, error) {
+		close(entered)
+		<-release
+		return replicator.RunReplicator(conf)
+	}
+	defer func() { runReplicator = replicator.RunReplicator }()
+	defer close(release)
+
+	started := make(chan error, 1)
+	go func() { started <- srv.Start(context.Background()) }()
+	<-entered
+	if err := srv.Start(context.Background()); err != ErrStarted {
+		t.Errorf("start during startup is not rejected: %v", err)
+	}
+	if srv.Storage() != nil {
+		t.Error("storage is available during startup")
+	}
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+	defer stopCancel()
+	if err := srv.Stop(stopCtx); err != nil {
+		t.Errorf("stop during startup: %s", err)
+	}
+	if err := <-started; err != context.Canceled {
+		t.Errorf("unexpected error of interrupted start: %v", err)
+	}
+	if srv.Storage() != nil {
+		t.Error("storage is available after interrupted start")
+	}
+}
+
+func TestServerBadOptions(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithRole("leader")},
+		{WithShards(0)},
+		{WithSetting("storage.unknown", "1")},
+		{WithClientAddr("no-port")},
+	} {
+		if _, err := New(opts...); err == nil {
+			t.Errorf("bad options are accepted: %d", len(opts))
+		}
+	}
+}
+
+func TestServerLifecycle(t *testing.T) {
+	opts := []Option{
+		WithClientAddr("127.0.0.1:12360"),
+		WithAdminAddr("127.0.0.1:12361"),
+		WithSetting("client-HTTP.prefix", "test"),
+		WithLogLevel("error")}
+
+	// ports are released on stop
+	for i := 0; i < 2; i++ {
+		srv, err := New(opts...)
+		if err != nil {
+			t.Fatalf("new server: %s", err)
+		}
+		if err := srv.Start(context.Background()); err != nil {
+			t.Fatalf("start #%d: %s", i, err)
+		}
+		srv.Storage().Set("key", []byte(`"value"`), time.Minute)
+
+		for _, url := range []string{"http://127.0.0.1:12360/test/keys", "http://127.0.0.1:12361/health/ready"} {
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Fatalf("request %s: %s", url, err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("unexpected response from %s: %d %s", url, resp.StatusCode, body)
+			}
+		}
+
+		if err := srv.Stop(context.Background()); err != nil {
+			t.Errorf("stop: %s", err)
+		}
+		http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	}
+}
+
+func TestServerReplication(t *testing.T) {
+	master, err := New(
+		WithRole("master"),
+		WithMaster("127.0.0.1:12362", "secret"),
+		WithSetting("replication.dump_update_period", "1"),
+		WithoutClient(), WithoutAdmin(), WithLogLevel("error"))
+	if err != nil {
+		t.Fatalf("new master: %s", err)
+	}
+	if err := master.Start(context.Background()); err != nil {
+		t.Fatalf("start master: %s", err)
+	}
+	defer master.Stop(context.Background())
+	master.Storage().Set("key", []byte(`"value"`), time.Minute)
+	time.Sleep(1100 * time.Millisecond)
+
+	slave, err := New(
+		WithRole("slave"),
+		WithMaster("127.0.0.1:12362", "secret"),
+		WithoutClient(), WithoutAdmin(), WithLogLevel("error"))
+	if err != nil {
+		t.Fatalf("new slave: %s", err)
+	}
+	if err := slave.Start(context.Background()); err != nil {
+		t.Fatalf("start slave: %s", err)
+	}
+	defer slave.Stop(context.Background())
+	// initial sync is made in background
+	for i := 0; i < 100 && slave.Readiness() != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if value, ok := slave.Storage().Get("key"); !ok || string(value) != `"value"` {
+		t.Errorf("value is not replicated: %s", value)
+	}
+	if err := slave.Readiness(); err != nil {
+		t.Errorf("slave is not ready: %s", err)
+	}
+}
@@ -0,0 +1,28 @@
+package gcache
+
+import (
+	"github.com/dgtony/gcache/storage"
+)
+
+type (
+	ValueType = storage.ValueType
+	ZMember   = storage.ZMember
+	KeyInfo   = storage.KeyInfo
+)
+
+var (
+	ErrWrongType    = storage.ErrWrongType
+	ErrInvalidKey   = storage.ErrInvalidKey
+	ErrInvalidValue = storage.ErrInvalidValue
+	ErrEmptyKey     = storage.ErrEmptyKey
+	ErrKeyTooLong   = storage.ErrKeyTooLong
+	ErrKeyChars     = storage.ErrKeyChars
+	ErrValueTooBig  = storage.ErrValueTooBig
+)
+
+/*
+Storage of running node for in-process access. Changes made on master
+or standalone node are visible to REST clients and replicated as usual,
+while changes on slave node are overwritten with the next master snapshot.
+*/
+type Storage = storage.Keyspace
@@ -1,53 +1,127 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"github.com/dgtony/gcache/client_rest"
-	"github.com/dgtony/gcache/replicator"
+	"github.com/dgtony/gcache/gcache"
+	"github.com/dgtony/gcache/storage"
 	"github.com/dgtony/gcache/utils"
 	"github.com/op/go-logging"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
 	// profiling
 	//"net/http"
 	//_ "net/http/pprof"
 )
 
+// wait for active requests on termination
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 var logger *logging.Logger
 
+// unexpected panic outside request handlers
 func catch_err() {
 	if err := recover(); err != nil {
-		fmt.Printf("program error occured => %s\n", err)
+		fmt.Fprintf(os.Stderr, "gcache: program error occured => %s\n%s", err, debug.Stack())
+		os.Exit(2)
 	}
 }
 
 func main() {
 	defer catch_err()
 
-	confFile := flag.String("c", "config.toml", "path to config file")
+	if err := run(); err != nil {
+		if logger != nil {
+			logger.Criticalf("cache server failed: %s", err)
+		}
+		fmt.Fprintf(os.Stderr, "gcache: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run cache node until it stops, errors are fatal
+func run() error {
+	confFile := flag.String("c", "config.toml", "path to config file, empty to use defaults")
+	overrides := utils.ConfigFlags(flag.CommandLine)
+	analyzeFile := flag.String("analyze", "", "analyze snapshot file and print report instead of running cache")
+	analyzeOpts := storage.AnalyzeOptions{}
+	flag.IntVar(&analyzeOpts.Top, "analyze-top", storage.ANALYZE_DEFAULT_TOP, "number of largest and hottest keys and prefixes reported")
+	flag.StringVar(&analyzeOpts.Delimiter, "analyze-delimiter", storage.ANALYZE_DEFAULT_DELIMITER, "key prefix delimiter")
+	flag.Float64Var(&analyzeOpts.HotSample, "analyze-sample", 0, "fraction of keys sampled for hottest keys, 0 to disable")
 	flag.Parse()
 
+	// offline mode
+	if *analyzeFile != "" {
+		return analyzeSnapshot(*analyzeFile, analyzeOpts)
+	}
+
 	// get configuration
-	config, err := utils.ReadConfig(*confFile)
+	srv, err := gcache.New(gcache.WithConfigFile(*confFile), gcache.WithSettings(overrides))
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// setup loggers
-	utils.SetupLoggers(config)
-	logger = utils.GetLogger("Cache")
-	logger.Infof("starting cache, node role: %s", config.Replication.NodeRole)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	// run replicator and core storage
-	_, store := replicator.RunReplicator(config)
+	// run replicator, core storage and clients
+	logger = utils.GetLogger("Cache")
+	startCtx, cancelStart := context.WithCancel(context.Background())
+	go func() {
+		// termination interrupts startup, signal is handled again if node is started already
+		select {
+		case sig := <-sigCh:
+			cancelStart()
+			sigCh <- sig
+		case <-startCtx.Done():
+		}
+	}()
+	err = srv.Start(startCtx)
+	cancelStart()
+	if err != nil {
+		return err
+	}
+	logger.Infof("cache started, node role: %s", srv.Config().Replication.NodeRole)
 
-	stopCh := make(chan struct{})
-	// run clients
-	_ = client_rest.StartClientREST(config, store, stopCh)
+	// reload configuration on SIGHUP
+	go handleReload(srv)
 
 	// profiling
 	//go http.ListenAndServe("0.0.0.0:7878", nil)
 
-	// wait for all clients to stop
-	<-stopCh
-	logger.Info("cache server stopped")
+	// wait for termination or failure
+	select {
+	case sig := <-sigCh:
+		logger.Infof("got %s, stopping cache server", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := srv.Stop(ctx); err != nil {
+			logger.Warningf("cache server stopped abruptly: %s", err)
+		}
+		logger.Info("cache server stopped")
+		return nil
+	case err := <-srv.Errors():
+		srv.Stop(context.Background())
+		return err
+	}
+}
+
+func handleReload(srv *gcache.Server) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		report, err := srv.Reload()
+		if err != nil {
+			logger.Errorf("cannot reload configuration: %s", err)
+			continue
+		}
+		logger.Infof("configuration reloaded, applied: %v", report.Applied)
+		if len(report.RestartRequired) > 0 {
+			logger.Warningf("settings changed, restart required: %v", report.RestartRequired)
+		}
+	}
 }
@@ -0,0 +1,398 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Minimal instrumentation toolkit exposing metrics
+in Prometheus text format (version 0.0.4).
+*/
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+
+	// content type of exposition format
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// default latency buckets, seconds
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry collecting all node metrics
+var Default = NewRegistry()
+
+type Collector interface {
+	Name() string
+	// write metric family in text format
+	Collect(w io.Writer) error
+}
+
+type Registry struct {
+	collectors map[string]Collector
+	sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// panic on duplicate metric names, as it is a programming error
+func (r *Registry) MustRegister(collectors ...Collector) {
+	r.Lock()
+	defer r.Unlock()
+	for _, c := range collectors {
+		if _, ok := r.collectors[c.Name()]; ok {
+			panic(fmt.Sprintf("metric %s is already registered", c.Name()))
+		}
+		r.collectors[c.Name()] = c
+	}
+}
+
+// write all registered metrics ordered by name
+func (r *Registry) Collect(w io.Writer) error {
+	r.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	r.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.RLock()
+		c := r.collectors[name]
+		r.RUnlock()
+		if err := c.Collect(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* counter */
+
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+type CounterVec struct {
+	family
+	vec
+}
+
+// metric without labels, exposed from the start
+func NewCounter(name, help string) *CounterVec {
+	v := NewCounterVec(name, help)
+	v.With()
+	return v
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		family: family{name: name, help: help, typ: TYPE_COUNTER, labelNames: labelNames},
+		vec:    vec{newMetric: func() interface{} { return &Counter{} }}}
+}
+
+// counter for given label values, created on first use
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.with(labelValues).(*Counter)
+}
+
+func (v *CounterVec) Collect(w io.Writer) error {
+	if err := v.writeHeader(w); err != nil {
+		return err
+	}
+	return v.collect(w, func(w io.Writer, labels []string, m interface{}) error {
+		return v.writeSample(w, "", labels, float64(m.(*Counter).Value()))
+	})
+}
+
+/* gauge */
+
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+type GaugeVec struct {
+	family
+	vec
+}
+
+// metric without labels, exposed from the start
+func NewGauge(name, help string) *GaugeVec {
+	v := NewGaugeVec(name, help)
+	v.With()
+	return v
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{
+		family: family{name: name, help: help, typ: TYPE_GAUGE, labelNames: labelNames},
+		vec:    vec{newMetric: func() interface{} { return &Gauge{} }}}
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.with(labelValues).(*Gauge)
+}
+
+func (v *GaugeVec) Collect(w io.Writer) error {
+	if err := v.writeHeader(w); err != nil {
+		return err
+	}
+	return v.collect(w, func(w io.Writer, labels []string, m interface{}) error {
+		return v.writeSample(w, "", labels, m.(*Gauge).Value())
+	})
+}
+
+/* histogram */
+
+type Histogram struct {
+	// upper bounds in ascending order
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     Gauge
+}
+
+func (h *Histogram) Observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			atomic.AddUint64(&h.counts[i], 1)
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(value)
+}
+
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+func (h *Histogram) Sum() float64 {
+	return h.sum.Value()
+}
+
+type HistogramVec struct {
+	family
+	vec
+	buckets []float64
+}
+
+// metric without labels, exposed from the start
+func NewHistogram(name, help string, buckets []float64) *HistogramVec {
+	v := NewHistogramVec(name, help, buckets)
+	v.With()
+	return v
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	bounds := append([]float64{}, buckets...)
+	sort.Float64s(bounds)
+	return &HistogramVec{
+		family: family{name: name, help: help, typ: TYPE_HISTOGRAM, labelNames: labelNames},
+		vec: vec{newMetric: func() interface{} {
+			return &Histogram{buckets: bounds, counts: make([]uint64, len(bounds))}
+		}},
+		buckets: bounds}
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.with(labelValues).(*Histogram)
+}
+
+func (v *HistogramVec) Collect(w io.Writer) error {
+	if err := v.writeHeader(w); err != nil {
+		return err
+	}
+	bucketLabels := append(append([]string{}, v.labelNames...), "le")
+	return v.collect(w, func(w io.Writer, labels []string, m interface{}) error {
+		h := m.(*Histogram)
+		le := append(append([]string{}, labels...), "")
+		for i, bound := range h.buckets {
+			le[len(le)-1] = formatFloat(bound)
+			if err := v.writeSampleLabels(w, "_bucket", bucketLabels, le, float64(atomic.LoadUint64(&h.counts[i]))); err != nil {
+				return err
+			}
+		}
+		le[len(le)-1] = "+Inf"
+		if err := v.writeSampleLabels(w, "_bucket", bucketLabels, le, float64(h.Count())); err != nil {
+			return err
+		}
+		if err := v.writeSample(w, "_sum", labels, h.Sum()); err != nil {
+			return err
+		}
+		return v.writeSample(w, "_count", labels, float64(h.Count()))
+	})
+}
+
+// n buckets starting from start, each next is factor times greater
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	buckets := make([]float64, n)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+/* internals */
+
+type family struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+func (f *family) Name() string {
+	return f.name
+}
+
+func (f *family) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	return err
+}
+
+func (f *family) writeSample(w io.Writer, suffix string, labelValues []string, value float64) error {
+	return f.writeSampleLabels(w, suffix, f.labelNames, labelValues, value)
+}
+
+func (f *family) writeSampleLabels(w io.Writer, suffix string, labelNames, labelValues []string, value float64) error {
+	var labels string
+	if len(labelNames) > 0 {
+		pairs := make([]string, len(labelNames))
+		for i, name := range labelNames {
+			pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(labelValues[i]))
+		}
+		labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+	_, err := fmt.Fprintf(w, "%s%s%s %s\n", f.name, suffix, labels, formatFloat(value))
+	return err
+}
+
+// metrics indexed by label values, created on first use
+type vec struct {
+	newMetric func() interface{}
+	children  map[string]*vecChild
+	sync.RWMutex
+}
+
+type vecChild struct {
+	labelValues []string
+	metric      interface{}
+}
+
+func (v *vec) with(labelValues []string) interface{} {
+	key := strings.Join(labelValues, "\xff")
+	v.RLock()
+	child, ok := v.children[key]
+	v.RUnlock()
+	if ok {
+		return child.metric
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child.metric
+	}
+	if v.children == nil {
+		v.children = make(map[string]*vecChild)
+	}
+	child = &vecChild{labelValues: append([]string{}, labelValues...), metric: v.newMetric()}
+	v.children[key] = child
+	return child.metric
+}
+
+// remove metric with given label values
+func (v *vec) Delete(labelValues ...string) {
+	v.Lock()
+	delete(v.children, strings.Join(labelValues, "\xff"))
+	v.Unlock()
+}
+
+// remove all metrics
+func (v *vec) Reset() {
+	v.Lock()
+	v.children = nil
+	v.Unlock()
+}
+
+func (v *vec) collect(w io.Writer, write func(io.Writer, []string, interface{}) error) error {
+	v.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	children := make([]*vecChild, len(keys))
+	sort.Strings(keys)
+	for i, key := range keys {
+		children[i] = v.children[key]
+	}
+	v.RUnlock()
+
+	for _, child := range children {
+		if err := write(w, child.labelValues, child.metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	registry := NewRegistry()
+	requests := NewCounterVec("test_requests_total", "Requests by route.", "route", "code")
+	size := NewGauge("test_size_bytes", "Size.")
+	latency := NewHistogram("test_latency_seconds", "Latency.", []float64{1, 0.1})
+	registry.MustRegister(requests, size, latency)
+
+	requests.With("Get\"Item", "200").Add(3)
+	requests.With("GetItem", "404").Inc()
+	size.With().Set(1.5)
+	size.With().Add(-0.5)
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(5)
+
+	var buf bytes.Buffer
+	if err := registry.Collect(&buf); err != nil {
+		t.Fatalf("collect metrics: %s", err)
+	}
+
+	expected := `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 5.55
+test_latency_seconds_count 3
+# HELP test_requests_total Requests by route.
+# TYPE test_requests_total counter
+test_requests_total{route="Get\"Item",code="200"} 3
+test_requests_total{route="GetItem",code="404"} 1
+# HELP test_size_bytes Size.
+# TYPE test_size_bytes gauge
+test_size_bytes 1
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected exposition =>\n%s", buf.String())
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	registry := NewRegistry()
+	registry.MustRegister(NewCounter("test_total", "Test."))
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("no panic on duplicate metric")
+		}
+	}()
+	registry.MustRegister(NewGauge("test_total", "Test."))
+}
+
+func TestMetricsVecDelete(t *testing.T) {
+	v := NewGaugeVec("test_gauge", "Test.", "slave")
+	v.With("a").Set(1)
+	v.With("b").Set(2)
+	v.Delete("a")
+
+	var buf bytes.Buffer
+	v.Collect(&buf)
+	if strings.Contains(buf.String(), `slave="a"`) || !strings.Contains(buf.String(), `test_gauge{slave="b"} 2`) {
+		t.Errorf("unexpected metrics after delete =>\n%s", buf.String())
+	}
+}
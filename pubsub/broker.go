@@ -0,0 +1,161 @@
+package pubsub
+
+import (
+	"github.com/dgtony/gcache/utils"
+	"github.com/gobwas/glob"
+	"github.com/op/go-logging"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// default length of subscriber message queue
+	DEFAULT_QUEUE_SIZE = 256
+	// queue length limit
+	MAX_QUEUE_SIZE = 65536
+)
+
+var logger *logging.Logger
+
+// loggers are shared by all nodes in process
+var loggerOnce sync.Once
+
+func init_logger() {
+	loggerOnce.Do(func() { logger = utils.GetLogger("PubSub") })
+}
+
+type Message struct {
+	Channel string
+	// pattern matched by channel name, empty for plain subscriptions
+	Pattern string
+	Payload []byte
+}
+
+type BrokerStats struct {
+	Channels    int
+	Patterns    int
+	Subscribers int
+	Published   uint64
+	Delivered   uint64
+	Dropped     uint64
+}
+
+/*
+Broker performs fan-out of published messages to subscribers.
+Delivery is non-blocking: if subscriber queue is full message will be dropped
+and counted both in subscriber and broker stats.
+*/
+type Broker struct {
+	queueSize   int
+	channels    map[string]map[*Subscriber]bool
+	patterns    map[string]*patternSubs
+	subscribers map[*Subscriber]bool
+	published   uint64
+	delivered   uint64
+	dropped     uint64
+	sync.RWMutex
+}
+
+type patternSubs struct {
+	matcher     glob.Glob
+	subscribers map[*Subscriber]bool
+}
+
+func NewBroker(conf *utils.Config) *Broker {
+	init_logger()
+
+	queueSize := conf.PubSub.QueueSize
+	if queueSize < 1 || queueSize > MAX_QUEUE_SIZE {
+		queueSize = DEFAULT_QUEUE_SIZE
+	}
+	logger.Debugf("create pub/sub broker, subscriber queue size: %d", queueSize)
+
+	return &Broker{
+		queueSize:   queueSize,
+		channels:    make(map[string]map[*Subscriber]bool),
+		patterns:    make(map[string]*patternSubs),
+		subscribers: make(map[*Subscriber]bool)}
+}
+
+// Send message to all channel and pattern subscribers.
+// Return number of subscribers message was delivered to.
+func (b *Broker) Publish(channel string, payload []byte) int {
+	atomic.AddUint64(&b.published, 1)
+	receivers := 0
+
+	b.RLock()
+	for sub := range b.channels[channel] {
+		if sub.deliver(Message{Channel: channel, Payload: payload}) {
+			receivers++
+		}
+	}
+	for pattern, ps := range b.patterns {
+		if !ps.matcher.Match(channel) {
+			continue
+		}
+		for sub := range ps.subscribers {
+			if sub.deliver(Message{Channel: channel, Pattern: pattern, Payload: payload}) {
+				receivers++
+			}
+		}
+	}
+	b.RUnlock()
+
+	atomic.AddUint64(&b.delivered, uint64(receivers))
+	return receivers
+}
+
+// Create new subscriber without any subscriptions.
+// Subscriber must be closed after use.
+func (b *Broker) NewSubscriber() *Subscriber {
+	sub := &Subscriber{
+		broker:   b,
+		queue:    make(chan Message, b.queueSize),
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool)}
+
+	b.Lock()
+	b.subscribers[sub] = true
+	b.Unlock()
+	return sub
+}
+
+func (b *Broker) Stats() BrokerStats {
+	b.RLock()
+	stats := BrokerStats{
+		Channels:    len(b.channels),
+		Patterns:    len(b.patterns),
+		Subscribers: len(b.subscribers)}
+	b.RUnlock()
+
+	stats.Published = atomic.LoadUint64(&b.published)
+	stats.Delivered = atomic.LoadUint64(&b.delivered)
+	stats.Dropped = atomic.LoadUint64(&b.dropped)
+	return stats
+}
+
+/* internals */
+
+// do not use outside - not thread-safe!
+func (b *Broker) removeChannelSub(channel string, sub *Subscriber) {
+	subs, ok := b.channels[channel]
+	if !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.channels, channel)
+	}
+}
+
+// do not use outside - not thread-safe!
+func (b *Broker) removePatternSub(pattern string, sub *Subscriber) {
+	ps, ok := b.patterns[pattern]
+	if !ok {
+		return
+	}
+	delete(ps.subscribers, sub)
+	if len(ps.subscribers) == 0 {
+		delete(b.patterns, pattern)
+	}
+}
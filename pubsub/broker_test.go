@@ -0,0 +1,136 @@
+package pubsub
+
+import (
+	"github.com/dgtony/gcache/utils"
+	"testing"
+)
+
+var logSetFlag bool
+
+func TestPubSubChannels(t *testing.T) {
+	setup_logger()
+	broker := NewBroker(getTestConfig(4))
+
+	sub1 := broker.NewSubscriber()
+	defer sub1.Close()
+	sub1.Subscribe("news", "weather")
+
+	sub2 := broker.NewSubscriber()
+	defer sub2.Close()
+	sub2.Subscribe("news")
+
+	if n := broker.Publish("news", []byte("hello")); n != 2 {
+		t.Errorf("wrong number of receivers => expected: 2, get: %d", n)
+	}
+	if n := broker.Publish("weather", []byte("rain")); n != 1 {
+		t.Errorf("wrong number of receivers => expected: 1, get: %d", n)
+	}
+	if n := broker.Publish("sports", []byte("goal")); n != 0 {
+		t.Errorf("wrong number of receivers => expected: 0, get: %d", n)
+	}
+
+	checkMessage(t, sub1, Message{Channel: "news", Payload: []byte("hello")})
+	checkMessage(t, sub1, Message{Channel: "weather", Payload: []byte("rain")})
+	checkMessage(t, sub2, Message{Channel: "news", Payload: []byte("hello")})
+
+	// unsubscribe
+	sub1.Unsubscribe("news")
+	if n := broker.Publish("news", []byte("again")); n != 1 {
+		t.Errorf("message delivered after unsubscribe, receivers: %d", n)
+	}
+}
+
+func TestPubSubPatterns(t *testing.T) {
+	setup_logger()
+	broker := NewBroker(getTestConfig(4))
+
+	sub := broker.NewSubscriber()
+	defer sub.Close()
+	if err := sub.PSubscribe("user:*:login"); err != nil {
+		t.Errorf("pattern subscribe: %s", err)
+	}
+	if err := sub.PSubscribe("[bad"); err == nil {
+		t.Error("no error for malformed pattern")
+	}
+
+	broker.Publish("user:42:login", []byte("1"))
+	broker.Publish("user:42:logout", []byte("2"))
+	checkMessage(t, sub, Message{Channel: "user:42:login", Pattern: "user:*:login", Payload: []byte("1")})
+	if len(sub.Messages()) != 0 {
+		t.Error("message delivered for non-matching channel")
+	}
+
+	// channel and pattern subscriptions receive separate copies
+	sub.Subscribe("user:1:login")
+	if n := broker.Publish("user:1:login", []byte("3")); n != 2 {
+		t.Errorf("wrong number of receivers => expected: 2, get: %d", n)
+	}
+
+	sub.PUnsubscribe("user:*:login")
+	if stats := broker.Stats(); stats.Patterns != 0 {
+		t.Errorf("pattern not removed, patterns: %d", stats.Patterns)
+	}
+}
+
+func TestPubSubQueueOverflow(t *testing.T) {
+	setup_logger()
+	queueSize := 4
+	broker := NewBroker(getTestConfig(queueSize))
+
+	sub := broker.NewSubscriber()
+	sub.Subscribe("ch")
+	for i := 0; i < queueSize+3; i++ {
+		broker.Publish("ch", []byte("msg"))
+	}
+
+	if sub.Dropped() != 3 {
+		t.Errorf("wrong subscriber drop counter => expected: 3, get: %d", sub.Dropped())
+	}
+	stats := broker.Stats()
+	if stats.Published != uint64(queueSize+3) || stats.Delivered != uint64(queueSize) || stats.Dropped != 3 {
+		t.Errorf("wrong broker stats: %+v", stats)
+	}
+
+	// queue must be closed with subscriber
+	sub.Close()
+	received := 0
+	for range sub.Messages() {
+		received++
+	}
+	if received != queueSize {
+		t.Errorf("wrong number of queued messages => expected: %d, get: %d", queueSize, received)
+	}
+	if stats := broker.Stats(); stats.Channels != 0 || stats.Subscribers != 0 {
+		t.Errorf("subscriber not removed: %+v", stats)
+	}
+}
+
+/* helpers */
+
+func checkMessage(t *testing.T, sub *Subscriber, expected Message) {
+	select {
+	case msg := <-sub.Messages():
+		if msg.Channel != expected.Channel || msg.Pattern != expected.Pattern ||
+			!utils.CompareByteSlices(msg.Payload, expected.Payload) {
+			t.Errorf("unexpected message => expected: %+v, get: %+v", expected, msg)
+		}
+	default:
+		t.Errorf("message not delivered: %+v", expected)
+	}
+}
+
+func getTestConfig(queueSize int) *utils.Config {
+	return &utils.Config{
+		General: utils.GeneralSettings{
+			LogLevel:  "debug",
+			LogFormat: "short",
+			LogOut:    "stdout"},
+		PubSub: utils.PubSubSettings{
+			QueueSize: queueSize}}
+}
+
+func setup_logger() {
+	if !logSetFlag {
+		utils.SetupLoggers(getTestConfig(1))
+	}
+}
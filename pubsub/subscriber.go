@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"github.com/gobwas/glob"
+	"sync/atomic"
+)
+
+/*
+Subscriber receives messages from subscribed channels and patterns
+through bounded queue. All subscription changes are made under broker lock.
+*/
+type Subscriber struct {
+	broker   *Broker
+	queue    chan Message
+	channels map[string]bool
+	patterns map[string]bool
+	dropped  uint64
+	closed   bool
+}
+
+// Messages returns subscriber queue, it will be closed with subscriber
+func (s *Subscriber) Messages() <-chan Message {
+	return s.queue
+}
+
+// Number of messages dropped due to queue overflow
+func (s *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscriber) Subscribe(channels ...string) {
+	b := s.broker
+	b.Lock()
+	defer b.Unlock()
+	if s.closed {
+		return
+	}
+
+	for _, channel := range channels {
+		subs, ok := b.channels[channel]
+		if !ok {
+			subs = make(map[*Subscriber]bool)
+			b.channels[channel] = subs
+		}
+		subs[s] = true
+		s.channels[channel] = true
+	}
+}
+
+// subscribe to all channels matching glob patterns
+func (s *Subscriber) PSubscribe(patterns ...string) error {
+	// compile all patterns before any changes
+	matchers := make([]glob.Glob, len(patterns))
+	for i, pattern := range patterns {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		matchers[i] = g
+	}
+
+	b := s.broker
+	b.Lock()
+	defer b.Unlock()
+	if s.closed {
+		return nil
+	}
+
+	for i, pattern := range patterns {
+		ps, ok := b.patterns[pattern]
+		if !ok {
+			ps = &patternSubs{matcher: matchers[i], subscribers: make(map[*Subscriber]bool)}
+			b.patterns[pattern] = ps
+		}
+		ps.subscribers[s] = true
+		s.patterns[pattern] = true
+	}
+	return nil
+}
+
+func (s *Subscriber) Unsubscribe(channels ...string) {
+	b := s.broker
+	b.Lock()
+	for _, channel := range channels {
+		b.removeChannelSub(channel, s)
+		delete(s.channels, channel)
+	}
+	b.Unlock()
+}
+
+func (s *Subscriber) PUnsubscribe(patterns ...string) {
+	b := s.broker
+	b.Lock()
+	for _, pattern := range patterns {
+		b.removePatternSub(pattern, s)
+		delete(s.patterns, pattern)
+	}
+	b.Unlock()
+}
+
+// Cancel all subscriptions and close message queue
+func (s *Subscriber) Close() {
+	b := s.broker
+	b.Lock()
+	defer b.Unlock()
+	if s.closed {
+		return
+	}
+
+	for channel := range s.channels {
+		b.removeChannelSub(channel, s)
+	}
+	for pattern := range s.patterns {
+		b.removePatternSub(pattern, s)
+	}
+	delete(b.subscribers, s)
+	s.closed = true
+	close(s.queue)
+}
+
+/* internals */
+
+// non-blocking delivery, must be called under broker read lock
+func (s *Subscriber) deliver(msg Message) bool {
+	select {
+	case s.queue <- msg:
+		return true
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		atomic.AddUint64(&s.broker.dropped, 1)
+		return false
+	}
+}
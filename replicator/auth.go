@@ -0,0 +1,207 @@
+package replicator
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// replication protocol versions supported by the node
+const (
+	PROTO_VERSION_MIN = 1
+	PROTO_VERSION_MAX = 1
+)
+
+const (
+	NONCE_SIZE = 32
+)
+
+var (
+	ErrAuthDenied         = errors.New("authorization failure")
+	ErrBadHandshake       = errors.New("malformed handshake message")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+)
+
+/*
+Handshake scheme, both sides prove knowledge of the secret
+without sending it or anything reusable over the wire:
+
+slave  -> master: HELLO     | min_version | max_version | slave_nonce
+master -> slave:  CHALLENGE | version | master_nonce
+slave  -> master: AUTH_REQ  | HMAC(secret, "slave" | version | slave_nonce | master_nonce)
+master -> slave:  AUTH_OK   | HMAC(secret, "master" | version | master_nonce | slave_nonce) or AUTH_DENY
+
+Master accepts proofs made with the current secret, and with the
+previous one until its grace window expires, so that secret
+could be rotated on master first and on slaves afterwards.
+*/
+type SecretRing struct {
+	current        []byte
+	previous       []byte
+	previousExpire time.Time
+	sync.RWMutex
+}
+
+func NewSecretRing(current, previous string, grace time.Duration) *SecretRing {
+	s := &SecretRing{current: []byte(current)}
+	if previous != "" && grace > 0 {
+		s.previous = []byte(previous)
+		s.previousExpire = time.Now().Add(grace)
+	}
+	return s
+}
+
+// Replace secrets, grace window of previous secret starts from now.
+func (s *SecretRing) Update(current, previous string, grace time.Duration) {
+	updated := NewSecretRing(current, previous, grace)
+	s.Lock()
+	s.current, s.previous, s.previousExpire = updated.current, updated.previous, updated.previousExpire
+	s.Unlock()
+}
+
+func (s *SecretRing) Current() []byte {
+	s.RLock()
+	defer s.RUnlock()
+	return s.current
+}
+
+// secrets accepted from slaves at the moment
+func (s *SecretRing) Accepted() [][]byte {
+	s.RLock()
+	defer s.RUnlock()
+	secrets := [][]byte{s.current}
+	if s.previous != nil && time.Now().Before(s.previousExpire) {
+		secrets = append(secrets, s.previous)
+	}
+	return secrets
+}
+
+// Authenticate slave connection on master, returns negotiated protocol version.
+func authenticateSlave(conn net.Conn, secrets *SecretRing, timeout time.Duration) (uint8, error) {
+	hello, err := ReceiveMsg(conn, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if hello.Type != MSG_TYPE_HELLO || len(hello.Payload) != 2+NONCE_SIZE {
+		SendMsg(conn, ServiceMsg{Type: MSG_TYPE_ERR, Payload: []byte(ErrUnsupportedVersion.Error())})
+		return 0, ErrBadHandshake
+	}
+
+	version, ok := negotiateVersion(hello.Payload[0], hello.Payload[1])
+	if !ok {
+		SendMsg(conn, ServiceMsg{Type: MSG_TYPE_ERR, Payload: []byte(ErrUnsupportedVersion.Error())})
+		return 0, ErrUnsupportedVersion
+	}
+	slaveNonce := hello.Payload[2:]
+
+	masterNonce, err := makeNonce()
+	if err != nil {
+		return 0, err
+	}
+	challenge := append([]byte{version}, masterNonce...)
+	if err := SendMsg(conn, ServiceMsg{Type: MSG_TYPE_CHALLENGE, Payload: challenge}); err != nil {
+		return 0, err
+	}
+
+	authMsg, err := ReceiveMsg(conn, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if authMsg.Type != MSG_TYPE_AUTH_REQ {
+		SendMsg(conn, ServiceMsg{Type: MSG_TYPE_AUTH_DENY})
+		return 0, ErrBadHandshake
+	}
+
+	for _, secret := range secrets.Accepted() {
+		if hmac.Equal(authMsg.Payload, handshakeProof(secret, "slave", version, slaveNonce, masterNonce)) {
+			proof := handshakeProof(secret, "master", version, masterNonce, slaveNonce)
+			return version, SendMsg(conn, ServiceMsg{Type: MSG_TYPE_AUTH_OK, Payload: proof})
+		}
+	}
+	SendMsg(conn, ServiceMsg{Type: MSG_TYPE_AUTH_DENY})
+	return 0, ErrAuthDenied
+}
+
+// Authenticate on master from slave side, returns negotiated protocol version.
+func authenticateMaster(conn net.Conn, secret []byte, timeout time.Duration) (uint8, error) {
+	slaveNonce, err := makeNonce()
+	if err != nil {
+		return 0, err
+	}
+	hello := append([]byte{PROTO_VERSION_MIN, PROTO_VERSION_MAX}, slaveNonce...)
+	if err := SendMsg(conn, ServiceMsg{Type: MSG_TYPE_HELLO, Payload: hello}); err != nil {
+		return 0, err
+	}
+
+	challenge, err := ReceiveMsg(conn, timeout)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case challenge.Type == MSG_TYPE_ERR:
+		return 0, errors.New(string(challenge.Payload))
+	case challenge.Type != MSG_TYPE_CHALLENGE || len(challenge.Payload) != 1+NONCE_SIZE:
+		return 0, ErrBadHandshake
+	}
+
+	version, masterNonce := challenge.Payload[0], challenge.Payload[1:]
+	if version < PROTO_VERSION_MIN || version > PROTO_VERSION_MAX {
+		return 0, ErrUnsupportedVersion
+	}
+
+	proof := handshakeProof(secret, "slave", version, slaveNonce, masterNonce)
+	if err := SendMsg(conn, ServiceMsg{Type: MSG_TYPE_AUTH_REQ, Payload: proof}); err != nil {
+		return 0, err
+	}
+
+	resp, err := ReceiveMsg(conn, timeout)
+	if err != nil {
+		return 0, err
+	}
+	switch resp.Type {
+	case MSG_TYPE_AUTH_OK:
+		// master must know the secret as well
+		if !hmac.Equal(resp.Payload, handshakeProof(secret, "master", version, masterNonce, slaveNonce)) {
+			return 0, ErrAuthDenied
+		}
+		return version, nil
+	case MSG_TYPE_AUTH_DENY:
+		return 0, ErrAuthDenied
+	default:
+		return 0, errors.New(string(resp.Payload))
+	}
+}
+
+/* internals */
+
+// highest version supported by both sides
+func negotiateVersion(min, max uint8) (uint8, bool) {
+	if max > PROTO_VERSION_MAX {
+		max = PROTO_VERSION_MAX
+	}
+	if min < PROTO_VERSION_MIN {
+		min = PROTO_VERSION_MIN
+	}
+	return max, min <= max
+}
+
+func handshakeProof(secret []byte, role string, version uint8, ownNonce, peerNonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(role))
+	mac.Write([]byte{version})
+	mac.Write(ownNonce)
+	mac.Write(peerNonce)
+	return mac.Sum(nil)
+}
+
+func makeNonce() ([]byte, error) {
+	nonce := make([]byte, NONCE_SIZE)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
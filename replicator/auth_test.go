@@ -0,0 +1,106 @@
+package replicator
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplicatorAuthHandshake(t *testing.T) {
+	setup_logger()
+
+	secrets := NewSecretRing("new-secret", "old-secret", time.Minute)
+	testCases := []struct {
+		Secret string
+		Err    error
+	}{
+		{"new-secret", nil},
+		{"old-secret", nil},
+		{"wrong-secret", ErrAuthDenied},
+		{"", ErrAuthDenied},
+	}
+	for _, c := range testCases {
+		slaveErr, masterErr := runHandshake(secrets, c.Secret)
+		if slaveErr != c.Err || masterErr != c.Err {
+			t.Errorf("unexpected handshake result with secret %q => slave: %v, master: %v", c.Secret, slaveErr, masterErr)
+		}
+	}
+
+	// grace window is over
+	expired := NewSecretRing("new-secret", "old-secret", time.Minute)
+	expired.previousExpire = time.Now().Add(-time.Second)
+	if slaveErr, masterErr := runHandshake(expired, "old-secret"); slaveErr != ErrAuthDenied || masterErr != ErrAuthDenied {
+		t.Errorf("previous secret accepted after grace window => slave: %v, master: %v", slaveErr, masterErr)
+	}
+}
+
+func TestReplicatorAuthReplay(t *testing.T) {
+	setup_logger()
+	secrets := NewSecretRing("secret", "", 0)
+
+	// proof captured from other session is useless
+	capturedProof := handshakeProof([]byte("secret"), "slave", PROTO_VERSION_MAX, make([]byte, NONCE_SIZE), make([]byte, NONCE_SIZE))
+
+	slaveConn, masterConn := net.Pipe()
+	defer slaveConn.Close()
+	masterErrCh := make(chan error, 1)
+	go func() {
+		_, err := authenticateSlave(masterConn, secrets, time.Second)
+		masterErrCh <- err
+	}()
+
+	hello := append([]byte{PROTO_VERSION_MIN, PROTO_VERSION_MAX}, make([]byte, NONCE_SIZE)...)
+	SendMsg(slaveConn, ServiceMsg{Type: MSG_TYPE_HELLO, Payload: hello})
+	ReceiveMsg(slaveConn, time.Second)
+	SendMsg(slaveConn, ServiceMsg{Type: MSG_TYPE_AUTH_REQ, Payload: capturedProof})
+	resp, err := ReceiveMsg(slaveConn, time.Second)
+	if err != nil || resp.Type != MSG_TYPE_AUTH_DENY {
+		t.Errorf("replayed proof accepted => response: %d, error: %v", resp.Type, err)
+	}
+	if err := <-masterErrCh; err != ErrAuthDenied {
+		t.Errorf("unexpected master error: %v", err)
+	}
+}
+
+func TestReplicatorAuthVersion(t *testing.T) {
+	testCases := []struct {
+		Min, Max uint8
+		Version  uint8
+		Ok       bool
+	}{
+		{PROTO_VERSION_MIN, PROTO_VERSION_MAX, PROTO_VERSION_MAX, true},
+		{PROTO_VERSION_MIN, PROTO_VERSION_MAX + 5, PROTO_VERSION_MAX, true},
+		{PROTO_VERSION_MAX + 1, PROTO_VERSION_MAX + 5, 0, false},
+	}
+	for _, c := range testCases {
+		version, ok := negotiateVersion(c.Min, c.Max)
+		if ok != c.Ok || (ok && version != c.Version) {
+			t.Errorf("bad version negotiation for [%d, %d] => version: %d, ok: %v", c.Min, c.Max, version, ok)
+		}
+	}
+
+	// legacy slave sending secret right away
+	slaveConn, masterConn := net.Pipe()
+	defer slaveConn.Close()
+	go authenticateSlave(masterConn, NewSecretRing("secret", "", 0), time.Second)
+	SendMsg(slaveConn, ServiceMsg{Type: MSG_TYPE_AUTH_REQ, Payload: []byte("secret")})
+	if resp, err := ReceiveMsg(slaveConn, time.Second); err != nil || resp.Type != MSG_TYPE_ERR {
+		t.Errorf("legacy handshake is not rejected => response: %d, error: %v", resp.Type, err)
+	}
+}
+
+/* helpers */
+
+func runHandshake(secrets *SecretRing, slaveSecret string) (slaveErr, masterErr error) {
+	slaveConn, masterConn := net.Pipe()
+	defer slaveConn.Close()
+	defer masterConn.Close()
+
+	masterErrCh := make(chan error, 1)
+	go func() {
+		_, err := authenticateSlave(masterConn, secrets, time.Second)
+		masterErrCh <- err
+	}()
+	_, slaveErr = authenticateMaster(slaveConn, []byte(slaveSecret), time.Second)
+	return slaveErr, <-masterErrCh
+}
@@ -1,50 +1,48 @@
 package replicator
 
 import (
-	"crypto/sha256"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"github.com/dgtony/gcache/utils"
 	"io"
+	"math/rand"
 	"net"
 	"time"
 )
 
 const (
-	RECONN_MAX_ATTEMPTS = 10
-	RECONN_MAX_WAIT     = 60 * time.Second
+	RECONN_MAX_WAIT = 60 * time.Second
 )
 
-func ConnectMaster(masterAddr string, timeout time.Duration, secretHash []byte) net.Conn {
-	for connAttempt := 0; connAttempt < RECONN_MAX_ATTEMPTS; connAttempt++ {
-		// try to connect
-		conn, err := net.DialTimeout("tcp", masterAddr, timeout)
-		if err == nil {
-			// auth
-			err = SendMsg(conn, ServiceMsg{Type: MSG_TYPE_AUTH_REQ, Payload: secretHash})
-			if err != nil {
-				panic(err)
-			}
-			resp, err := ReceiveMsg(conn, timeout)
-			if err != nil {
-				panic(err)
-			}
+var ErrMasterLost = errors.New("cannot reconnect to master node")
 
-			// parse response
-			switch resp.Type {
-			case MSG_TYPE_AUTH_OK:
-				logger.Infof("master node connection established, attempts: %d", connAttempt+1)
-				return conn
-			case MSG_TYPE_AUTH_DENY:
-				panic("master node authorization failure")
-			default:
-				panic(string(resp.Payload))
-			}
-		}
-		// wait next reconnect
-		time.Sleep(backoff(connAttempt, RECONN_MAX_WAIT))
+// replicator is stopped while waiting
+var errStopped = errors.New("replicator is stopped")
+
+/*
+Slave reconnection policy: attempts are made with jittered
+exponential backoff up to MaxWait between them, slave gives up
+after GiveUpAfter of being disconnected, never if zero.
+*/
+type ReconnectPolicy struct {
+	MaxWait     time.Duration
+	GiveUpAfter time.Duration
+}
+
+// Connect and authenticate on master node, single attempt.
+func ConnectMaster(masterAddr string, timeout time.Duration, secret []byte, tlsConf *tls.Config) (net.Conn, error) {
+	conn, err := dial(masterAddr, timeout, tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	version, err := authenticateMaster(conn, secret, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
-	panic("cannot connect to master node")
+	logger.Debugf("master node connection established, protocol version: %d", version)
+	return conn, nil
 }
 
 func GetMasterDump(conn net.Conn, timeout time.Duration) ([]byte, error) {
@@ -70,20 +68,22 @@ func GetMasterDump(conn net.Conn, timeout time.Duration) ([]byte, error) {
 
 func handleSlaveConn(conn net.Conn, r *Replicator) {
 	// auth phase
-	authMsg, err := ReceiveMsg(conn, CONN_AUTH_WAIT)
+	version, err := authenticateSlave(conn, r.Secrets, CONN_AUTH_WAIT)
 	if err != nil {
-		logger.Debugf("slave authentication failed: %s", err)
-		return
-	}
-	if authMsg.Type != MSG_TYPE_AUTH_REQ || !utils.CompareByteSlices(authMsg.Payload, r.MasterSecretHash) {
-		logger.Debug("slave authentication failed: bad auth")
-		SendMsg(conn, ServiceMsg{Type: MSG_TYPE_AUTH_DENY})
+		logger.Debugf("slave %s authentication failed: %s", conn.RemoteAddr(), err)
+		conn.Close()
 		return
 	}
 
 	// auth ok - proceed communication
-	SendMsg(conn, ServiceMsg{Type: MSG_TYPE_AUTH_OK})
-	logger.Debugf("slave connected: %s", conn.RemoteAddr())
+	logger.Debugf("slave connected: %s, protocol version: %d", conn.RemoteAddr(), version)
+	slaveHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	slave := r.addSlave(conn, version)
+	defer func() {
+		r.removeSlave(conn)
+		// series of gone slaves are not kept, other slave on the same host restores it on next pull
+		slaveLastPull.Delete(slaveHost)
+	}()
 
 	// waiting for requests
 	for {
@@ -98,6 +98,8 @@ func handleSlaveConn(conn net.Conn, r *Replicator) {
 			}
 			return
 		}
+		r.touchSlave(slave)
+
 		// process message
 		switch msg.Type {
 		case MSG_TYPE_GET_DUMP:
@@ -107,6 +109,8 @@ func handleSlaveConn(conn net.Conn, r *Replicator) {
 			if err := SendMsg(conn, ServiceMsg{Type: MSG_TYPE_DUMP, Payload: dump}); err != nil {
 				// FIXME: mb close connection?
 				logger.Debugf("error sending dump to slave: %s", err)
+			} else {
+				slaveLastPull.With(slaveHost).Set(float64(time.Now().Unix()))
 			}
 
 		default:
@@ -123,6 +127,8 @@ const (
 	MSG_TYPE_AUTH_REQ  = 1
 	MSG_TYPE_AUTH_OK   = 2
 	MSG_TYPE_AUTH_DENY = 3
+	MSG_TYPE_HELLO     = 4
+	MSG_TYPE_CHALLENGE = 5
 	// replication
 	MSG_TYPE_GET_DUMP = 10
 	MSG_TYPE_DUMP     = 11
@@ -164,10 +170,9 @@ func SendMsg(conn net.Conn, msg ServiceMsg) error {
 		return err
 	}
 
-	// write payload
-	err = binary.Write(conn, binary.BigEndian, msg.Payload)
-	if err != nil {
-		return err
+	// write payload, if any
+	if len(msg.Payload) > 0 {
+		return binary.Write(conn, binary.BigEndian, msg.Payload)
 	}
 	return nil
 }
@@ -209,8 +214,23 @@ func ReceiveMsg(conn net.Conn, timeout time.Duration) (ServiceMsg, error) {
 
 /* internal stuff */
 
+func dial(addr string, timeout time.Duration, tlsConf *tls.Config) (net.Conn, error) {
+	if tlsConf == nil {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
 // exponential backoff
 func backoff(attempt int, maxWait time.Duration) time.Duration {
+	// 2^30 seconds is beyond any sane limit, while larger exponents overflow duration
+	if attempt > 30 {
+		attempt = 30
+	}
 	wait := time.Duration((utils.Pow(2, attempt) - 1)) * time.Second
 	if wait > maxWait {
 		return maxWait
@@ -218,10 +238,13 @@ func backoff(attempt int, maxWait time.Duration) time.Duration {
 	return wait
 }
 
-func getSecretHash(secret string) []byte {
-	secretHash := make([]byte, 32)
-	for i, v := range sha256.Sum256([]byte(secret)) {
-		secretHash[i] = v
+// exponential backoff randomized within its upper half,
+// so that slaves do not reconnect simultaneously
+func jitteredBackoff(attempt int, maxWait time.Duration) time.Duration {
+	wait := backoff(attempt, maxWait)
+	// nothing to randomize in zero or nanosecond wait
+	if wait/2 <= 0 {
+		return wait
 	}
-	return secretHash
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)))
 }
@@ -1,7 +1,12 @@
 package replicator
 
 import (
+	"bytes"
 	"github.com/dgtony/gcache/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,6 +26,75 @@ func TestReplicatorConnBackoff(t *testing.T) {
 	if backoff(10, time.Minute) != time.Minute {
 		t.Error("backoff failure on attempt 10")
 	}
+	// exponent would overflow without limit
+	if backoff(64, time.Minute) != time.Minute {
+		t.Error("backoff failure on attempt 64")
+	}
+	if wait := jitteredBackoff(64, time.Minute); wait < 30*time.Second || wait >= time.Minute {
+		t.Errorf("jittered backoff failure on attempt 64: %s", wait)
+	}
+
+	for i := 0; i < 100; i++ {
+		if wait := jitteredBackoff(3, time.Minute); wait < 3500*time.Millisecond || wait >= 7*time.Second {
+			t.Fatalf("jittered backoff out of range: %s", wait)
+		}
+	}
+	if jitteredBackoff(10, time.Minute) > time.Minute {
+		t.Error("jittered backoff exceeds max wait")
+	}
+}
+
+func TestReplicatorConnReconnect(t *testing.T) {
+	setup_logger()
+
+	masterAddr := "127.0.0.1:12350"
+	secret := "secret"
+	slave := &Replicator{
+		Role:       "slave",
+		MasterAddr: masterAddr,
+		Secrets:    NewSecretRing(secret, "", 0),
+		Reconnect:  ReconnectPolicy{MaxWait: 200 * time.Millisecond}}
+
+	// master is not up yet
+	pulled := make(chan []byte)
+	go func() {
+		_, dump, err := slave.pullDump(nil)
+		if err != nil {
+			t.Errorf("pull dump => %s", err)
+		}
+		pulled <- dump
+	}()
+	time.Sleep(300 * time.Millisecond)
+	if status := slave.Status(); status.MasterConnected || status.ReconnectAttempts == 0 || status.DisconnectedSince.IsZero() {
+		t.Errorf("disconnected state is not reported: %+v", status)
+	}
+
+	master := &Replicator{
+		CacheDump:  []byte("dump"),
+		MasterAddr: masterAddr,
+		Secrets:    NewSecretRing(secret, "", 0)}
+	if err := master.runMasterServer(); err != nil {
+		t.Fatalf("run master server: %s", err)
+	}
+
+	select {
+	case dump := <-pulled:
+		if string(dump) != "dump" {
+			t.Errorf("unexpected dump: %q", dump)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("slave has not reconnected")
+	}
+	if status := slave.Status(); !status.MasterConnected || status.ReconnectAttempts != 0 {
+		t.Errorf("connected state is not reported: %+v", status)
+	}
+
+	// give up
+	slave.MasterAddr = "127.0.0.1:12351"
+	slave.Reconnect.GiveUpAfter = 300 * time.Millisecond
+	if _, _, err := slave.pullDump(nil); err != ErrMasterLost {
+		t.Errorf("unexpected error on give up: %v", err)
+	}
 }
 
 func TestReplicatorConnMasterSlave(t *testing.T) {
@@ -33,14 +107,19 @@ func TestReplicatorConnMasterSlave(t *testing.T) {
 
 	// start fake replicator
 	rep := Replicator{
-		CacheDump:        cacheDump,
-		MasterAddr:       masterAddr,
-		MasterSecretHash: getSecretHash(secret),
+		CacheDump:  cacheDump,
+		MasterAddr: masterAddr,
+		Secrets:    NewSecretRing(secret, "", 0),
+	}
+	if err := rep.runMasterServer(); err != nil {
+		t.Fatalf("run master server: %s", err)
 	}
-	rep.runMasterServer()
 
 	// connect
-	conn := ConnectMaster(masterAddr, 2*time.Second, getSecretHash(secret))
+	conn, err := ConnectMaster(masterAddr, 2*time.Second, []byte(secret), nil)
+	if err != nil {
+		t.Fatalf("connect master => %s", err)
+	}
 
 	// get dump
 	rcvDump, err := GetMasterDump(conn, 2*time.Second)
@@ -51,10 +130,115 @@ func TestReplicatorConnMasterSlave(t *testing.T) {
 	if !utils.CompareByteSlices(cacheDump, rcvDump) {
 		t.Error("dumps do not match")
 	}
+
+	// pull time is exposed while slave is connected
+	if !strings.Contains(collectLastPull(), `slave="127.0.0.1"`) {
+		t.Errorf("no pull time of connected slave:\n%s", collectLastPull())
+	}
+	conn.Close()
+	for i := 0; i < 100 && strings.Contains(collectLastPull(), `slave="127.0.0.1"`); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if strings.Contains(collectLastPull(), `slave="127.0.0.1"`) {
+		t.Errorf("pull time of disconnected slave is kept:\n%s", collectLastPull())
+	}
+}
+
+func TestReplicatorConnMutualTLS(t *testing.T) {
+	defer catch_panic(t)
+	setup_logger()
+
+	dir, err := ioutil.TempDir("", "gcache-replication")
+	if err != nil {
+		t.Fatalf("create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	masterCert, masterKey := writeTestCert(t, dir, "master")
+	slaveCert, slaveKey := writeTestCert(t, dir, "slave")
+	strangerCert, strangerKey := writeTestCert(t, dir, "stranger")
+
+	masterAddr := "127.0.0.1:12347"
+	secret := "secret"
+	cacheDump := []byte("somedatahere")
+
+	// master trusts slave certificate only
+	serverTLS, serverCerts, err := utils.ServerTLSConfig(masterCert, masterKey, slaveCert, true)
+	if err != nil {
+		t.Fatalf("master TLS config: %s", err)
+	}
+	defer serverCerts.Close()
+	rep := Replicator{
+		CacheDump:  cacheDump,
+		MasterAddr: masterAddr,
+		Secrets:    NewSecretRing(secret, "", 0),
+		TLSConfig:  serverTLS,
+	}
+	if err := rep.runMasterServer(); err != nil {
+		t.Fatalf("run master server: %s", err)
+	}
+
+	// trusted slave
+	clientTLS, clientCerts, err := utils.ClientTLSConfig(slaveCert, slaveKey, masterCert, "")
+	if err != nil {
+		t.Fatalf("slave TLS config: %s", err)
+	}
+	defer clientCerts.Close()
+	conn, err := ConnectMaster(masterAddr, 2*time.Second, []byte(secret), clientTLS)
+	if err != nil {
+		t.Fatalf("connect master => %s", err)
+	}
+	rcvDump, err := GetMasterDump(conn, 2*time.Second)
+	if err != nil {
+		t.Errorf("get master dump failure => %s", err)
+	}
+	if !utils.CompareByteSlices(cacheDump, rcvDump) {
+		t.Error("dumps do not match")
+	}
+	conn.Close()
+
+	// untrusted slave certificate
+	strangerTLS, strangerCerts, _ := utils.ClientTLSConfig(strangerCert, strangerKey, masterCert, "")
+	defer strangerCerts.Close()
+	if conn, err := dial(masterAddr, 2*time.Second, strangerTLS); err == nil {
+		if _, err := authenticateMaster(conn, []byte(secret), 2*time.Second); err == nil {
+			t.Error("slave with untrusted certificate is accepted")
+		}
+		conn.Close()
+	}
+
+	// slave does not trust master
+	untrustedTLS, untrustedCerts, _ := utils.ClientTLSConfig(slaveCert, slaveKey, strangerCert, "")
+	defer untrustedCerts.Close()
+	if conn, err := dial(masterAddr, 2*time.Second, untrustedTLS); err == nil {
+		t.Error("untrusted master certificate is accepted")
+		conn.Close()
+	}
 }
 
 /* helpers */
 
+func collectLastPull() string {
+	var buf bytes.Buffer
+	slaveLastPull.Collect(&buf)
+	return buf.String()
+}
+
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certPEM, keyPEM, err := utils.GenerateSelfSignedCert(name)
+	if err != nil {
+		t.Fatalf("generate certificate: %s", err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("write certificate: %s", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("write key: %s", err)
+	}
+	return certFile, keyFile
+}
+
 func setup_logger() {
 	if !logSetFlag {
 		utils.SetupLoggers(&utils.Config{
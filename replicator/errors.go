@@ -0,0 +1,27 @@
+package replicator
+
+import (
+	"fmt"
+)
+
+// startup stages
+const (
+	STAGE_TLS     = "TLS setup"
+	STAGE_STORAGE = "storage setup"
+	STAGE_LISTEN  = "replication listener"
+)
+
+// Replicator startup failure, no replication goroutines are left running.
+type StartupError struct {
+	Role  string
+	Stage string
+	Err   error
+}
+
+func (e *StartupError) Error() string {
+	return fmt.Sprintf("%s node startup failed at %s: %s", e.Role, e.Stage, e.Err)
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
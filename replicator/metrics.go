@@ -0,0 +1,36 @@
+package replicator
+
+import (
+	"github.com/dgtony/gcache/metrics"
+)
+
+var (
+	dumpSize = metrics.NewGauge(
+		"gcache_replication_dump_bytes",
+		"Size of the latest storage snapshot.")
+	dumpDuration = metrics.NewHistogram(
+		"gcache_replication_dump_duration_seconds",
+		"Time taken to make storage snapshot.",
+		metrics.DefBuckets)
+	slaveLastPull = metrics.NewGaugeVec(
+		"gcache_replication_slave_last_pull_timestamp_seconds",
+		"Time of the latest snapshot pulled by slave, master only.",
+		"slave")
+	lastSync = metrics.NewGauge(
+		"gcache_replication_last_sync_timestamp_seconds",
+		"Time of the latest successful sync with master, slave only.")
+	masterConnected = metrics.NewGauge(
+		"gcache_replication_master_connected",
+		"Whether slave is connected to master, slave only.")
+	reconnectAttempts = metrics.NewCounter(
+		"gcache_replication_reconnect_attempts_total",
+		"Failed attempts to connect to master, slave only.")
+	pullDuration = metrics.NewHistogram(
+		"gcache_replication_pull_duration_seconds",
+		"Time taken to pull and apply master snapshot, slave only.",
+		metrics.DefBuckets)
+)
+
+func init() {
+	metrics.Default.MustRegister(dumpSize, dumpDuration, slaveLastPull, lastSync, masterConnected, reconnectAttempts, pullDuration)
+}
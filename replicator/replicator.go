@@ -1,12 +1,16 @@
 package replicator
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/dgtony/gcache/storage"
 	"github.com/dgtony/gcache/utils"
 	"github.com/op/go-logging"
 	"io/ioutil"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,140 +19,274 @@ const (
 	CONN_AUTH_WAIT        = 20 * time.Second
 	CONN_GET_DUMP_TIMEOUT = 20 * time.Second
 	CONN_MAX_IDLE         = 30 * time.Minute
+	ACCEPT_RETRY_WAIT     = 100 * time.Millisecond
 )
 
 var logger *logging.Logger
 
+// loggers are shared by all nodes in process
+var loggerOnce sync.Once
+
 func init_logger() {
-	logger = utils.GetLogger("Replicator")
+	loggerOnce.Do(func() { logger = utils.GetLogger("Replicator") })
 }
 
 type Replicator struct {
-	CacheDump        []byte
-	Store            *storage.ConcurrentMap
-	DumpFile         string
-	MasterAddr       string
-	MasterSecretHash []byte
+	Role       string
+	StartTime  time.Time
+	CacheDump  []byte
+	Store      storage.Store
+	DumpFile   string
+	MasterAddr string
+	Secrets    *SecretRing
+	// TLS settings of replication link, plain TCP if nil
+	TLSConfig *tls.Config
+	// watches certificate files of TLSConfig, closed on Stop
+	certReloader *utils.CertReloader
+	// slave only
+	Reconnect ReconnectPolicy
+	// snapshot periods, nanoseconds, could be changed live
+	dumpUpdatePeriod int64
+	fileWritePeriod  int64
+	// status tracking, see Status() and Readiness()
+	lastDumpTime time.Time
+	lastDumpErr  error
+	lastSync     time.Time
+	lastSyncErr  error
+	slaves       map[net.Conn]*SlaveStatus
+	// slave connection to master
+	masterConnected   bool
+	disconnectedAt    time.Time
+	reconnectAttempts int
+	// unrecoverable failures after startup
+	fatal        chan error
+	snapshotting bool
+	listening    bool
+	// closed on Stop
+	stop     chan struct{}
+	stopOnce sync.Once
+	listener net.Listener
 	sync.Mutex
 }
 
-func RunReplicator(conf *utils.Config) (*Replicator, *storage.ConcurrentMap) {
+func RunReplicator(conf *utils.Config) (*Replicator, storage.Store, error) {
 	init_logger()
 
 	rep := &Replicator{
-		DumpFile:         conf.Replication.CacheFile,
-		MasterSecretHash: getSecretHash(conf.Replication.MasterSecret),
-		MasterAddr:       conf.Replication.MasterAddr}
+		Role:       conf.Replication.NodeRole,
+		StartTime:  time.Now(),
+		DumpFile:   conf.Replication.CacheFile,
+		MasterAddr: conf.Replication.MasterAddr,
+		Secrets: NewSecretRing(
+			conf.Replication.MasterSecret,
+			conf.Replication.MasterSecretPrevious,
+			time.Duration(conf.Replication.SecretGracePeriod)*time.Second),
+		Reconnect: ReconnectPolicy{
+			MaxWait:     time.Duration(conf.Replication.ReconnectMaxWait) * time.Second,
+			GiveUpAfter: time.Duration(conf.Replication.ReconnectGiveUp) * time.Second},
+		fatal: make(chan error, 1),
+		stop:  make(chan struct{})}
+	rep.SetPeriods(
+		time.Duration(conf.Replication.DumpUpdatePeriod)*time.Second,
+		time.Duration(conf.Replication.FileWritePeriod)*time.Second)
+
+	if conf.Replication.TLSEnabled && conf.Replication.NodeRole != "standalone" {
+		tlsConf, reloader, err := makeTLSConfig(conf)
+		if err != nil {
+			return nil, nil, &StartupError{Role: rep.Role, Stage: STAGE_TLS, Err: err}
+		}
+		rep.TLSConfig = tlsConf
+		rep.certReloader = reloader
+	}
 
+	var err error
 	switch conf.Replication.NodeRole {
 	case "standalone":
-		startStandalone(rep, conf)
+		err = startStandalone(rep, conf)
 	case "master":
-		startMaster(rep, conf)
+		err = startMaster(rep, conf)
 	case "slave":
-		startSlave(rep, conf)
+		err = startSlave(rep, conf)
 	default:
-		panic("unsupported node role")
+		err = &StartupError{Role: rep.Role, Stage: "role", Err: errors.New("unsupported node role")}
 	}
+	if err != nil {
+		rep.certReloader.Close()
+		return nil, nil, err
+	}
+	return rep, rep.Store, nil
+}
+
+/*
+Stop replication processes, listener and slave connections,
+as well as storage background sweeps. Stored data is still accessible.
+*/
+func (r *Replicator) Stop() {
+	r.stopOnce.Do(func() {
+		if r.stop != nil {
+			close(r.stop)
+		}
+		r.Lock()
+		if r.listener != nil {
+			r.listener.Close()
+			r.listening = false
+		}
+		for conn := range r.slaves {
+			conn.Close()
+		}
+		r.Unlock()
+		if r.Store != nil {
+			r.Store.Close()
+		}
+		r.certReloader.Close()
+	})
+}
+
+// Unrecoverable replication failure after startup, e.g. slave gave up on master.
+func (r *Replicator) Fatal() <-chan error {
+	return r.fatal
+}
+
+// Change snapshot update (pull for slaves) and file saving periods,
+// applied since the next cycle.
+func (r *Replicator) SetPeriods(dumpUpdate, fileWrite time.Duration) {
+	atomic.StoreInt64(&r.dumpUpdatePeriod, int64(dumpUpdate))
+	atomic.StoreInt64(&r.fileWritePeriod, int64(fileWrite))
+}
 
-	return rep, rep.Store
+func (r *Replicator) Periods() (dumpUpdate, fileWrite time.Duration) {
+	dumpUpdate = time.Duration(atomic.LoadInt64(&r.dumpUpdatePeriod))
+	fileWrite = time.Duration(atomic.LoadInt64(&r.fileWritePeriod))
+	return dumpUpdate, fileWrite
 }
 
 /* bootstrap procedures */
 
-func startStandalone(rep *Replicator, conf *utils.Config) {
-	startStorage(rep, conf)
+func startStandalone(rep *Replicator, conf *utils.Config) error {
+	if err := startStorage(rep, conf); err != nil {
+		return err
+	}
 	if conf.Replication.SaveCacheToFile {
-		rep.runDumpUpdater(time.Duration(conf.Replication.DumpUpdatePeriod) * time.Second)
-		rep.runFileDumper(time.Duration(conf.Replication.FileWritePeriod) * time.Second)
+		rep.runDumpUpdater()
+		rep.runFileDumper()
 	}
+	return nil
 }
 
-func startMaster(rep *Replicator, conf *utils.Config) {
-	startStorage(rep, conf)
-	rep.runDumpUpdater(time.Duration(conf.Replication.DumpUpdatePeriod) * time.Second)
-	rep.runMasterServer()
+func startMaster(rep *Replicator, conf *utils.Config) error {
+	if err := startStorage(rep, conf); err != nil {
+		return err
+	}
+	if err := rep.runMasterServer(); err != nil {
+		return &StartupError{Role: rep.Role, Stage: STAGE_LISTEN, Err: err}
+	}
+	rep.runDumpUpdater()
 	if conf.Replication.SaveCacheToFile {
-		rep.runFileDumper(time.Duration(conf.Replication.FileWritePeriod) * time.Second)
+		rep.runFileDumper()
 	}
+	return nil
 }
 
-func startSlave(rep *Replicator, conf *utils.Config) {
-	masterConn := startStorageSlave(rep, conf)
-	rep.runDumpPuller(masterConn, time.Duration(conf.Replication.DumpUpdatePeriod)*time.Second)
+/*
+Slave starts with empty storage and makes initial sync in background,
+so that its servers are up and report it not ready until sync is completed.
+*/
+func startSlave(rep *Replicator, conf *utils.Config) error {
+	store, err := storage.NewStore(conf)
+	if err != nil {
+		return &StartupError{Role: rep.Role, Stage: STAGE_STORAGE, Err: err}
+	}
+	rep.Store = store
+	rep.runDumpPuller(nil)
 	if conf.Replication.SaveCacheToFile {
-		rep.runFileDumper(time.Duration(conf.Replication.FileWritePeriod) * time.Second)
+		rep.runFileDumper()
 	}
+	return nil
 }
 
-func startStorage(rep *Replicator, conf *utils.Config) {
+func startStorage(rep *Replicator, conf *utils.Config) error {
 	if conf.Replication.RestoreCacheFromFile {
 		dump, err := ioutil.ReadFile(conf.Replication.CacheFile)
 		if err == nil {
-			store, err := storage.MakeStorageFromDump(conf, dump)
+			store, err := storage.NewStoreFromDump(conf, dump)
 			if err == nil {
 				rep.Store = store
 				logger.Debug("storage successfully restored from dump")
-				return
+				return nil
 			}
 			logger.Warningf("cannot restore cache from dump: %s", err)
+		} else {
+			logger.Warningf("cannot read dump file: %s", err)
 		}
-		logger.Warningf("cannot read dump file: %s", err)
 	}
 
 	// make empty
 	logger.Debug("starting empty cache")
-	store, err := storage.MakeStorageEmpty(conf)
+	store, err := storage.NewStore(conf)
 	if err != nil {
-		panic(err)
+		return &StartupError{Role: rep.Role, Stage: STAGE_STORAGE, Err: err}
 	}
 	rep.Store = store
+	return nil
 }
 
-func startStorageSlave(rep *Replicator, conf *utils.Config) net.Conn {
-	conn := ConnectMaster(rep.MasterAddr, CONN_TIMEOUT, rep.MasterSecretHash)
-	dump, err := GetMasterDump(conn, CONN_GET_DUMP_TIMEOUT)
-	if err != nil {
-		panic(err)
-	}
-	store, err := storage.MakeStorageFromDump(conf, dump)
-	if err != nil {
-		logger.Errorf("create storage from master snapshot: %s", err)
-		panic(err)
+// server settings for master, client settings for slave
+func makeTLSConfig(conf *utils.Config) (*tls.Config, *utils.CertReloader, error) {
+	rc := conf.Replication
+	if rc.NodeRole == "master" {
+		return utils.ServerTLSConfig(rc.TLSCert, rc.TLSKey, rc.TLSCA, rc.TLSClientAuth)
 	}
-	rep.Store = store
-	return conn
+	return utils.ClientTLSConfig(rc.TLSCert, rc.TLSKey, rc.TLSCA, rc.TLSServerName)
 }
 
 /* replicator proccesses */
 
 // take current snapshot from storage
-func (r *Replicator) runDumpUpdater(dumpUpdatePeriod time.Duration) {
+func (r *Replicator) runDumpUpdater() {
+	r.Lock()
+	r.snapshotting = true
+	r.Unlock()
 	go func() {
 		for {
+			start := time.Now()
 			dump, err := r.Store.DumpStorage()
 			if err == nil {
-				r.Lock()
-				r.CacheDump = dump
-				r.Unlock()
+				dumpDuration.With().Observe(time.Since(start).Seconds())
+				dumpSize.With().Set(float64(len(dump)))
 			} else {
 				logger.Errorf("cannot update cache snapshot: %s", err)
 			}
-			time.Sleep(dumpUpdatePeriod)
+			r.Lock()
+			if err == nil {
+				r.setDump(dump)
+			}
+			r.lastDumpErr = err
+			r.Unlock()
+			dumpUpdatePeriod, _ := r.Periods()
+			if !r.sleep(dumpUpdatePeriod) {
+				return
+			}
 		}
 	}()
 }
 
 // write snapshot in file
-func (r *Replicator) runFileDumper(dumpSavePeriod time.Duration) {
+func (r *Replicator) runFileDumper() {
 	go func() {
 		for {
-			time.Sleep(dumpSavePeriod)
+			_, dumpSavePeriod := r.Periods()
+			if !r.sleep(dumpSavePeriod) {
+				return
+			}
 
 			// save current dump in file
 			r.Lock()
 			data := r.CacheDump
 			r.Unlock()
+			if data == nil {
+				// slave before initial sync, file is kept
+				continue
+			}
 			if err := ioutil.WriteFile(r.DumpFile, data, 0644); err != nil {
 				logger.Errorf("cache snapshot saving: %s", err)
 			}
@@ -156,57 +294,141 @@ func (r *Replicator) runFileDumper(dumpSavePeriod time.Duration) {
 	}()
 }
 
-// pull storage dump from master (slave only)
-func (r *Replicator) runDumpPuller(conn net.Conn, pullDumpPeriod time.Duration) {
+// pull storage dump from master (slave only), connection is nil before initial sync
+func (r *Replicator) runDumpPuller(conn net.Conn) {
 	go func() {
-		reconFlag := false
-
-		// main loop
 		for {
-			// try to send request
-			dump, err := GetMasterDump(conn, CONN_GET_DUMP_TIMEOUT)
+			start := time.Now()
+			var dump []byte
+			var err error
+			conn, dump, err = r.pullDump(conn)
+			if err == errStopped {
+				return
+			}
 			if err != nil {
-				if !reconFlag {
-					// try to reconnect
-					reconFlag = true
-					logger.Warning("master node connection lost, reconnecting...")
-					conn = ConnectMaster(r.MasterAddr, CONN_TIMEOUT, r.MasterSecretHash)
-					continue
-				} else {
-					panic(err)
-				}
+				// reconnect policy exhausted
+				logger.Criticalf("giving up on master node %s: %s", r.MasterAddr, err)
+				r.fatal <- err
+				return
 			}
-			reconFlag = false
 
-			// update storage
+			// update storage, keep stale data on failure
 			if err = r.Store.RestoreFromDump(dump); err != nil {
+				if _, ok := err.(*storage.ShardMismatchError); ok {
+					err = fmt.Errorf("%s, storage.shards must be the same as on master", err)
+				}
 				logger.Errorf("update storage from master snapshot: %s", err)
-				panic(err)
+				r.Lock()
+				r.lastSyncErr = err
+				r.Unlock()
+				pullDumpPeriod, _ := r.Periods()
+				if !r.sleep(pullDumpPeriod) {
+					conn.Close()
+					return
+				}
+				continue
 			}
 
 			// update cache dump (for file saving)
 			r.Lock()
-			r.CacheDump = dump
+			r.setDump(dump)
+			r.lastSync, r.lastSyncErr = r.lastDumpTime, nil
 			r.Unlock()
 
-			time.Sleep(pullDumpPeriod)
+			pullDuration.With().Observe(time.Since(start).Seconds())
+			dumpSize.With().Set(float64(len(dump)))
+			lastSync.With().Set(float64(time.Now().Unix()))
+
+			pullDumpPeriod, _ := r.Periods()
+			if !r.sleep(pullDumpPeriod) {
+				conn.Close()
+				return
+			}
 		}
 	}()
 }
 
+/*
+Pull snapshot from master over given connection, reconnecting on failure
+according to reconnect policy, storage keeps serving stale data meanwhile.
+Connection is nil if not established yet. Returns connection in use.
+*/
+func (r *Replicator) pullDump(conn net.Conn) (net.Conn, []byte, error) {
+	for attempt := 1; ; attempt++ {
+		var err error
+		if conn == nil {
+			conn, err = ConnectMaster(r.MasterAddr, CONN_TIMEOUT, r.Secrets.Current(), r.TLSConfig)
+		}
+		if err == nil {
+			var dump []byte
+			if dump, err = GetMasterDump(conn, CONN_GET_DUMP_TIMEOUT); err == nil {
+				r.setMasterConnected()
+				return conn, dump, nil
+			}
+			conn.Close()
+			conn = nil
+		}
+
+		disconnected := r.setMasterDisconnected(err)
+		if r.Reconnect.GiveUpAfter > 0 && disconnected >= r.Reconnect.GiveUpAfter {
+			return nil, nil, ErrMasterLost
+		}
+		maxWait := r.Reconnect.MaxWait
+		if maxWait <= 0 {
+			maxWait = RECONN_MAX_WAIT
+		}
+		if !r.sleep(jitteredBackoff(attempt, maxWait)) {
+			return nil, nil, errStopped
+		}
+	}
+}
+
+func (r *Replicator) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait for given time, false if replicator is stopped meanwhile.
+func (r *Replicator) sleep(d time.Duration) bool {
+	select {
+	case <-r.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
 // serve storage dump (master only)
-func (r *Replicator) runMasterServer() {
+func (r *Replicator) runMasterServer() error {
+	ln, err := net.Listen("tcp", r.MasterAddr)
+	if err != nil {
+		return err
+	}
+	if r.TLSConfig != nil {
+		ln = tls.NewListener(ln, r.TLSConfig)
+	}
+	r.Lock()
+	r.listening = true
+	r.listener = ln
+	r.Unlock()
+
 	go func() {
-		ln, err := net.Listen("tcp", r.MasterAddr)
-		if err != nil {
-			// no recovery
-			panic(err)
-		}
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
+				if r.stopped() {
+					return
+				}
+				logger.Errorf("accept slave connection: %s", err)
+				r.sleep(ACCEPT_RETRY_WAIT)
+				continue
 			}
 			go handleSlaveConn(conn, r)
 		}
 	}()
+	return nil
 }
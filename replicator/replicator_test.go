@@ -0,0 +1,124 @@
+package replicator
+
+import (
+	"github.com/dgtony/gcache/utils"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicatorStartupErrors(t *testing.T) {
+	// replication address is taken
+	ln, err := net.Listen("tcp", "127.0.0.1:12352")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+
+	testCases := []struct {
+		Role  string
+		Stage string
+		Setup func(conf *utils.Config)
+	}{
+		{"master", STAGE_LISTEN, func(conf *utils.Config) {}},
+		{"slave", STAGE_STORAGE, func(conf *utils.Config) { conf.Storage.NumShards = 0 }},
+		{"master", STAGE_STORAGE, func(conf *utils.Config) { conf.Storage.NumShards = 0 }},
+		{"unknown", "role", func(conf *utils.Config) {}},
+	}
+	for _, c := range testCases {
+		conf := utils.DefaultConfig()
+		conf.Replication.NodeRole = c.Role
+		conf.Replication.MasterAddr = "127.0.0.1:12352"
+		conf.Replication.SaveCacheToFile = false
+		conf.Replication.RestoreCacheFromFile = false
+		c.Setup(conf)
+
+		rep, store, err := RunReplicator(conf)
+		if rep != nil || store != nil {
+			t.Errorf("replicator returned on %s failure", c.Stage)
+		}
+		startErr, ok := err.(*StartupError)
+		if !ok {
+			t.Errorf("expected startup error at %s, got: %v", c.Stage, err)
+			continue
+		}
+		if startErr.Role != c.Role || startErr.Stage != c.Stage {
+			t.Errorf("unexpected startup error => expected: %s/%s, got: %s", c.Role, c.Stage, startErr)
+		}
+	}
+}
+
+func TestReplicatorSlaveInitialSync(t *testing.T) {
+	setup_logger()
+	conf := utils.DefaultConfig()
+	conf.Replication.NodeRole = "slave"
+	conf.Replication.MasterAddr = "127.0.0.1:12353"
+	conf.Replication.SaveCacheToFile = false
+	conf.Replication.RestoreCacheFromFile = false
+	conf.Replication.ReconnectGiveUp = 1
+	conf.Replication.ReconnectMaxWait = 1
+
+	// slave starts without master, not ready until initial sync
+	rep, store, err := RunReplicator(conf)
+	if err != nil || store == nil {
+		t.Fatalf("slave is not started without master: %v", err)
+	}
+	defer rep.Stop()
+	if err := rep.Readiness(); err == nil || !strings.Contains(err.Error(), "initial sync") {
+		t.Errorf("unexpected readiness before initial sync: %v", err)
+	}
+
+	select {
+	case err := <-rep.Fatal():
+		if err != ErrMasterLost {
+			t.Errorf("unexpected replication failure: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("slave doesn't give up on unavailable master")
+	}
+}
+
+func TestReplicatorStopTLS(t *testing.T) {
+	setup_logger()
+	dir, err := ioutil.TempDir("", "gcache-replication")
+	if err != nil {
+		t.Fatalf("create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := utils.DefaultConfig()
+	conf.Replication.NodeRole = "master"
+	conf.Replication.MasterAddr = "127.0.0.1:12354"
+	conf.Replication.SaveCacheToFile = false
+	conf.Replication.RestoreCacheFromFile = false
+	conf.Replication.TLSEnabled = true
+	conf.Replication.TLSCert, conf.Replication.TLSKey = writeTestCert(t, dir, "master")
+
+	rep, _, err := RunReplicator(conf)
+	if err != nil {
+		t.Fatalf("run replicator: %s", err)
+	}
+	if !waitCertWatch(true) {
+		t.Fatal("certificate files are not watched")
+	}
+	rep.Stop()
+	if !waitCertWatch(false) {
+		t.Error("certificate files are watched after stop")
+	}
+}
+
+// wait for certificate watcher goroutine to start or exit
+func waitCertWatch(running bool) bool {
+	buf := make([]byte, 1<<20)
+	for i := 0; i < 100; i++ {
+		if strings.Contains(string(buf[:runtime.Stack(buf, true)]), "CertReloader).watch") == running {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// see Readiness()
+const READY_MISSED_PERIODS = 3
+
+// Connected slave as seen by master
+type SlaveStatus struct {
+	Addr            string
+	ProtocolVersion uint8
+	ConnectedAt     time.Time
+	LastRequest     time.Time
+}
+
+// Node replication state report
+type Status struct {
+	Role      string
+	StartTime time.Time
+	// latest storage snapshot, zero time if not made yet
+	LastDumpTime time.Time
+	LastDumpSize int
+	// slave only
+	MasterAddr      string
+	LastSync        time.Time
+	MasterConnected bool
+	// zero time if connected
+	DisconnectedSince time.Time
+	// failed attempts since connection loss
+	ReconnectAttempts int
+	// master only, ordered by address
+	Slaves []SlaveStatus
+}
+
+func (r *Replicator) Status() Status {
+	r.Lock()
+	defer r.Unlock()
+
+	status := Status{
+		Role:         r.Role,
+		StartTime:    r.StartTime,
+		LastDumpTime: r.lastDumpTime,
+		LastDumpSize: len(r.CacheDump)}
+
+	if r.Role == "slave" {
+		status.MasterAddr = r.MasterAddr
+		status.LastSync = r.lastSync
+		status.MasterConnected = r.masterConnected
+		status.ReconnectAttempts = r.reconnectAttempts
+		if !r.masterConnected {
+			status.DisconnectedSince = r.disconnectedAt
+		}
+	}
+
+	status.Slaves = make([]SlaveStatus, 0, len(r.slaves))
+	for _, slave := range r.slaves {
+		status.Slaves = append(status.Slaves, *slave)
+	}
+	sort.Slice(status.Slaves, func(i, j int) bool { return status.Slaves[i].Addr < status.Slaves[j].Addr })
+	return status
+}
+
+/*
+Check whether node is ready to serve clients, error describes the reason if not.
+Master should listen for slaves and make snapshots regularly, slave should complete
+initial sync and pull snapshots from master regularly. Snapshot is considered stale
+after READY_MISSED_PERIODS update periods.
+*/
+func (r *Replicator) Readiness() error {
+	dumpUpdatePeriod, _ := r.Periods()
+	maxAge := READY_MISSED_PERIODS * dumpUpdatePeriod
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.Role == "slave" {
+		if r.lastSync.IsZero() {
+			if r.lastSyncErr != nil {
+				return fmt.Errorf("initial sync with master is not completed: %s", r.lastSyncErr)
+			}
+			return errors.New("initial sync with master is not completed")
+		}
+		if age := time.Since(r.lastSync); age > maxAge+CONN_GET_DUMP_TIMEOUT {
+			if !r.masterConnected && !r.disconnectedAt.IsZero() {
+				return fmt.Errorf("master connection lost %s ago", time.Since(r.disconnectedAt).Truncate(time.Second))
+			}
+			return fmt.Errorf("no successful pull from master for %s", age.Truncate(time.Second))
+		}
+		return nil
+	}
+
+	if r.Role == "master" && !r.listening {
+		return errors.New("replication listener is not up")
+	}
+	if !r.snapshotting {
+		return nil
+	}
+	if r.lastDumpErr != nil {
+		return fmt.Errorf("snapshot failed: %s", r.lastDumpErr)
+	}
+	if r.lastDumpTime.IsZero() {
+		return errors.New("no snapshot made yet")
+	}
+	if age := time.Since(r.lastDumpTime); age > maxAge {
+		return fmt.Errorf("last snapshot is stale: made %s ago", age.Truncate(time.Second))
+	}
+	return nil
+}
+
+/* internals */
+
+// do not use outside - not thread-safe!
+func (r *Replicator) setDump(dump []byte) {
+	r.CacheDump = dump
+	r.lastDumpTime = time.Now()
+}
+
+func (r *Replicator) setMasterConnected() {
+	r.Lock()
+	defer r.Unlock()
+	if !r.masterConnected {
+		if r.reconnectAttempts > 0 {
+			logger.Infof("master node connection restored, attempts: %d", r.reconnectAttempts)
+		}
+		r.masterConnected, r.reconnectAttempts = true, 0
+		masterConnected.With().Set(1)
+	}
+}
+
+// register failed attempt, return time being disconnected
+func (r *Replicator) setMasterDisconnected(err error) time.Duration {
+	r.Lock()
+	defer r.Unlock()
+	if r.masterConnected || r.disconnectedAt.IsZero() {
+		if r.masterConnected {
+			logger.Warningf("master node connection lost: %s, serving stale data while reconnecting", err)
+		}
+		r.masterConnected, r.disconnectedAt = false, time.Now()
+		masterConnected.With().Set(0)
+	}
+	r.reconnectAttempts++
+	reconnectAttempts.With().Inc()
+	if err == ErrAuthDenied {
+		logger.Errorf("master node authorization failure, attempt: %d", r.reconnectAttempts)
+	} else {
+		logger.Warningf("master node %s is unavailable: %s, attempt: %d", r.MasterAddr, err, r.reconnectAttempts)
+	}
+	return time.Since(r.disconnectedAt)
+}
+
+func (r *Replicator) addSlave(conn net.Conn, version uint8) *SlaveStatus {
+	now := time.Now()
+	slave := &SlaveStatus{
+		Addr:            conn.RemoteAddr().String(),
+		ProtocolVersion: version,
+		ConnectedAt:     now,
+		LastRequest:     now}
+
+	r.Lock()
+	if r.slaves == nil {
+		r.slaves = make(map[net.Conn]*SlaveStatus)
+	}
+	r.slaves[conn] = slave
+	r.Unlock()
+	return slave
+}
+
+func (r *Replicator) removeSlave(conn net.Conn) {
+	r.Lock()
+	delete(r.slaves, conn)
+	r.Unlock()
+}
+
+func (r *Replicator) touchSlave(slave *SlaveStatus) {
+	r.Lock()
+	slave.LastRequest = time.Now()
+	r.Unlock()
+}
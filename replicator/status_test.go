@@ -0,0 +1,35 @@
+package replicator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicatorReadiness(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		Rep *Replicator
+		Err string
+	}{
+		{&Replicator{Role: "standalone"}, ""},
+		{&Replicator{Role: "standalone", snapshotting: true}, "no snapshot made yet"},
+		{&Replicator{Role: "master", snapshotting: true, lastDumpTime: now}, "listener is not up"},
+		{&Replicator{Role: "master", snapshotting: true, listening: true, lastDumpTime: now}, ""},
+		{&Replicator{Role: "master", snapshotting: true, listening: true, lastDumpTime: now, lastDumpErr: errors.New("encoding")}, "snapshot failed: encoding"},
+		{&Replicator{Role: "master", snapshotting: true, listening: true, lastDumpTime: now.Add(-time.Minute)}, "last snapshot is stale"},
+		{&Replicator{Role: "slave"}, "initial sync"},
+		{&Replicator{Role: "slave", lastSyncErr: errors.New("shards differ")}, "initial sync with master is not completed: shards differ"},
+		{&Replicator{Role: "slave", lastSync: now}, ""},
+		{&Replicator{Role: "slave", lastSync: now.Add(-time.Minute)}, "no successful pull"},
+		{&Replicator{Role: "slave", lastSync: now.Add(-time.Minute), disconnectedAt: now.Add(-time.Minute)}, "master connection lost"},
+	}
+	for _, c := range testCases {
+		c.Rep.SetPeriods(time.Second, time.Second)
+		err := c.Rep.Readiness()
+		if (c.Err == "" && err != nil) || (c.Err != "" && (err == nil || !strings.Contains(err.Error(), c.Err))) {
+			t.Errorf("unexpected readiness of %s node => expected: %q, got: %v", c.Rep.Role, c.Err, err)
+		}
+	}
+}
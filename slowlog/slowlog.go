@@ -0,0 +1,146 @@
+package slowlog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_LEN = 128
+
+	// operation sources
+	SOURCE_REST    = "rest"
+	SOURCE_STORAGE = "storage"
+)
+
+// Slow log shared by all node components, disabled until configured
+var Default = New(-1, DEFAULT_MAX_LEN)
+
+type Entry struct {
+	ID         uint64
+	Time       time.Time
+	Duration   time.Duration
+	Source     string
+	Operation  string
+	Key        string
+	ClientAddr string
+	// ID of REST request, empty for storage calls
+	RequestID string
+}
+
+/*
+SlowLog keeps bounded number of latest operations
+taken longer than threshold, older entries are dropped.
+*/
+type SlowLog struct {
+	// nanoseconds, disabled if negative
+	threshold int64
+	maxLen    int
+	// ring buffer, head points to the oldest entry
+	entries []Entry
+	head    int
+	nextID  uint64
+	sync.Mutex
+}
+
+func New(threshold time.Duration, maxLen int) *SlowLog {
+	if maxLen < 1 {
+		maxLen = DEFAULT_MAX_LEN
+	}
+	return &SlowLog{threshold: int64(threshold), maxLen: maxLen}
+}
+
+// Change settings, entries exceeding new max length are dropped.
+func (l *SlowLog) Configure(threshold time.Duration, maxLen int) {
+	if maxLen < 1 {
+		maxLen = DEFAULT_MAX_LEN
+	}
+	atomic.StoreInt64(&l.threshold, int64(threshold))
+
+	l.Lock()
+	defer l.Unlock()
+	entries := l.ordered()
+	if len(entries) > maxLen {
+		entries = entries[len(entries)-maxLen:]
+	}
+	l.entries, l.head, l.maxLen = entries, 0, maxLen
+}
+
+func (l *SlowLog) Threshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&l.threshold))
+}
+
+// Add operation started at given time if it is slow enough.
+func (l *SlowLog) Record(source, op, key, clientAddr string, start time.Time) {
+	l.RecordRequest(source, op, key, clientAddr, "", start)
+}
+
+// Same as Record for operation made by request with given ID.
+func (l *SlowLog) RecordRequest(source, op, key, clientAddr, requestID string, start time.Time) {
+	threshold := atomic.LoadInt64(&l.threshold)
+	if threshold < 0 {
+		return
+	}
+	duration := time.Since(start)
+	if int64(duration) < threshold {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.nextID++
+	entry := Entry{
+		ID:         l.nextID,
+		Time:       start,
+		Duration:   duration,
+		Source:     source,
+		Operation:  op,
+		Key:        key,
+		ClientAddr: clientAddr,
+		RequestID:  requestID}
+
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.head] = entry
+	l.head = (l.head + 1) % len(l.entries)
+}
+
+// Return up to n latest entries, newest first, all entries if n < 1.
+func (l *SlowLog) Entries(n int) []Entry {
+	l.Lock()
+	entries := l.ordered()
+	l.Unlock()
+
+	if n < 1 || n > len(entries) {
+		n = len(entries)
+	}
+	res := make([]Entry, n)
+	for i := 0; i < n; i++ {
+		res[i] = entries[len(entries)-1-i]
+	}
+	return res
+}
+
+func (l *SlowLog) Len() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.entries)
+}
+
+func (l *SlowLog) Reset() {
+	l.Lock()
+	l.entries, l.head = nil, 0
+	l.Unlock()
+}
+
+/* internals */
+
+// entries from oldest to newest, not thread-safe!
+func (l *SlowLog) ordered() []Entry {
+	res := make([]Entry, 0, len(l.entries))
+	res = append(res, l.entries[l.head:]...)
+	return append(res, l.entries[:l.head]...)
+}
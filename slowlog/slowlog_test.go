@@ -0,0 +1,53 @@
+package slowlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowLogThreshold(t *testing.T) {
+	l := New(time.Hour, 10)
+	l.Record(SOURCE_STORAGE, "get", "fast", "", time.Now())
+	l.Record(SOURCE_STORAGE, "get", "slow", "", time.Now().Add(-2*time.Hour))
+	entries := l.Entries(0)
+	if len(entries) != 1 || entries[0].Key != "slow" || entries[0].Duration < 2*time.Hour {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	// disabled
+	l = New(-1, 10)
+	l.Record(SOURCE_STORAGE, "get", "slow", "", time.Now().Add(-2*time.Hour))
+	if l.Len() != 0 {
+		t.Error("disabled slow log records entries")
+	}
+}
+
+func TestSlowLogBounded(t *testing.T) {
+	l := New(0, 3)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		l.Record(SOURCE_REST, "GetItem", key, "127.0.0.1:1234", time.Now())
+	}
+
+	entries := l.Entries(0)
+	if len(entries) != 3 || entries[0].Key != "e" || entries[2].Key != "c" || entries[0].ID != 5 {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if latest := l.Entries(1); len(latest) != 1 || latest[0].Key != "e" {
+		t.Errorf("unexpected latest entry: %+v", latest)
+	}
+
+	// shrink
+	l.Configure(0, 2)
+	if entries := l.Entries(0); len(entries) != 2 || entries[0].Key != "e" || entries[1].Key != "d" {
+		t.Errorf("unexpected entries after shrink: %+v", entries)
+	}
+	l.Record(SOURCE_REST, "GetItem", "f", "", time.Now())
+	if entries := l.Entries(0); len(entries) != 2 || entries[0].Key != "f" || entries[1].Key != "e" {
+		t.Errorf("unexpected entries after shrink: %+v", entries)
+	}
+
+	l.Reset()
+	if l.Len() != 0 || len(l.Entries(0)) != 0 {
+		t.Error("slow log is not empty after reset")
+	}
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Analysis walks storage shard by shard and reports where memory goes:
+largest keys, memory per key prefix and TTL distribution. Sizes are estimated
+per key the same way as in ShardStats of map storage, key and element overhead
+included, so totals of slab storage differ slightly.
+Sharded engine is read in batches of keys, so that writers are never blocked
+for long, RCU engine is read without locking. Keys are reported as stored
+in their namespaces.
+*/
+const (
+	ANALYZE_DEFAULT_TOP       = 10
+	ANALYZE_DEFAULT_DELIMITER = ":"
+	// keys read under single shard lock
+	ANALYZE_BATCH = 1000
+)
+
+// Upper bounds of TTL histogram buckets, the last bucket is unbounded.
+// Keys expired but not swept yet fall into the first one.
+var ANALYZE_TTL_BUCKETS = []time.Duration{
+	0,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour}
+
+type AnalyzeOptions struct {
+	// number of largest keys, prefixes and hottest keys reported
+	Top int
+	// prefix is a part of key before the first delimiter, empty for keys without one
+	Delimiter string
+	// fraction of keys sampled for hit counts, 0 disables hot keys report
+	HotSample float64
+}
+
+type KeyStat struct {
+	Namespace string
+	Key       string
+	Type      ValueType
+	// estimated size of key and value
+	Bytes int
+	Hits  int64
+	TTL   time.Duration
+}
+
+type PrefixStat struct {
+	Namespace string
+	Prefix    string
+	Keys      int
+	Bytes     int
+}
+
+type TTLBucket struct {
+	// math.MaxInt64 for the last bucket
+	Max   time.Duration
+	Keys  int
+	Bytes int
+}
+
+type Analysis struct {
+	Keys  int
+	Bytes int
+	// descending by size
+	Biggest []KeyStat
+	// descending by size
+	Prefixes []PrefixStat
+	TTL      []TTLBucket
+	// descending by hits among sampled keys, empty without sampling
+	Hottest []KeyStat
+	Sampled int
+}
+
+func (c ConcurrentMap) Analyze(opts AnalyzeOptions) *Analysis {
+	defer opAnalyze.track("", time.Now())
+	a := newAnalyzer(opts)
+	for _, shard := range c {
+		shard.RLock()
+		keys := shard.getShardKeys("")
+		shard.RUnlock()
+
+		// keys changed between batches are analyzed as found
+		for len(keys) > 0 {
+			batch := keys
+			if len(batch) > ANALYZE_BATCH {
+				batch = batch[:ANALYZE_BATCH]
+			}
+			keys = keys[len(batch):]
+
+			shard.RLock()
+			for _, k := range batch {
+				if value, ok := shard.getItem(k); ok {
+					a.add(k, TYPE_STRING, len(value), shard.meta.get(k))
+				} else if s, ok := shard.Structures[k]; ok {
+					a.add(k, s.Type, s.size(), shard.meta.get(k))
+				}
+			}
+			shard.RUnlock()
+		}
+	}
+	return a.result()
+}
+
+func (c *RCUMap) Analyze(opts AnalyzeOptions) *Analysis {
+	defer opAnalyze.track("", time.Now())
+	a := newAnalyzer(opts)
+	for _, shard := range c.shards {
+		d := shard.load()
+		for key, k := range d.keys {
+			if k.structure == nil {
+				a.add(key, TYPE_STRING, len(k.value), d.keyMeta(k))
+			} else {
+				a.add(key, k.structure.Type, k.structure.size(), d.keyMeta(k))
+			}
+		}
+	}
+	return a.result()
+}
+
+// Analyze snapshot without restoring it, values are measured uncompressed.
+// TTL is computed relative to current time, so keys of old snapshot may be expired.
+func AnalyzeSnapshot(snapshot []byte, opts AnalyzeOptions) (*Analysis, error) {
+	storageDump, err := deserializeDump(snapshot, SNAPSHOT_MAX_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	a := newAnalyzer(opts)
+	for _, shardDump := range storageDump {
+		meta := shardDump.getMeta()
+		for k, v := range shardDump.Items {
+			m := meta[k]
+			a.add(k, TYPE_STRING, len(v), &m)
+		}
+		for k, s := range shardDump.getStructures() {
+			m := meta[k]
+			a.add(k, s.Type, s.size(), &m)
+		}
+	}
+	return a.result(), nil
+}
+
+/* internals */
+
+type analyzer struct {
+	opts     AnalyzeOptions
+	now      int64
+	keys     int
+	bytes    int
+	biggest  *topKeys
+	hottest  *topKeys
+	sampled  int
+	prefixes map[string]*PrefixStat
+	ttl      []TTLBucket
+}
+
+func newAnalyzer(opts AnalyzeOptions) *analyzer {
+	if opts.Top <= 0 {
+		opts.Top = ANALYZE_DEFAULT_TOP
+	}
+	if opts.Delimiter == "" {
+		opts.Delimiter = ANALYZE_DEFAULT_DELIMITER
+	}
+	a := &analyzer{
+		opts: opts,
+		now:  time.Now().UnixNano(),
+		biggest: &topKeys{limit: opts.Top, less: func(a, b KeyStat) bool {
+			return a.Bytes < b.Bytes
+		}},
+		hottest: &topKeys{limit: opts.Top, less: func(a, b KeyStat) bool {
+			return a.Hits < b.Hits
+		}},
+		prefixes: make(map[string]*PrefixStat),
+		ttl:      make([]TTLBucket, len(ANALYZE_TTL_BUCKETS)+1)}
+	for i, max := range ANALYZE_TTL_BUCKETS {
+		a.ttl[i].Max = max
+	}
+	a.ttl[len(ANALYZE_TTL_BUCKETS)].Max = math.MaxInt64
+	return a
+}
+
+// meta may be missing for key written concurrently
+func (a *analyzer) add(key string, t ValueType, size int, meta *KeyMeta) {
+	ns, k := splitNamespace(key)
+	stat := KeyStat{Namespace: ns, Key: k, Type: t, Bytes: len(key) + size + ELEMENT_OVERHEAD}
+	if meta != nil {
+		m := meta.load()
+		stat.Hits = m.Hits
+		stat.TTL = time.Duration(m.Expires - a.now)
+	}
+
+	a.keys++
+	a.bytes += stat.Bytes
+	a.biggest.add(stat)
+
+	prefix := ""
+	if i := strings.Index(k, a.opts.Delimiter); i >= 0 {
+		prefix = k[:i]
+	}
+	p, ok := a.prefixes[namespacePrefix(ns)+prefix]
+	if !ok {
+		p = &PrefixStat{Namespace: ns, Prefix: prefix}
+		a.prefixes[namespacePrefix(ns)+prefix] = p
+	}
+	p.Keys++
+	p.Bytes += stat.Bytes
+
+	b := sort.Search(len(ANALYZE_TTL_BUCKETS), func(i int) bool {
+		return stat.TTL <= ANALYZE_TTL_BUCKETS[i]
+	})
+	a.ttl[b].Keys++
+	a.ttl[b].Bytes += stat.Bytes
+
+	if meta != nil && a.opts.HotSample > 0 && rand.Float64() < a.opts.HotSample {
+		a.sampled++
+		a.hottest.add(stat)
+	}
+}
+
+func (a *analyzer) result() *Analysis {
+	prefixes := make([]PrefixStat, 0, len(a.prefixes))
+	for _, p := range a.prefixes {
+		prefixes = append(prefixes, *p)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Bytes != prefixes[j].Bytes {
+			return prefixes[i].Bytes > prefixes[j].Bytes
+		}
+		return prefixes[i].Prefix < prefixes[j].Prefix
+	})
+	if len(prefixes) > a.opts.Top {
+		prefixes = prefixes[:a.opts.Top]
+	}
+
+	return &Analysis{
+		Keys:     a.keys,
+		Bytes:    a.bytes,
+		Biggest:  a.biggest.sorted(),
+		Prefixes: prefixes,
+		TTL:      a.ttl,
+		Hottest:  a.hottest.sorted(),
+		Sampled:  a.sampled}
+}
+
+// keeps limited number of greatest keys, min-heap
+type topKeys struct {
+	stats []KeyStat
+	limit int
+	less  func(a, b KeyStat) bool
+}
+
+func (t *topKeys) Len() int {
+	return len(t.stats)
+}
+
+func (t *topKeys) Less(i, j int) bool {
+	return t.less(t.stats[i], t.stats[j])
+}
+
+func (t *topKeys) Swap(i, j int) {
+	t.stats[i], t.stats[j] = t.stats[j], t.stats[i]
+}
+
+func (t *topKeys) Push(s interface{}) {
+	t.stats = append(t.stats, s.(KeyStat))
+}
+
+func (t *topKeys) Pop() interface{} {
+	s := t.stats[len(t.stats)-1]
+	t.stats = t.stats[:len(t.stats)-1]
+	return s
+}
+
+func (t *topKeys) add(s KeyStat) {
+	if len(t.stats) < t.limit {
+		heap.Push(t, s)
+	} else if t.less(t.stats[0], s) {
+		t.stats[0] = s
+		heap.Fix(t, 0)
+	}
+}
+
+// greatest first
+func (t *topKeys) sorted() []KeyStat {
+	stats := append([]KeyStat{}, t.stats...)
+	sort.Slice(stats, func(i, j int) bool {
+		return t.less(stats[j], stats[i])
+	})
+	return stats
+}
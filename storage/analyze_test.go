@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreAnalyze(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		store.Set("user:1", []byte("short"), time.Minute)
+		store.Set("user:2", []byte(strings.Repeat("v", 100)), time.Hour)
+		store.Set("plain", []byte("value"), 48*time.Hour)
+		store.SAdd("tags:all", 30*time.Second, "a", "b", "c")
+		NewNamespace(store, "sessions").Set("user:3", []byte("session"), time.Minute)
+		for i := 0; i < 3; i++ {
+			store.Get("plain")
+		}
+
+		a := store.Analyze(AnalyzeOptions{Top: 2, HotSample: 1})
+		// key, value or set members with element overhead each
+		bytes := (6 + 5 + 16) + (6 + 100 + 16) + (5 + 5 + 16) + (8 + 3*(1+16) + 16) + (len("sessions\x1fuser:3") + 7 + 16)
+		if a.Keys != 5 || a.Bytes != bytes {
+			t.Errorf("unexpected totals: %d keys, %d bytes, expected %d", a.Keys, a.Bytes, bytes)
+		}
+
+		if len(a.Biggest) != 2 || a.Biggest[0].Key != "user:2" || a.Biggest[0].Bytes < 100 {
+			t.Errorf("unexpected biggest keys: %+v", a.Biggest)
+		}
+
+		// default delimiter, namespaces are kept apart
+		if len(a.Prefixes) != 2 || a.Prefixes[0].Prefix != "user" || a.Prefixes[0].Namespace != "" ||
+			a.Prefixes[0].Keys != 2 {
+			t.Errorf("unexpected prefixes: %+v", a.Prefixes)
+		}
+
+		ttlKeys := make(map[time.Duration]int)
+		for _, b := range a.TTL {
+			ttlKeys[b.Max] = b.Keys
+		}
+		if len(a.TTL) != len(ANALYZE_TTL_BUCKETS)+1 || ttlKeys[time.Minute] != 3 ||
+			ttlKeys[time.Hour] != 1 || ttlKeys[7*24*time.Hour] != 1 {
+			t.Errorf("unexpected TTL histogram: %+v", a.TTL)
+		}
+
+		if a.Sampled != 5 || len(a.Hottest) != 2 || a.Hottest[0].Key != "plain" || a.Hottest[0].Hits != 3 {
+			t.Errorf("unexpected hottest keys: %+v", a.Hottest)
+		}
+		if a := store.Analyze(AnalyzeOptions{}); a.Sampled != 0 || len(a.Hottest) != 0 {
+			t.Errorf("keys are sampled by default: %+v", a.Hottest)
+		}
+
+		// custom delimiter, keys without it have empty prefix
+		a = store.Analyze(AnalyzeOptions{Delimiter: "-"})
+		if len(a.Prefixes) != 2 || a.Prefixes[0].Prefix != "" || a.Prefixes[0].Keys != 4 {
+			t.Errorf("unexpected prefixes: %+v", a.Prefixes)
+		}
+	})
+}
+
+func TestAnalyzeSnapshot(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		store.Set("key:1", []byte("value"), time.Minute)
+		store.HSet("key:2", time.Hour, map[string][]byte{"field": []byte("value")})
+		store.Get("key:1")
+		dump, err := store.DumpStorage()
+		if err != nil {
+			t.Fatalf("dump: %s", err)
+		}
+
+		a, err := AnalyzeSnapshot(dump, AnalyzeOptions{HotSample: 1})
+		if err != nil {
+			t.Fatalf("analyze: %s", err)
+		}
+		if live := store.Analyze(AnalyzeOptions{}); a.Keys != 2 || a.Bytes != live.Bytes {
+			t.Errorf("unexpected totals: %d keys, %d bytes, expected %d", a.Keys, a.Bytes, live.Bytes)
+		}
+		if len(a.Hottest) != 2 || a.Hottest[0].Key != "key:1" || a.Hottest[0].Hits != 1 {
+			t.Errorf("hits are not read from snapshot: %+v", a.Hottest)
+		}
+
+		if _, err := AnalyzeSnapshot([]byte("garbage"), AnalyzeOptions{}); err == nil {
+			t.Error("bad snapshot is analyzed")
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"github.com/dgtony/gcache/utils"
+	"io"
+	"io/ioutil"
+)
+
+// Decompressed data is limited, so that small crafted payload can't exhaust memory.
+var ErrDecompressedTooBig = errors.New("decompressed data exceeds size limit")
+
+// Compression settings of storage, immutable.
+type compression struct {
+	// plain values of at least threshold bytes are compressed, 0 disables
+	threshold int
+	// compress whole snapshots
+	snapshots bool
+	// bounds of decompressed value and snapshot in bytes
+	valueMaxSize    int
+	snapshotMaxSize int
+}
+
+func newCompression(conf *utils.Config) compression {
+	return compression{
+		threshold:       conf.Storage.CompressThreshold,
+		snapshots:       conf.Storage.CompressSnapshots,
+		valueMaxSize:    newLimits(conf).maxValueSize(),
+		snapshotMaxSize: orDefault(conf.Storage.SnapshotMaxSize<<20, SNAPSHOT_MAX_SIZE)}
+}
+
+// Compress value if it's large enough and compression pays off.
+// Values beyond any limit, e.g. restored after limits are lowered,
+// are kept plain, so that every packed value could be unpacked.
+func (c compression) pack(value []byte) ([]byte, bool) {
+	if c.threshold == 0 || len(value) < c.threshold || len(value) > c.valueMaxSize {
+		return value, false
+	}
+	packed, err := compress(value)
+	if err != nil || len(packed) >= len(value) {
+		return value, false
+	}
+	return packed, true
+}
+
+// Decompress value stored with the key, failure is treated as missing value.
+func (c compression) unpackValue(key string, packed []byte) ([]byte, bool) {
+	value, err := decompress(packed, c.valueMaxSize)
+	if err != nil {
+		logger.Errorf("cannot decompress value of %s: %s", key, err)
+		return nil, false
+	}
+	return value, true
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	w, err := flate.NewWriter(&buff, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// Decompress data of at most maxSize bytes, ErrDecompressedTooBig otherwise.
+func decompress(data []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, ErrDecompressedTooBig
+	}
+	return out, nil
+}
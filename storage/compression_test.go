@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoreCompression(t *testing.T) {
+	setup_logger()
+	for _, engine := range []string{ENGINE_SHARDED, testSlabEngine} {
+		conf := getEngineConfig(engine, 2)
+		conf.Storage.CompressThreshold = 1024
+		conf.Storage.CompressSnapshots = true
+		store, err := NewStore(conf)
+		if err != nil {
+			t.Fatalf("%s: create storage: %s", engine, err)
+		}
+		core := store.(*ConcurrentMap)
+
+		large := bytes.Repeat([]byte(`{"field": "value"}`), 1000)
+		core.Set("large", large, time.Minute)
+		core.Set("small", []byte("value"), time.Minute)
+
+		shard, _ := core.getShard("large")
+		if stored, _ := shard.getItem("large"); !shard.compressed["large"] || len(stored) >= len(large) {
+			t.Errorf("%s: large value is not compressed", engine)
+		}
+		if shard, _ := core.getShard("small"); shard.compressed["small"] {
+			t.Errorf("%s: small value is compressed", engine)
+		}
+		if value, ok := core.Get("large"); !ok || !bytes.Equal(value, large) {
+			t.Errorf("%s: large value is not decompressed", engine)
+		}
+		if stats := core.ShardStats(); stats[0].Bytes+stats[1].Bytes >= len(large) {
+			t.Errorf("%s: stats don't reflect compression: %v", engine, stats)
+		}
+
+		// overwritten with small value
+		core.Set("large", []byte("small now"), time.Minute)
+		if value, _ := core.Get("large"); string(value) != "small now" || shard.compressed["large"] {
+			t.Errorf("%s: overwritten value: %q", engine, value)
+		}
+		core.Set("large", large, time.Minute)
+
+		// snapshot keeps raw values and could be restored without compression
+		dump, err := core.DumpStorage()
+		if err != nil {
+			t.Fatalf("%s: dump: %s", engine, err)
+		}
+		if len(dump) >= len(large) {
+			t.Errorf("%s: snapshot is not compressed: %d bytes", engine, len(dump))
+		}
+		for _, target := range testEngines {
+			restored, err := NewStoreFromDump(getEngineConfig(target, 2), dump)
+			if err != nil {
+				t.Fatalf("%s -> %s: restore: %s", engine, target, err)
+			}
+			if value, ok := restored.Get("large"); !ok || !bytes.Equal(value, large) {
+				t.Errorf("%s -> %s: large value is not restored", engine, target)
+			}
+			restored.Close()
+		}
+
+		// restored values are compressed again
+		core.Remove("large")
+		if err := core.RestoreFromDump(dump); err != nil {
+			t.Fatalf("%s: restore: %s", engine, err)
+		}
+		shard, _ = core.getShard("large")
+		if !shard.compressed["large"] {
+			t.Errorf("%s: restored value is not compressed", engine)
+		}
+		if value, ok := core.Get("large"); !ok || !bytes.Equal(value, large) {
+			t.Errorf("%s: large value is not decompressed after restore", engine)
+		}
+
+		// corrupted value fails snapshot instead of being lost
+		shard.Lock()
+		shard.setItem("large", []byte("not compressed"))
+		shard.Unlock()
+		if _, err := core.DumpStorage(); err == nil || !strings.Contains(err.Error(), "large") {
+			t.Errorf("%s: corrupted value is not reported: %v", engine, err)
+		}
+		core.Close()
+	}
+}
+
+func TestDecompressionLimits(t *testing.T) {
+	setup_logger()
+	zeros := make([]byte, 2<<20)
+	packed, err := compress(zeros)
+	if err != nil {
+		t.Fatalf("compress: %s", err)
+	}
+	if value, err := decompress(packed, len(zeros)); err != nil || len(value) != len(zeros) {
+		t.Errorf("data of exact limit size: %d bytes, %v", len(value), err)
+	}
+	if _, err := decompress(packed, len(zeros)-1); err != ErrDecompressedTooBig {
+		t.Errorf("oversized data is decompressed: %v", err)
+	}
+
+	// crafted value expanding beyond value limit is treated as missing
+	conf := getEngineConfig(ENGINE_SHARDED, 2)
+	conf.Limits.ValueMaxSize = 1 << 20
+	conf.Storage.CompressThreshold = 1024
+	conf.Storage.SnapshotMaxSize = 1
+	store, err := NewStore(conf)
+	if err != nil {
+		t.Fatalf("create storage: %s", err)
+	}
+	defer store.Close()
+	core := store.(*ConcurrentMap)
+	shard, _ := core.getShard("bomb")
+	shard.Lock()
+	shard.setItem("bomb", packed)
+	shard.compressed["bomb"] = true
+	shard.Unlock()
+	if _, ok := core.Get("bomb"); ok {
+		t.Error("oversized value is decompressed")
+	}
+	if _, err := core.DumpStorage(); err == nil || !strings.Contains(err.Error(), "bomb") {
+		t.Errorf("oversized value is not reported: %v", err)
+	}
+	core.Remove("bomb")
+
+	// compressed snapshot expanding beyond snapshot limit is rejected
+	dump := StorageDump{{Items: map[string][]byte{"large": zeros}}, {}}
+	snapshot, err := serializeDump(dump, true)
+	if err != nil {
+		t.Fatalf("serialize: %s", err)
+	}
+	for _, engine := range testEngines {
+		engineConf := getEngineConfig(engine, 2)
+		engineConf.Storage.SnapshotMaxSize = 1
+		if _, err := NewStoreFromDump(engineConf, snapshot); err != ErrDecompressedTooBig {
+			t.Errorf("%s: oversized snapshot is restored: %v", engine, err)
+		}
+	}
+	if err := core.RestoreFromDump(snapshot); err != ErrDecompressedTooBig {
+		t.Errorf("oversized snapshot is restored: %v", err)
+	}
+	if _, err := AnalyzeSnapshot(snapshot, AnalyzeOptions{}); err != nil {
+		t.Errorf("snapshot within default limit is not analyzed: %s", err)
+	}
+}
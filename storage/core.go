@@ -1,33 +1,57 @@
 package storage
 
 import (
-	"errors"
 	"github.com/dgtony/gcache/utils"
 	"github.com/gobwas/glob"
 	"github.com/op/go-logging"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	MAX_SHARDS = 4096
-	// key length limit
+	MAX_SHARDS = utils.MAX_SHARDS
+	// default key length limit
 	KEY_MAX_LEN = 2048
-	// value size limit up to 10Mb
+	// default value size limit up to 10Mb
 	VALUE_MAX_SIZE = 10485760
+	// default size limit of decompressed snapshot up to 4Gb, the same as replication message limit
+	SNAPSHOT_MAX_SIZE = 4294967296
 )
 
 var logger *logging.Logger
 
+// loggers are shared by all nodes in process
+var loggerOnce sync.Once
+
 func init_logger() {
-	logger = utils.GetLogger("Storage")
+	loggerOnce.Do(func() { logger = utils.GetLogger("Storage") })
 }
 
 type ConcurrentMap []*ConcurrentMapShard
 
 type ConcurrentMapShard struct {
-	Items         map[string][]byte
+	// plain values, nil when values are kept in slab
+	Items map[string][]byte
+	slab  *slab
+	// storage mode, never changed
+	slabValues bool
+	// keys of compressed plain values
+	compressed  map[string]bool
+	compression compression
+	limits      limits
+	// values of complex types: lists, hashes, sets etc.
+	Structures    map[string]*Structure
 	KeyExpiration ExpireQueue
+	// metadata of every key
+	meta metaTable
+	// estimated size of plain values in map (slab keeps its own) and of structures
+	itemBytes   int
+	structBytes int
+	// expiration sweep control shared by all shards
+	sweeper *sweeper
+	// readers take shared lock, writers - exclusive one
 	sync.RWMutex
 }
 
@@ -41,18 +65,15 @@ func MakeStorageEmpty(conf *utils.Config) (*ConcurrentMap, error) {
 	logger.Debugf("create storage with %d shards", numShards)
 
 	if numShards < 1 || numShards > MAX_SHARDS {
-		return nil, errors.New("wrong number of shards")
+		return nil, ErrBadShardNumber
 	}
 
 	m := make(ConcurrentMap, numShards)
 	for i := 0; i < numShards; i++ {
-		m[i] = &ConcurrentMapShard{
-			Items:         make(map[string][]byte),
-			KeyExpiration: NewExpireQueue()}
+		m[i] = newShard(conf)
 	}
 
-	cleanPeriod := time.Duration(conf.Storage.ExpiredKeyCheckInterval) * time.Second
-	m.runExpKeyCleaning(cleanPeriod)
+	m.runExpKeyCleaning(time.Duration(conf.Storage.ExpiredKeyCheckInterval) * time.Second)
 	return &m, nil
 }
 
@@ -60,7 +81,7 @@ func MakeStorageFromDump(conf *utils.Config, snapshot []byte) (*ConcurrentMap, e
 	init_logger()
 
 	// decode snapshot
-	storageDump, err := deserializeDump(snapshot)
+	storageDump, err := deserializeDump(snapshot, newCompression(conf).snapshotMaxSize)
 	if err != nil {
 		return nil, err
 	}
@@ -69,50 +90,78 @@ func MakeStorageFromDump(conf *utils.Config, snapshot []byte) (*ConcurrentMap, e
 	numShards := len(storageDump)
 	m := make(ConcurrentMap, numShards)
 	for i := 0; i < numShards; i++ {
-		m[i] = &ConcurrentMapShard{
-			Items:         storageDump[i].Items,
-			KeyExpiration: storageDump[i].KeyExpiration}
+		m[i] = newShard(conf)
+		m[i].setItems(m[i].prepareItems(storageDump[i].getItems()))
+		m[i].setStructures(storageDump[i].getStructures())
+		m[i].KeyExpiration = storageDump[i].KeyExpiration
+		m[i].meta = newMetaTable(storageDump[i].getMeta())
 	}
-	cleanPeriod := time.Duration(conf.Storage.ExpiredKeyCheckInterval) * time.Second
-	m.runExpKeyCleaning(cleanPeriod)
+	m.runExpKeyCleaning(time.Duration(conf.Storage.ExpiredKeyCheckInterval) * time.Second)
 	return &m, nil
 }
 
 func (c *ConcurrentMap) Get(key string) ([]byte, bool) {
-	shard, ok := c.getShard(key)
-	if !ok {
+	defer opGet.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
 		return nil, false
 	}
 
-	shard.Lock()
-	value, ok := shard.Items[key]
-	shard.Unlock()
+	shard.RLock()
+	value, ok := shard.getItem(key)
+	packed := ok && shard.compressed[key]
+	if ok {
+		shard.accessed(key)
+	}
+	shard.RUnlock()
+	if packed {
+		value, ok = shard.compression.unpackValue(key, value)
+	}
+	countLookup(ok)
 	return value, ok
 }
 
-func (c *ConcurrentMap) Set(key string, value []byte, ttl time.Duration) bool {
-	shard, ok := c.getShard(key)
-	if !ok || !validValue(value) {
-		return false
+func (c *ConcurrentMap) Set(key string, value []byte, ttl time.Duration) error {
+	defer opSet.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return err
+	}
+	if err := shard.limits.checkValues(key, value); err != nil {
+		return err
 	}
+	value, packed := shard.compression.pack(value)
 	shard.Lock()
-	shard.Items[key] = value
-	shard.KeyExpiration.InsertKey(key, ttl)
+	shard.setItem(key, value)
+	if packed {
+		shard.compressed[key] = true
+	} else {
+		delete(shard.compressed, key)
+	}
+	shard.removeStructure(key)
+	shard.written(key, ttl)
 	shard.Unlock()
-	return true
+	return nil
 }
 
 func (c *ConcurrentMap) Remove(key string) {
-	shard, ok := c.getShard(key)
-	if !ok {
+	defer opRemove.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
 		return
 	}
 	shard.Lock()
-	delete(shard.Items, key)
+	shard.removeKey(key)
 	shard.Unlock()
 }
 
 func (c ConcurrentMap) Keys() []string {
+	return c.KeysPrefix("")
+}
+
+// Return keys starting with given prefix, filtered by shards.
+func (c ConcurrentMap) KeysPrefix(prefix string) []string {
+	defer opKeys.track(prefix, time.Now())
 	numShards := len(c)
 	resChan := make(chan []string, numShards)
 
@@ -120,9 +169,9 @@ func (c ConcurrentMap) Keys() []string {
 	for i := 0; i < numShards; i++ {
 		go func(shardIndex int) {
 			shard := c[shardIndex]
-			shard.Lock()
-			shardKeys := shard.getShardKeys()
-			shard.Unlock()
+			shard.RLock()
+			shardKeys := shard.getShardKeys(prefix)
+			shard.RUnlock()
 			resChan <- shardKeys
 		}(i)
 	}
@@ -140,69 +189,292 @@ func (c ConcurrentMap) Keys() []string {
 // return keys according to given mask
 // use glob pattern matching
 func (c ConcurrentMap) KeysMask(mask string) ([]string, bool) {
+	return matchKeys(c.Keys(), mask)
+}
+
+// Remove all keys, locking one shard at a time.
+func (c ConcurrentMap) Flush() int {
+	defer opFlush.track("", time.Now())
+	removed := 0
+	for _, shard := range c {
+		shard.Lock()
+		removed += shard.itemCount() + len(shard.Structures)
+		shard.setItems(shard.prepareItems(make(map[string][]byte)))
+		shard.setStructures(make(map[string]*Structure))
+		shard.KeyExpiration = NewExpireQueue()
+		shard.meta = newMetaTable(nil)
+		shard.Unlock()
+	}
+	return removed
+}
+
+// Remove keys matching glob mask, locking one shard at a time.
+func (c ConcurrentMap) RemoveMask(mask string) (int, bool) {
+	defer opRemoveMask.track(mask, time.Now())
 	g, err := glob.Compile(mask)
 	if err != nil {
-		return nil, false
+		return 0, false
 	}
-	filtered := make([]string, 0)
-	for _, s := range c.Keys() {
-		if g.Match(s) {
-			filtered = append(filtered, s)
+	removed := 0
+	for _, shard := range c {
+		shard.Lock()
+		for _, k := range shard.getShardKeys("") {
+			if g.Match(k) {
+				shard.removeKey(k)
+				removed++
+			}
 		}
+		shard.KeyExpiration.removeMatching(g.Match)
+		shard.Unlock()
 	}
-	return filtered, true
+	return removed, true
+}
+
+// Change expiration sweep interval, applied since the next sweep.
+func (c ConcurrentMap) SetSweepInterval(interval time.Duration) {
+	if len(c) == 0 {
+		return
+	}
+	c[0].sweeper.setInterval(interval)
+}
+
+func (c ConcurrentMap) SweepInterval() time.Duration {
+	if len(c) == 0 {
+		return 0
+	}
+	return c[0].sweeper.getInterval()
+}
+
+// Stop background expiration sweeps, stored data is still accessible.
+func (c ConcurrentMap) Close() {
+	if len(c) == 0 {
+		return
+	}
+	c[0].sweeper.close()
 }
 
 /* internals */
 
-func (c ConcurrentMap) runExpKeyCleaning(cleanPeriod time.Duration) {
-	for _, shard := range c {
-		// run separate cleaner process for each shard
-		go func(shard *ConcurrentMapShard) {
+type sweeper struct {
+	// nanoseconds
+	interval int64
+	stop     chan struct{}
+	once     sync.Once
+}
+
+func newSweeper(interval time.Duration) *sweeper {
+	return &sweeper{interval: int64(interval), stop: make(chan struct{})}
+}
+
+// Run separate cleaner process for each shard,
+// sweep removes expired keys of given shard and returns their number.
+func (sw *sweeper) run(numShards int, sweep func(shard int) int) {
+	for i := 0; i < numShards; i++ {
+		go func(shardIndex int) {
 			for {
-				shard.Lock()
-				ok, expiredKeys := shard.KeyExpiration.GetExpiredKeys()
-				if ok {
-					for _, k := range expiredKeys {
-						delete(shard.Items, k)
-					}
+				start := time.Now()
+				expired := sweep(shardIndex)
+				sweepDuration.With().Observe(time.Since(start).Seconds())
+				sweepKeys.With().Observe(float64(expired))
+				expiredKeys.With().Add(uint64(expired))
+				select {
+				case <-sw.stop:
+					return
+				case <-time.After(time.Duration(atomic.LoadInt64(&sw.interval))):
 				}
-				shard.Unlock()
-				time.Sleep(cleanPeriod)
 			}
-		}(shard)
+		}(i)
 	}
 }
 
-// Return shard for given key
-func (c ConcurrentMap) getShard(key string) (*ConcurrentMapShard, bool) {
-	if !validKey(key) {
-		return nil, false
+func (sw *sweeper) setInterval(interval time.Duration) {
+	atomic.StoreInt64(&sw.interval, int64(interval))
+}
+
+func (sw *sweeper) getInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&sw.interval))
+}
+
+func (sw *sweeper) close() {
+	sw.once.Do(func() { close(sw.stop) })
+}
+
+func (c ConcurrentMap) runExpKeyCleaning(cleanPeriod time.Duration) {
+	sw := newSweeper(cleanPeriod)
+	for _, shard := range c {
+		shard.sweeper = sw
 	}
-	return c[uint(utils.FNVSum64(key))%uint(len(c))], true
+	sw.run(len(c), func(i int) int {
+		shard := c[i]
+		shard.Lock()
+		_, keys := shard.KeyExpiration.GetExpiredKeys()
+		for _, k := range keys {
+			shard.removeKey(k)
+		}
+		shard.Unlock()
+		return len(keys)
+	})
 }
 
-func validKey(key string) bool {
-	if len(key) > KEY_MAX_LEN {
-		return false
+// Return shard for given key, error if key is invalid
+func (c ConcurrentMap) getShard(key string) (*ConcurrentMapShard, error) {
+	shard := c[uint(utils.FNVSum64(key))%uint(len(c))]
+	if err := shard.limits.checkKey(key); err != nil {
+		return nil, err
 	}
-	return true
+	return shard, nil
 }
 
-func validValue(value []byte) bool {
-	if len(value) > VALUE_MAX_SIZE {
-		return false
+// filter keys with glob pattern, false if pattern is malformed
+func matchKeys(keys []string, mask string) ([]string, bool) {
+	g, err := glob.Compile(mask)
+	if err != nil {
+		return nil, false
+	}
+	filtered := make([]string, 0)
+	for _, s := range keys {
+		if g.Match(s) {
+			filtered = append(filtered, s)
+		}
 	}
-	return true
+	return filtered, true
 }
 
 // do not use outside - not thread-safe!
-func (c *ConcurrentMapShard) getShardKeys() []string {
-	i := 0
-	keys := make([]string, len(c.Items))
-	for k, _ := range c.Items {
-		keys[i] = k
-		i++
+func (c *ConcurrentMapShard) getShardKeys(prefix string) []string {
+	var keys []string
+	if prefix == "" {
+		keys = make([]string, 0, c.itemCount()+len(c.Structures))
+	} else {
+		keys = make([]string, 0)
+	}
+	c.eachItem(func(k string, _ []byte) {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	})
+	for k := range c.Structures {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
 	}
 	return keys
 }
+
+/* shard plain values, either in map or in slab */
+
+func newShard(conf *utils.Config) *ConcurrentMapShard {
+	shard := &ConcurrentMapShard{
+		Structures:    make(map[string]*Structure),
+		KeyExpiration: NewExpireQueue(),
+		meta:          newMetaTable(nil),
+		compressed:    make(map[string]bool),
+		compression:   newCompression(conf),
+		limits:        newLimits(conf)}
+	if conf.Storage.SlabValues {
+		shard.slabValues = true
+		shard.slab = newSlab(SLAB_INITIAL_SIZE)
+	} else {
+		shard.Items = make(map[string][]byte)
+	}
+	return shard
+}
+
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) getItem(key string) ([]byte, bool) {
+	if c.slab != nil {
+		return c.slab.get(key)
+	}
+	value, ok := c.Items[key]
+	return value, ok
+}
+
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) hasItem(key string) bool {
+	_, ok := c.getItem(key)
+	return ok
+}
+
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) setItem(key string, value []byte) {
+	if c.slab != nil {
+		c.slab.set(key, value)
+		return
+	}
+	if old, ok := c.Items[key]; ok {
+		c.itemBytes -= itemSize(key, old)
+	}
+	c.Items[key] = value
+	c.itemBytes += itemSize(key, value)
+}
+
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) removeItem(key string) {
+	delete(c.compressed, key)
+	if c.slab != nil {
+		c.slab.remove(key)
+		return
+	}
+	if old, ok := c.Items[key]; ok {
+		c.itemBytes -= itemSize(key, old)
+		delete(c.Items, key)
+	}
+}
+
+// Plain values prepared to replace shard ones.
+type shardItems struct {
+	items      map[string][]byte
+	slab       *slab
+	compressed map[string]bool
+	bytes      int
+}
+
+// Compress large values and pack them into slab if needed. Only shard
+// settings are read, so that the work is done without holding shard lock.
+func (c *ConcurrentMapShard) prepareItems(items map[string][]byte) shardItems {
+	p := shardItems{compressed: make(map[string]bool)}
+	for k, v := range items {
+		if packed, ok := c.compression.pack(v); ok {
+			items[k] = packed
+			p.compressed[k] = true
+		}
+	}
+	if c.slabValues {
+		p.slab = newSlabFromItems(items)
+	} else {
+		p.items = items
+		p.bytes = dataStats(items, nil).Bytes
+	}
+	return p
+}
+
+// Replace all plain values with prepared ones.
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) setItems(p shardItems) {
+	c.compressed = p.compressed
+	if c.slab != nil {
+		c.slab = p.slab
+		return
+	}
+	c.Items = p.items
+	c.itemBytes = p.bytes
+}
+
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) itemCount() int {
+	if c.slab != nil {
+		return c.slab.len()
+	}
+	return len(c.Items)
+}
+
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) eachItem(f func(key string, value []byte)) {
+	if c.slab != nil {
+		c.slab.each(f)
+		return
+	}
+	for k, v := range c.Items {
+		f(k, v)
+	}
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"fmt"
 	"github.com/dgtony/gcache/utils"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -52,8 +54,8 @@ func TestCoreCRUDValues(t *testing.T) {
 	keyTTL := time.Minute
 	testKV := getTestKV()
 	for k, v := range testKV {
-		if ok := core.Set(k, v, keyTTL); !ok {
-			t.Errorf("cannot insert pair %s:%s with ttl %s", k, v, keyTTL)
+		if err := core.Set(k, v, keyTTL); err != nil {
+			t.Errorf("cannot insert pair %s:%s with ttl %s: %s", k, v, keyTTL, err)
 		}
 	}
 
@@ -98,8 +100,8 @@ func TestCoreIntegrationDumpRestore(t *testing.T) {
 	keyTTL := 1 * time.Minute
 	testKV := getTestKV()
 	for k, v := range testKV {
-		if ok := core.Set(k, v, keyTTL); !ok {
-			t.Errorf("cannot insert pair %s:%s with ttl %s", k, v, keyTTL)
+		if err := core.Set(k, v, keyTTL); err != nil {
+			t.Errorf("cannot insert pair %s:%s with ttl %s: %s", k, v, keyTTL, err)
 		}
 	}
 
@@ -132,6 +134,35 @@ func TestCoreIntegrationDumpRestore(t *testing.T) {
 	}
 }
 
+/*
+Parallel GET benchmarks, readers should scale with GOMAXPROCS:
+
+	go test ./storage -run ^$ -bench GetParallel -cpu 1,2,4,8
+*/
+
+const benchKeys = 1024
+
+// readers spread over all shards
+func BenchmarkCoreGetParallel(b *testing.B) {
+	benchmarkGetParallel(b, 16, func(i uint64) string {
+		return fmt.Sprintf("key%d", i%benchKeys)
+	})
+}
+
+// all readers hit the same key, hence the same shard
+func BenchmarkCoreGetParallelHotKey(b *testing.B) {
+	benchmarkGetParallel(b, 16, func(uint64) string {
+		return "key0"
+	})
+}
+
+// one shard serves all keys
+func BenchmarkCoreGetParallelSingleShard(b *testing.B) {
+	benchmarkGetParallel(b, 1, func(i uint64) string {
+		return fmt.Sprintf("key%d", i%benchKeys)
+	})
+}
+
 /* helpers */
 
 func getTestConfig(numShards int) *utils.Config {
@@ -142,7 +173,9 @@ func getTestConfig(numShards int) *utils.Config {
 			LogOut:    "stdout"},
 		Storage: utils.StorageSettings{
 			NumShards:               numShards,
-			ExpiredKeyCheckInterval: 60}}
+			ExpiredKeyCheckInterval: 60},
+		Limits: utils.LimitsSettings{
+			KeyChars: KEY_CHARS_PRINTABLE}}
 }
 
 func getTestKV() map[string][]byte {
@@ -160,3 +193,37 @@ func setup_logger() {
 		utils.SetupLoggers(logConf)
 	}
 }
+
+func benchmarkGetParallel(b *testing.B, numShards int, key func(i uint64) string) {
+	setup_logger()
+	for _, engine := range testEngines {
+		b.Run(engine, func(b *testing.B) {
+			store, err := NewStore(getEngineConfig(engine, numShards))
+			if err != nil {
+				b.Fatalf("create %s storage: %s", engine, err)
+			}
+			defer store.Close()
+
+			keys := make([]string, benchKeys)
+			for i := range keys {
+				keys[i] = key(uint64(i))
+				store.Set(keys[i], []byte("value"), time.Hour)
+			}
+
+			var worker uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// workers start at different offsets
+				i := atomic.AddUint64(&worker, 1) * 7919
+				for pb.Next() {
+					if _, ok := store.Get(keys[i%benchKeys]); !ok {
+						b.Error("key is not found")
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
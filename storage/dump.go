@@ -3,12 +3,44 @@ package storage
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 )
 
+/*
+Snapshot starts with header: magic, format version and flags,
+followed by gob-encoded StorageDump, compressed with the flag set.
+Snapshots made before header introduction are plain gob and still could be restored.
+*/
+const (
+	DUMP_MAGIC   = "GCDUMP"
+	DUMP_VERSION = 1
+	// header flags
+	DUMP_FLAG_COMPRESSED = 1 << 0
+
+	DUMP_HEADER_SIZE = len(DUMP_MAGIC) + 2
+)
+
+var ErrDumpVersion = errors.New("unsupported snapshot version")
+
 type StorageDump []ShardDump
 type ShardDump struct {
 	Items         map[string][]byte
+	Structures    map[string]*Structure
 	KeyExpiration ExpireQueue
+	Meta          map[string]KeyMeta
+}
+
+// Snapshot cannot be restored into storage with different number of shards.
+type ShardMismatchError struct {
+	Storage int
+	Dump    int
+}
+
+func (e *ShardMismatchError) Error() string {
+	return fmt.Sprintf("cannot restore from dump: storage has %d shards, dump has %d", e.Storage, e.Dump)
 }
 
 // Get current storage snapshot
@@ -16,13 +48,21 @@ func (c ConcurrentMap) DumpStorage() ([]byte, error) {
 	numShards := len(c)
 	fullDump := make([]ShardDump, numShards)
 	for i, shard := range c {
-		shard.Lock()
-		fullDump[i].Items = copyShardItems(shard)
-		fullDump[i].KeyExpiration = copyShardKeyExp(shard)
-		shard.Unlock()
+		shard.RLock()
+		items, packed := copyShardItems(shard)
+		fullDump[i].Structures = copyShardStructures(shard)
+		fullDump[i].KeyExpiration = copyKeyExp(shard.KeyExpiration)
+		fullDump[i].Meta = shard.meta.copy()
+		shard.RUnlock()
+
+		// stored values are never changed in place, so they are unpacked without lock
+		if err := unpackItems(items, packed, shard.compression.valueMaxSize); err != nil {
+			return nil, err
+		}
+		fullDump[i].Items = items
 	}
 
-	serialized, err := serializeDump(fullDump)
+	serialized, err := serializeDump(fullDump, c[0].compression.snapshots)
 	if err != nil {
 		return nil, err
 	}
@@ -33,59 +73,171 @@ func (c ConcurrentMap) DumpStorage() ([]byte, error) {
 // Restore entire storage from snapshot
 // All stored data will be completely replaced
 func (c *ConcurrentMap) RestoreFromDump(snapshot []byte) error {
-	storageDump, err := deserializeDump(snapshot)
+	storageDump, err := deserializeDump(snapshot, (*c)[0].compression.snapshotMaxSize)
 	if err != nil {
 		return err
 	}
 
 	if len(*c) != len(storageDump) {
-		// dunno how to recover in this case
-		panic("cannot restore from dump: shard number doesn't match")
+		// storage is left intact
+		return &ShardMismatchError{Storage: len(*c), Dump: len(storageDump)}
 	}
 
 	// restore each shard separately
+	var wg sync.WaitGroup
 	for i, shardDump := range storageDump {
+		wg.Add(1)
 		go func(shardIndex int, shardDump ShardDump) {
+			defer wg.Done()
 			oldShard := (*c)[shardIndex]
+			// new content is built without lock, readers are blocked only to swap it
+			items := oldShard.prepareItems(shardDump.getItems())
+			structures := shardDump.getStructures()
+			meta := newMetaTable(shardDump.getMeta())
 			oldShard.Lock()
-			oldShard.Items = shardDump.Items
+			oldShard.setItems(items)
+			oldShard.setStructures(structures)
 			oldShard.KeyExpiration = shardDump.KeyExpiration
+			oldShard.meta = meta
 			oldShard.Unlock()
 		}(i, shardDump)
 	}
+	wg.Wait()
 
 	return nil
 }
 
-func copyShardItems(shard *ConcurrentMapShard) map[string][]byte {
-	newShardItems := make(map[string][]byte)
-	for k, v := range shard.Items {
+// Copy plain values as stored, return keys of compressed ones.
+func copyShardItems(shard *ConcurrentMapShard) (map[string][]byte, []string) {
+	newShardItems := make(map[string][]byte, shard.itemCount())
+	packed := make([]string, 0, len(shard.compressed))
+	shard.eachItem(func(k string, v []byte) {
+		if shard.compressed[k] {
+			packed = append(packed, k)
+		}
 		newShardItems[k] = v
+	})
+	return newShardItems, packed
+}
+
+// snapshot keeps values uncompressed, being compressed as a whole
+func unpackItems(items map[string][]byte, packed []string, maxSize int) error {
+	for _, k := range packed {
+		value, err := decompress(items[k], maxSize)
+		if err != nil {
+			return fmt.Errorf("cannot decompress value of %s: %s", k, err)
+		}
+		items[k] = value
 	}
-	return newShardItems
+	return nil
 }
 
-func copyShardKeyExp(shard *ConcurrentMapShard) ExpireQueue {
-	keyExpLen := len(shard.KeyExpiration)
-	newKeyExpirations := make([]*StorageKey, keyExpLen)
+func copyShardStructures(shard *ConcurrentMapShard) map[string]*Structure {
+	newShardStructures := make(map[string]*Structure)
+	for k, v := range shard.Structures {
+		newShardStructures[k] = v.clone()
+	}
+	return newShardStructures
+}
 
-	for i, item := range shard.KeyExpiration {
+func copyKeyExp(queue ExpireQueue) ExpireQueue {
+	newKeyExpirations := make([]*StorageKey, len(queue))
+	for i, item := range queue {
 		newKeyExpirations[i] = &StorageKey{Key: item.Key, Expire: item.Expire}
 	}
 
 	return newKeyExpirations
 }
 
-func serializeDump(dump StorageDump) ([]byte, error) {
+// gob omits empty maps, so restored shard may have none
+func (d ShardDump) getItems() map[string][]byte {
+	if d.Items == nil {
+		return make(map[string][]byte)
+	}
+	return d.Items
+}
+
+// snapshots made before complex types support have no structures
+func (d ShardDump) getStructures() map[string]*Structure {
+	if d.Structures == nil {
+		return make(map[string]*Structure)
+	}
+	for _, s := range d.Structures {
+		s.restoreEmpty()
+	}
+	return d.Structures
+}
+
+// snapshots made before key metadata support have none, keys get new one
+func (d ShardDump) getMeta() map[string]KeyMeta {
+	meta := d.Meta
+	if meta == nil {
+		meta = make(map[string]KeyMeta)
+	}
+	var expires map[string]int64
+	now := time.Now().UnixNano()
+	restore := func(key string) {
+		if _, ok := meta[key]; ok {
+			return
+		}
+		if expires == nil {
+			expires = make(map[string]int64, len(d.KeyExpiration))
+			for _, k := range d.KeyExpiration {
+				expires[k.Key] = k.Expire
+			}
+		}
+		meta[key] = KeyMeta{Created: now, Updated: now, Accessed: now, Expires: expires[key]}
+	}
+	for k := range d.Items {
+		restore(k)
+	}
+	for k := range d.Structures {
+		restore(k)
+	}
+	return meta
+}
+
+func serializeDump(dump StorageDump, compressed bool) ([]byte, error) {
+	var flags byte
+	if compressed {
+		flags |= DUMP_FLAG_COMPRESSED
+	}
 	var buff bytes.Buffer
-	err := gob.NewEncoder(&buff).Encode(dump)
-	return buff.Bytes(), err
+	buff.WriteString(DUMP_MAGIC)
+	buff.Write([]byte{DUMP_VERSION, flags})
+	if err := gob.NewEncoder(&buff).Encode(dump); err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return buff.Bytes(), nil
+	}
+
+	serialized := buff.Bytes()
+	packed, err := compress(serialized[DUMP_HEADER_SIZE:])
+	if err != nil {
+		return nil, err
+	}
+	return append(serialized[:DUMP_HEADER_SIZE], packed...), nil
 }
 
-func deserializeDump(snapshot []byte) (StorageDump, error) {
+// Compressed payload is limited to maxSize bytes after decompression.
+func deserializeDump(snapshot []byte, maxSize int) (StorageDump, error) {
+	payload := snapshot
+	if bytes.HasPrefix(snapshot, []byte(DUMP_MAGIC)) && len(snapshot) >= DUMP_HEADER_SIZE {
+		version, flags := snapshot[len(DUMP_MAGIC)], snapshot[len(DUMP_MAGIC)+1]
+		if version != DUMP_VERSION {
+			return nil, ErrDumpVersion
+		}
+		payload = snapshot[DUMP_HEADER_SIZE:]
+		if flags&DUMP_FLAG_COMPRESSED != 0 {
+			var err error
+			if payload, err = decompress(payload, maxSize); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	var dump StorageDump
-	var buff bytes.Buffer
-	buff.Write(snapshot)
-	err := gob.NewDecoder(&buff).Decode(&dump)
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&dump)
 	return dump, err
 }
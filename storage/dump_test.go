@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/gob"
 	"github.com/dgtony/gcache/utils"
 	"testing"
 	"time"
@@ -28,19 +30,70 @@ func makeTestDump() StorageDump {
 }
 
 func TestCoreDumpSerializeDeserialize(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		dumpOriginal := makeTestDump()
+		ser, err := serializeDump(dumpOriginal, compressed)
+		if err != nil {
+			t.Errorf("dump serialization: %s", err)
+		}
+		flag := ser[len(DUMP_MAGIC)+1]&DUMP_FLAG_COMPRESSED != 0
+		if flag != compressed {
+			t.Errorf("wrong compression flag in dump header: %t", flag)
+		}
+
+		dumpRestored, err := deserializeDump(ser, SNAPSHOT_MAX_SIZE)
+		if err != nil {
+			t.Errorf("dump deserialization: %s", err)
+		}
+
+		if !compareStorageDumps(dumpOriginal, dumpRestored) {
+			t.Errorf("dump serialization is not isomorphic, compressed: %t", compressed)
+		}
+	}
+}
+
+func TestCoreDumpHeader(t *testing.T) {
+	// snapshot without header made by previous versions
 	dumpOriginal := makeTestDump()
-	ser, err := serializeDump(dumpOriginal)
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(dumpOriginal); err != nil {
+		t.Fatalf("dump serialization: %s", err)
+	}
+	if dump, err := deserializeDump(buff.Bytes(), SNAPSHOT_MAX_SIZE); err != nil || !compareStorageDumps(dumpOriginal, dump) {
+		t.Errorf("legacy dump is not restored: %v", err)
+	}
+
+	ser, _ := serializeDump(makeTestDump(), false)
+	ser[len(DUMP_MAGIC)] = DUMP_VERSION + 1
+	if _, err := deserializeDump(ser, SNAPSHOT_MAX_SIZE); err != ErrDumpVersion {
+		t.Errorf("expected version error, got: %v", err)
+	}
+}
+
+func TestCoreDumpRestoreShardMismatch(t *testing.T) {
+	ser, err := serializeDump(makeTestDump(), false)
 	if err != nil {
-		t.Errorf("dump serialization: %s", err)
+		t.Fatalf("dump serialization: %s", err)
 	}
 
-	dumpRestored, err := deserializeDump(ser)
+	conf := utils.DefaultConfig()
+	conf.Storage.NumShards = 3
+	store, err := MakeStorageEmpty(conf)
 	if err != nil {
-		t.Errorf("dump deserialization: %s", err)
+		t.Fatalf("make storage: %s", err)
 	}
+	store.Set("key", []byte("value"), time.Minute)
 
-	if !compareStorageDumps(dumpOriginal, dumpRestored) {
-		t.Error("dump serialization is not isomorphic")
+	err = store.RestoreFromDump(ser)
+	mismatch, ok := err.(*ShardMismatchError)
+	if !ok {
+		t.Fatalf("expected shard mismatch error, got: %v", err)
+	}
+	if mismatch.Storage != 3 || mismatch.Dump != 2 {
+		t.Errorf("wrong shard numbers in error: %+v", mismatch)
+	}
+	if _, found := store.Get("key"); !found {
+		t.Error("storage changed after failed restore")
 	}
 }
 
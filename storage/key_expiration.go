@@ -86,6 +86,21 @@ func (q *ExpireQueue) GetExpiredKeys() (bool, []string) {
 	return false, nil
 }
 
+// drop expiration of removed keys
+func (q *ExpireQueue) removeMatching(match func(string) bool) {
+	kept := (*q)[:0]
+	for _, k := range *q {
+		if !match(k.Key) {
+			kept = append(kept, k)
+		}
+	}
+	for i := len(kept); i < len(*q); i++ {
+		(*q)[i] = nil
+	}
+	*q = kept
+	heap.Init(q)
+}
+
 // clean previous key expiration in case of key update
 func (q *ExpireQueue) removeExisting(key string) {
 	qp := *q
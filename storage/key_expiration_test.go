@@ -2,6 +2,7 @@ package storage
 
 import (
 	"container/heap"
+	"strings"
 	"testing"
 	"time"
 )
@@ -77,3 +78,23 @@ func TestKeyExpireMassInsert(t *testing.T) {
 		t.Error("get expired keys failed: some of long-living expired")
 	}
 }
+
+func TestKeyExpireRemoveMatching(t *testing.T) {
+	keyExpireQueue := NewExpireQueue()
+	for i, key := range []string{"a:1", "b:1", "a:2", "b:2", "c"} {
+		keyExpireQueue.InsertKey(key, time.Duration(i)*time.Millisecond)
+	}
+	keyExpireQueue.removeMatching(func(key string) bool {
+		return strings.HasPrefix(key, "a:")
+	})
+	if keyExpireQueue.Len() != 3 {
+		t.Fatalf("unexpected queue length: %d", keyExpireQueue.Len())
+	}
+
+	// heap order is kept
+	time.Sleep(10 * time.Millisecond)
+	_, expiredKeys := keyExpireQueue.GetExpiredKeys()
+	if strings.Join(expiredKeys, ",") != "b:1,b:2,c" {
+		t.Errorf("unexpected expired keys: %v", expiredKeys)
+	}
+}
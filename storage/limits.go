@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/dgtony/gcache/utils"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// key character policies
+const (
+	KEY_CHARS_ANY       = "any"
+	KEY_CHARS_PRINTABLE = "printable"
+)
+
+// Validation errors, match ErrInvalidKey or ErrInvalidValue with errors.Is.
+var (
+	ErrEmptyKey    = fmt.Errorf("%w: empty key", ErrInvalidKey)
+	ErrKeyTooLong  = fmt.Errorf("%w: key is too long", ErrInvalidKey)
+	ErrKeyChars    = fmt.Errorf("%w: invalid characters in key", ErrInvalidKey)
+	ErrValueTooBig = fmt.Errorf("%w: value is too big", ErrInvalidValue)
+)
+
+// Key and value limits of storage, immutable.
+type limits struct {
+	keyMaxLen    int
+	valueMaxSize int
+	printable    bool
+	// sorted by prefix length, the longest first
+	prefixes []prefixLimits
+	// limits of configured namespaces by name
+	namespaces map[string]prefixLimits
+}
+
+type prefixLimits struct {
+	prefix       string
+	keyMaxLen    int
+	valueMaxSize int
+}
+
+// Zero limits are replaced with defaults, namespace limits are inherited from common ones.
+// Omitted prefix limits are inherited from namespace of the key on lookup.
+func newLimits(conf *utils.Config) limits {
+	l := limits{
+		keyMaxLen:    orDefault(conf.Limits.KeyMaxLen, KEY_MAX_LEN),
+		valueMaxSize: orDefault(conf.Limits.ValueMaxSize, VALUE_MAX_SIZE),
+		printable:    conf.Limits.KeyChars == KEY_CHARS_PRINTABLE,
+		namespaces:   make(map[string]prefixLimits)}
+	for _, p := range conf.Limits.Prefixes {
+		l.prefixes = append(l.prefixes, prefixLimits{
+			prefix:       p.Prefix,
+			keyMaxLen:    p.KeyMaxLen,
+			valueMaxSize: p.ValueMaxSize})
+	}
+	sort.SliceStable(l.prefixes, func(i, j int) bool {
+		return len(l.prefixes[i].prefix) > len(l.prefixes[j].prefix)
+	})
+	for _, ns := range conf.Storage.Namespaces {
+		l.namespaces[ns.Name] = prefixLimits{
+			prefix:       namespacePrefix(ns.Name),
+			keyMaxLen:    orDefault(ns.KeyMaxLen, l.keyMaxLen),
+			valueMaxSize: orDefault(ns.ValueMaxSize, l.valueMaxSize)}
+	}
+	return l
+}
+
+func (l limits) checkKey(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	keyMaxLen, _ := l.forKey(key)
+	if len(key) > keyMaxLen {
+		return ErrKeyTooLong
+	}
+	if l.printable && !printableKey(key) {
+		return ErrKeyChars
+	}
+	return nil
+}
+
+func (l limits) checkValues(key string, values ...[]byte) error {
+	_, valueMaxSize := l.forKey(key)
+	for _, value := range values {
+		if len(value) > valueMaxSize {
+			return ErrValueTooBig
+		}
+	}
+	return nil
+}
+
+// set and sorted set members are limited as values
+func (l limits) checkMembers(key string, members ...string) error {
+	_, valueMaxSize := l.forKey(key)
+	for _, member := range members {
+		if len(member) > valueMaxSize {
+			return ErrValueTooBig
+		}
+	}
+	return nil
+}
+
+// Limits of the longest prefix matching key inside its namespace, otherwise
+// limits of namespace or common ones. Key length limit doesn't include namespace prefix.
+func (l limits) forKey(key string) (keyMaxLen, valueMaxSize int) {
+	ns := prefixLimits{keyMaxLen: l.keyMaxLen, valueMaxSize: l.valueMaxSize}
+	if name, nsKey := splitNamespace(key); name != DEFAULT_NAMESPACE {
+		if nsLimits, ok := l.namespaces[name]; ok {
+			ns, key = nsLimits, nsKey
+		}
+	}
+	for _, p := range l.prefixes {
+		if strings.HasPrefix(key, p.prefix) {
+			return len(ns.prefix) + orDefault(p.keyMaxLen, ns.keyMaxLen), orDefault(p.valueMaxSize, ns.valueMaxSize)
+		}
+	}
+	return len(ns.prefix) + ns.keyMaxLen, ns.valueMaxSize
+}
+
+// the largest value size allowed for any key
+func (l limits) maxValueSize() int {
+	size := l.valueMaxSize
+	for _, p := range l.prefixes {
+		if p.valueMaxSize > size {
+			size = p.valueMaxSize
+		}
+	}
+	for _, ns := range l.namespaces {
+		if ns.valueMaxSize > size {
+			size = ns.valueMaxSize
+		}
+	}
+	return size
+}
+
+// valid UTF-8 without control characters, except namespace separator
+func printableKey(key string) bool {
+	if !utf8.ValidString(key) {
+		return false
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) && string(r) != NAMESPACE_SEPARATOR {
+			return false
+		}
+	}
+	return true
+}
+
+func orDefault(value, def int) int {
+	if value == 0 {
+		return def
+	}
+	return value
+}
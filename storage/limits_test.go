@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"github.com/dgtony/gcache/utils"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	conf := getTestConfig(1)
+	conf.Limits = utils.LimitsSettings{
+		KeyMaxLen:    16,
+		ValueMaxSize: 32,
+		KeyChars:     KEY_CHARS_PRINTABLE,
+		Prefixes: []utils.PrefixLimits{
+			{Prefix: "s:", KeyMaxLen: 8, ValueMaxSize: 4},
+			{Prefix: "s:long:", KeyMaxLen: 64},
+			{Prefix: "v:", ValueMaxSize: 64}}}
+	l := newLimits(conf)
+
+	testCases := []struct {
+		Key   string
+		Value int
+		Err   error
+	}{
+		{"key", 32, nil},
+		{"", 1, ErrEmptyKey},
+		{strings.Repeat("k", 17), 1, ErrKeyTooLong},
+		{"key", 33, ErrValueTooBig},
+		{"s:key", 4, nil},
+		{"s:key1234", 1, ErrKeyTooLong},
+		{"s:key", 5, ErrValueTooBig},
+		// the longest prefix wins, omitted limits are common ones
+		{"s:long:" + strings.Repeat("k", 20), 32, nil},
+		{"s:long:key", 33, ErrValueTooBig},
+		{"v:key", 64, nil},
+		{"v:" + strings.Repeat("k", 15), 1, ErrKeyTooLong},
+		// printable keys only
+		{"ключ", 1, nil},
+		{"bad\nkey", 1, ErrKeyChars},
+		{"bad\xffkey", 1, ErrKeyChars},
+	}
+	for _, c := range testCases {
+		err := l.checkKey(c.Key)
+		if err == nil {
+			err = l.checkValues(c.Key, []byte("v"), make([]byte, c.Value))
+		}
+		if err != c.Err {
+			t.Errorf("unexpected error for key %q with %d bytes value => expected: %v, got: %v", c.Key, c.Value, c.Err, err)
+		}
+	}
+
+	if !errors.Is(ErrKeyChars, ErrInvalidKey) || !errors.Is(ErrValueTooBig, ErrInvalidValue) {
+		t.Error("validation errors don't match general ones")
+	}
+
+	if err := l.checkMembers("s:key", "1234"); err != nil {
+		t.Errorf("set member within limits is rejected: %s", err)
+	}
+	if err := l.checkMembers("s:key", "1", "12345"); err != ErrValueTooBig {
+		t.Errorf("unexpected error for big set member: %v", err)
+	}
+
+	// prefixes are matched inside namespace, omitted limits are namespace ones
+	conf.Storage.Namespaces = []utils.NamespaceSettings{{Name: "ns", KeyMaxLen: 12, ValueMaxSize: 16}}
+	l = newLimits(conf)
+	ns := namespacePrefix("ns")
+	for _, c := range []struct {
+		Key   string
+		Value int
+		Err   error
+	}{
+		{ns + "key", 16, nil},
+		{ns + "key", 17, ErrValueTooBig},
+		{ns + strings.Repeat("k", 12), 1, nil},
+		{ns + strings.Repeat("k", 13), 1, ErrKeyTooLong},
+		{ns + "s:key", 4, nil},
+		{ns + "s:key", 5, ErrValueTooBig},
+		{ns + "s:key1234", 1, ErrKeyTooLong},
+		{ns + "s:long:" + strings.Repeat("k", 20), 16, nil},
+		{ns + "s:long:key", 17, ErrValueTooBig},
+		{ns + "v:" + strings.Repeat("k", 10), 64, nil},
+		{ns + "v:" + strings.Repeat("k", 11), 1, ErrKeyTooLong},
+		// unknown namespace is a part of key
+		{namespacePrefix("other") + "s:key", 32, nil},
+	} {
+		err := l.checkKey(c.Key)
+		if err == nil {
+			err = l.checkValues(c.Key, make([]byte, c.Value))
+		}
+		if err != c.Err {
+			t.Errorf("unexpected error for namespace key %q with %d bytes value => expected: %v, got: %v", c.Key, c.Value, c.Err, err)
+		}
+	}
+	if size := l.maxValueSize(); size != 64 {
+		t.Errorf("unexpected maximum value size: %d", size)
+	}
+
+	for _, policy := range []string{KEY_CHARS_ANY, ""} {
+		conf.Limits.KeyChars = policy
+		if err := newLimits(conf).checkKey("binary\x00key"); err != nil {
+			t.Errorf("binary key is rejected with %q characters policy: %s", policy, err)
+		}
+	}
+}
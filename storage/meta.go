@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+Metadata is kept for every key, plain values and structures alike, and saved
+in snapshots. Readers update access time and hit count atomically under shared
+lock (sharded engine) or without any lock (RCU engine), so all mutable fields
+are accessed atomically. Times are Unix nanoseconds.
+
+Metadata is stored by value in pointer-free chunks of slots, see metaSlots,
+so it takes no allocation per key and is not scanned by garbage collector.
+Engines map keys to slots with maps keyed by strings; like value maps,
+these are still scanned on each GC cycle.
+*/
+type KeyMeta struct {
+	Created  int64
+	Updated  int64
+	Accessed int64
+	Expires  int64
+	// reads of the key since creation
+	Hits int64
+}
+
+// Key metadata returned by Object.
+type KeyInfo struct {
+	Type     ValueType
+	Created  time.Time
+	Updated  time.Time
+	Accessed time.Time
+	Hits     int64
+	// stored bytes of plain value or structure elements estimate
+	Size       int
+	Compressed bool
+	// time left before expiration
+	TTL time.Duration
+}
+
+// Return metadata of stored key, access time and hit count are not changed.
+func (c *ConcurrentMap) Object(key string) (KeyInfo, bool) {
+	shard, err := c.getShard(key)
+	if err != nil {
+		return KeyInfo{}, false
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+	m := shard.meta.get(key)
+	if m == nil {
+		return KeyInfo{}, false
+	}
+	info := m.info()
+	if value, ok := shard.getItem(key); ok {
+		info.Type = TYPE_STRING
+		info.Size = len(value)
+		info.Compressed = shard.compressed[key]
+	} else if s, ok := shard.Structures[key]; ok {
+		info.Type = s.Type
+		info.Size = s.size()
+	}
+	return info, true
+}
+
+/* internals */
+
+// metadata slots allocated at once
+const META_CHUNK_SIZE = 1024
+
+// chunk is never moved, so its slots stay in place while new chunks are added
+type metaChunk [META_CHUNK_SIZE]KeyMeta
+
+// Slots of key metadata, allocated and released by writers only.
+type metaSlots struct {
+	chunks []*metaChunk
+	// released slots, reused first
+	free []int32
+	// slots below were allocated at least once
+	next int32
+}
+
+// Allocate slot with metadata of new key.
+func (m *metaSlots) alloc(now int64) int32 {
+	var slot int32
+	if n := len(m.free); n > 0 {
+		slot, m.free = m.free[n-1], m.free[:n-1]
+	} else {
+		if int(m.next) == len(m.chunks)*META_CHUNK_SIZE {
+			m.chunks = append(m.chunks, new(metaChunk))
+		}
+		slot = m.next
+		m.next++
+	}
+	metaSlot(m.chunks, slot).reset(now)
+	return slot
+}
+
+func (m *metaSlots) release(slot int32) {
+	m.free = append(m.free, slot)
+}
+
+func metaSlot(chunks []*metaChunk, slot int32) *KeyMeta {
+	return &chunks[slot/META_CHUNK_SIZE][slot%META_CHUNK_SIZE]
+}
+
+// Metadata of sharded engine keys, changed under exclusive shard lock.
+type metaTable struct {
+	index map[string]int32
+	metaSlots
+}
+
+// Table filled with metadata restored from snapshot.
+func newMetaTable(meta map[string]KeyMeta) metaTable {
+	t := metaTable{index: make(map[string]int32, len(meta))}
+	for k, m := range meta {
+		slot := t.alloc(0)
+		*metaSlot(t.chunks, slot) = m
+		t.index[k] = slot
+	}
+	return t
+}
+
+// nil if key has no metadata
+func (t *metaTable) get(key string) *KeyMeta {
+	slot, ok := t.index[key]
+	if !ok {
+		return nil
+	}
+	return metaSlot(t.chunks, slot)
+}
+
+func (t *metaTable) add(key string, now int64) *KeyMeta {
+	slot := t.alloc(now)
+	t.index[key] = slot
+	return metaSlot(t.chunks, slot)
+}
+
+func (t *metaTable) remove(key string) {
+	if slot, ok := t.index[key]; ok {
+		t.release(slot)
+		delete(t.index, key)
+	}
+}
+
+// consistent copy of every key metadata, safe to encode
+func (t *metaTable) copy() map[string]KeyMeta {
+	c := make(map[string]KeyMeta, len(t.index))
+	for k, slot := range t.index {
+		c[k] = metaSlot(t.chunks, slot).load()
+	}
+	return c
+}
+
+// metadata of new key, slot may still be read by stale RCU readers
+func (m *KeyMeta) reset(now int64) {
+	atomic.StoreInt64(&m.Created, now)
+	atomic.StoreInt64(&m.Updated, now)
+	atomic.StoreInt64(&m.Accessed, now)
+	atomic.StoreInt64(&m.Expires, now)
+	atomic.StoreInt64(&m.Hits, 0)
+}
+
+// key value is changed and its TTL is updated
+func (m *KeyMeta) written(now int64, ttl time.Duration) {
+	atomic.StoreInt64(&m.Updated, now)
+	atomic.StoreInt64(&m.Accessed, now)
+	atomic.StoreInt64(&m.Expires, now+int64(ttl))
+}
+
+// key value is read
+func (m *KeyMeta) accessed() {
+	atomic.StoreInt64(&m.Accessed, time.Now().UnixNano())
+	atomic.AddInt64(&m.Hits, 1)
+}
+
+// consistent copy of each field, safe to encode
+func (m *KeyMeta) load() KeyMeta {
+	return KeyMeta{
+		Created:  atomic.LoadInt64(&m.Created),
+		Updated:  atomic.LoadInt64(&m.Updated),
+		Accessed: atomic.LoadInt64(&m.Accessed),
+		Expires:  atomic.LoadInt64(&m.Expires),
+		Hits:     atomic.LoadInt64(&m.Hits)}
+}
+
+func (m *KeyMeta) info() KeyInfo {
+	c := m.load()
+	return KeyInfo{
+		Created:  time.Unix(0, c.Created),
+		Updated:  time.Unix(0, c.Updated),
+		Accessed: time.Unix(0, c.Accessed),
+		Hits:     c.Hits,
+		TTL:      time.Duration(c.Expires - time.Now().UnixNano())}
+}
+
+// Record key write: create metadata of new key and update expiration.
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) written(key string, ttl time.Duration) {
+	now := time.Now().UnixNano()
+	m := c.meta.get(key)
+	if m == nil {
+		m = c.meta.add(key, now)
+	}
+	m.written(now, ttl)
+	c.KeyExpiration.InsertKey(key, ttl)
+}
+
+// Record key read, shared lock is enough.
+func (c *ConcurrentMapShard) accessed(key string) {
+	if m := c.meta.get(key); m != nil {
+		m.accessed()
+	}
+}
+
+// Remove value of any type with its metadata.
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) removeKey(key string) {
+	c.removeItem(key)
+	c.removeStructure(key)
+	c.meta.remove(key)
+}
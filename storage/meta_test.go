@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreObject(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		if _, ok := store.Object("missing"); ok {
+			t.Error("metadata of missing key is found")
+		}
+
+		store.Set("key", []byte("value"), time.Minute)
+		store.RPush("list", time.Minute, []byte("a"), []byte("b"))
+		created, _ := store.Object("key")
+		if created.Type != TYPE_STRING || created.Size != len("value") || created.Hits != 0 {
+			t.Errorf("unexpected metadata of new key: %+v", created)
+		}
+		if created.TTL <= 59*time.Second || created.TTL > time.Minute {
+			t.Errorf("unexpected TTL: %s", created.TTL)
+		}
+
+		time.Sleep(time.Millisecond)
+		store.Get("key")
+		store.Get("key")
+		store.LRange("list", 0, -1)
+		info, _ := store.Object("key")
+		if info.Hits != 2 || !info.Accessed.After(created.Accessed) || !info.Updated.Equal(created.Updated) {
+			t.Errorf("reads are not recorded: %+v", info)
+		}
+		if list, _ := store.Object("list"); list.Type != TYPE_LIST || list.Hits != 1 || list.Size == 0 {
+			t.Errorf("unexpected metadata of list: %+v", list)
+		}
+
+		// hits are kept on update
+		store.Set("key", []byte("new value"), time.Hour)
+		updated, _ := store.Object("key")
+		if !updated.Created.Equal(created.Created) || !updated.Updated.After(created.Updated) ||
+			updated.Hits != 2 || updated.TTL <= time.Minute {
+			t.Errorf("update is not recorded: %+v", updated)
+		}
+
+		// metadata is kept in snapshots
+		dump, err := store.DumpStorage()
+		if err != nil {
+			t.Fatalf("dump: %s", err)
+		}
+		store.Remove("key")
+		if _, ok := store.Object("key"); ok {
+			t.Error("metadata of removed key is found")
+		}
+		if err := store.RestoreFromDump(dump); err != nil {
+			t.Fatalf("restore: %s", err)
+		}
+		if restored, _ := store.Object("key"); restored.Hits != 2 || !restored.Created.Equal(created.Created) {
+			t.Errorf("metadata is not restored: %+v", restored)
+		}
+	})
+}
+
+func TestCoreDumpWithoutMeta(t *testing.T) {
+	dump := StorageDump{{
+		Items:         map[string][]byte{"key": []byte("value")},
+		Structures:    map[string]*Structure{"set": newStructure(TYPE_SET)},
+		KeyExpiration: ExpireQueue{{Key: "key", Expire: time.Now().Add(time.Minute).UnixNano()}}}}
+	meta := dump[0].getMeta()
+	if _, ok := meta["set"]; len(meta) != 2 || !ok {
+		t.Fatalf("metadata is not created: %v", meta)
+	}
+	m := meta["key"]
+	if info := m.info(); info.TTL <= 59*time.Second || info.Hits != 0 {
+		t.Errorf("unexpected metadata: %+v", info)
+	}
+}
+
+func TestStoreObjectSlotReuse(t *testing.T) {
+	for _, engine := range testEngines {
+		// single shard, so that new key takes released slot
+		store := newTestStore(t, engine, 1)
+		store.Set("old", []byte("value"), time.Minute)
+		store.Get("old")
+		store.Remove("old")
+		store.SAdd("new", time.Hour, "a")
+		if info, ok := store.Object("new"); !ok || info.Hits != 0 || info.TTL <= 59*time.Minute {
+			t.Errorf("%s: metadata of removed key is kept: %+v", engine, info)
+		}
+		if _, ok := store.Object("old"); ok {
+			t.Errorf("%s: metadata of removed key is found", engine)
+		}
+		store.Close()
+	}
+}
+
+func TestMetaTable(t *testing.T) {
+	table := newMetaTable(map[string]KeyMeta{"key": {Hits: 3}})
+	for i := 0; i < META_CHUNK_SIZE; i++ {
+		table.add(fmt.Sprintf("key%d", i), 1)
+	}
+	if len(table.chunks) != 2 || table.get("key").Hits != 3 || table.get("key1").Created != 1 {
+		t.Fatalf("unexpected table: %d chunks, %+v", len(table.chunks), table.get("key"))
+	}
+
+	table.remove("key")
+	table.add("other", 2)
+	if table.get("key") != nil || table.next != META_CHUNK_SIZE+1 {
+		t.Errorf("released slot is not reused, %d slots", table.next)
+	}
+	if copied := table.copy(); len(copied) != META_CHUNK_SIZE+1 || copied["other"].Created != 2 {
+		t.Errorf("unexpected copy of %d keys", len(copied))
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"github.com/dgtony/gcache/metrics"
+	"github.com/dgtony/gcache/slowlog"
+	"strconv"
+	"time"
+)
+
+var (
+	storageOps = metrics.NewCounterVec(
+		"gcache_storage_operations_total",
+		"Storage operations by type.",
+		"op")
+	storageHits = metrics.NewCounter(
+		"gcache_storage_hits_total",
+		"Read operations on existing keys.")
+	storageMisses = metrics.NewCounter(
+		"gcache_storage_misses_total",
+		"Read operations on missing keys.")
+	expiredKeys = metrics.NewCounter(
+		"gcache_storage_expired_keys_total",
+		"Keys removed on expiration.")
+	sweepKeys = metrics.NewHistogram(
+		"gcache_storage_expiration_sweep_keys",
+		"Keys expired per shard sweep.",
+		[]float64{0, 1, 10, 100, 1000, 10000})
+	sweepDuration = metrics.NewHistogram(
+		"gcache_storage_expiration_sweep_duration_seconds",
+		"Duration of shard expiration sweep.",
+		metrics.ExponentialBuckets(0.00001, 10, 7))
+	slabCompactions = metrics.NewCounter(
+		"gcache_storage_slab_compactions_total",
+		"Compactions of value slabs.")
+)
+
+// tracked operations, cached for hot paths
+var (
+	opGet           = newOperation("get")
+	opSet           = newOperation("set")
+	opRemove        = newOperation("remove")
+	opKeys          = newOperation("keys")
+	opType          = newOperation("type")
+	opLPush         = newOperation("lpush")
+	opRPush         = newOperation("rpush")
+	opLRange        = newOperation("lrange")
+	opHSet          = newOperation("hset")
+	opHGet          = newOperation("hget")
+	opHGetAll       = newOperation("hgetall")
+	opSAdd          = newOperation("sadd")
+	opSIsMember     = newOperation("sismember")
+	opSMembers      = newOperation("smembers")
+	opZAdd          = newOperation("zadd")
+	opZRangeByScore = newOperation("zrangebyscore")
+	opFlush         = newOperation("flush")
+	opRemoveMask    = newOperation("removemask")
+	opAnalyze       = newOperation("analyze")
+
+	hits   = storageHits.With()
+	misses = storageMisses.With()
+)
+
+func init() {
+	metrics.Default.MustRegister(storageOps, storageHits, storageMisses, expiredKeys, sweepKeys, sweepDuration, slabCompactions)
+}
+
+// Collect storage size metrics per shard, computed on demand.
+func (c ConcurrentMap) CollectMetrics() []metrics.Collector {
+	return shardMetrics(c.ShardStats())
+}
+
+func shardMetrics(shardStats []ShardStats) []metrics.Collector {
+	keys := metrics.NewGaugeVec("gcache_storage_keys", "Keys stored per shard.", "shard")
+	bytes := metrics.NewGaugeVec("gcache_storage_bytes", "Estimated size of keys and values per shard.", "shard")
+	for i, stats := range shardStats {
+		shard := strconv.Itoa(i)
+		keys.With(shard).Set(float64(stats.Keys))
+		bytes.With(shard).Set(float64(stats.Bytes))
+	}
+	return []metrics.Collector{keys, bytes}
+}
+
+type operation struct {
+	name    string
+	counter *metrics.Counter
+}
+
+func newOperation(name string) operation {
+	return operation{name: name, counter: storageOps.With(name)}
+}
+
+// count operation and record it in slow log if it took too long,
+// usage: defer op.track(key, time.Now())
+func (o operation) track(key string, start time.Time) {
+	o.counter.Inc()
+	slowlog.Default.Record(slowlog.SOURCE_STORAGE, o.name, key, "", start)
+}
+
+func countLookup(found bool) {
+	if found {
+		hits.Inc()
+	} else {
+		misses.Inc()
+	}
+}
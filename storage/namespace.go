@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+Keys of named namespaces are stored with namespace name and separator prepended,
+so namespaces need no support from storage engines and are included in snapshots
+and replicated as usual. Separator is a control character, rejected in namespace
+keys and never found in printable keys.
+*/
+const NAMESPACE_SEPARATOR = "\x1f"
+
+// Name of default namespace, its keys are stored as is.
+const DEFAULT_NAMESPACE = ""
+
+// Namespace is a keyspace isolated from keys of other namespaces.
+type Namespace struct {
+	store  Store
+	name   string
+	prefix string
+}
+
+var _ Keyspace = (*Namespace)(nil)
+
+func NewNamespace(store Store, name string) *Namespace {
+	return &Namespace{store: store, name: name, prefix: namespacePrefix(name)}
+}
+
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+func (n *Namespace) Get(key string) ([]byte, bool) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, false
+	}
+	return n.store.Get(k)
+}
+
+func (n *Namespace) Set(key string, value []byte, ttl time.Duration) error {
+	k, err := n.key(key)
+	if err != nil {
+		return err
+	}
+	return n.store.Set(k, value, ttl)
+}
+
+func (n *Namespace) Remove(key string) {
+	if k, err := n.key(key); err == nil {
+		n.store.Remove(k)
+	}
+}
+
+// Return keys of namespace without namespace prefix.
+func (n *Namespace) Keys() []string {
+	if n.prefix != "" {
+		keys := n.store.KeysPrefix(n.prefix)
+		for i, k := range keys {
+			keys[i] = k[len(n.prefix):]
+		}
+		return keys
+	}
+	// keys of default namespace have no common prefix
+	keys := make([]string, 0)
+	for _, k := range n.store.Keys() {
+		if !strings.Contains(k, NAMESPACE_SEPARATOR) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (n *Namespace) KeysMask(mask string) ([]string, bool) {
+	return matchKeys(n.Keys(), mask)
+}
+
+func (n *Namespace) Type(key string) ValueType {
+	k, err := n.key(key)
+	if err != nil {
+		return TYPE_NONE
+	}
+	return n.store.Type(k)
+}
+
+func (n *Namespace) Object(key string) (KeyInfo, bool) {
+	k, err := n.key(key)
+	if err != nil {
+		return KeyInfo{}, false
+	}
+	return n.store.Object(k)
+}
+
+func (n *Namespace) LPush(key string, ttl time.Duration, values ...[]byte) (int, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return n.store.LPush(k, ttl, values...)
+}
+
+func (n *Namespace) RPush(key string, ttl time.Duration, values ...[]byte) (int, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return n.store.RPush(k, ttl, values...)
+}
+
+func (n *Namespace) LRange(key string, start, stop int) ([][]byte, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.store.LRange(k, start, stop)
+}
+
+func (n *Namespace) HSet(key string, ttl time.Duration, fields map[string][]byte) (int, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return n.store.HSet(k, ttl, fields)
+}
+
+func (n *Namespace) HGet(key, field string) ([]byte, bool, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, false, err
+	}
+	return n.store.HGet(k, field)
+}
+
+func (n *Namespace) HGetAll(key string) (map[string][]byte, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.store.HGetAll(k)
+}
+
+func (n *Namespace) SAdd(key string, ttl time.Duration, members ...string) (int, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return n.store.SAdd(k, ttl, members...)
+}
+
+func (n *Namespace) SIsMember(key, member string) (bool, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return false, err
+	}
+	return n.store.SIsMember(k, member)
+}
+
+func (n *Namespace) SMembers(key string) ([]string, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.store.SMembers(k)
+}
+
+func (n *Namespace) ZAdd(key string, ttl time.Duration, members ...ZMember) (int, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return n.store.ZAdd(k, ttl, members...)
+}
+
+func (n *Namespace) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.store.ZRangeByScore(k, min, max)
+}
+
+// Remove keys of namespace matching glob mask, see Store.RemoveMask.
+func (n *Namespace) RemoveMask(mask string) (int, bool) {
+	if n.prefix != "" {
+		return n.store.RemoveMask(n.prefix + mask)
+	}
+	// keys of default namespace have no common prefix, removed one by one
+	keys, ok := n.KeysMask(mask)
+	for _, k := range keys {
+		n.store.Remove(k)
+	}
+	return len(keys), ok
+}
+
+// Remove all keys of namespace.
+func (n *Namespace) Flush() int {
+	removed, _ := n.RemoveMask("*")
+	return removed
+}
+
+/*
+Count keys of given named namespaces and default one. Default namespace
+takes the rest of storage keys, including keys of namespaces not listed.
+*/
+func NamespaceKeys(store Store, names []string) map[string]int {
+	total := 0
+	for _, stats := range store.ShardStats() {
+		total += stats.Keys
+	}
+	counts := make(map[string]int, len(names)+1)
+	for _, name := range names {
+		if name != DEFAULT_NAMESPACE {
+			counts[name] = len(store.KeysPrefix(namespacePrefix(name)))
+			total -= counts[name]
+		}
+	}
+	counts[DEFAULT_NAMESPACE] = total
+	return counts
+}
+
+/* internals */
+
+// storage key, separator is not allowed in namespace keys
+func (n *Namespace) key(key string) (string, error) {
+	if key == "" {
+		return "", ErrEmptyKey
+	}
+	if strings.Contains(key, NAMESPACE_SEPARATOR) {
+		return "", ErrKeyChars
+	}
+	return n.prefix + key, nil
+}
+
+func namespacePrefix(name string) string {
+	if name == DEFAULT_NAMESPACE {
+		return ""
+	}
+	return name + NAMESPACE_SEPARATOR
+}
+
+// split storage key into namespace name and key
+func splitNamespace(key string) (string, string) {
+	if i := strings.Index(key, NAMESPACE_SEPARATOR); i >= 0 {
+		return key[:i], key[i+len(NAMESPACE_SEPARATOR):]
+	}
+	return DEFAULT_NAMESPACE, key
+}
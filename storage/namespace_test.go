@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"github.com/dgtony/gcache/utils"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNamespaces(t *testing.T) {
+	setup_logger()
+	for _, engine := range testEngines {
+		t.Run(engine, func(t *testing.T) {
+			conf := getEngineConfig(engine, 4)
+			conf.Storage.Namespaces = []utils.NamespaceSettings{{Name: "small", KeyMaxLen: 4, ValueMaxSize: 8}}
+			store, err := NewStore(conf)
+			if err != nil {
+				t.Fatalf("create storage: %s", err)
+			}
+			defer store.Close()
+
+			def := NewNamespace(store, DEFAULT_NAMESPACE)
+			small := NewNamespace(store, "small")
+			other := NewNamespace(store, "other")
+			for i, ns := range []*Namespace{def, small, other} {
+				if err := ns.Set("key", []byte{byte(i)}, time.Minute); err != nil {
+					t.Fatalf("set in namespace %q: %s", ns.Name(), err)
+				}
+				if _, err := ns.SAdd("set", time.Minute, ns.Name()); err != nil {
+					t.Fatalf("add to set in namespace %q: %s", ns.Name(), err)
+				}
+			}
+
+			// keys are isolated
+			for i, ns := range []*Namespace{def, small, other} {
+				if value, ok := ns.Get("key"); !ok || value[0] != byte(i) {
+					t.Errorf("unexpected value in namespace %q: %v", ns.Name(), value)
+				}
+				if members, _ := ns.SMembers("set"); len(members) != 1 || members[0] != ns.Name() {
+					t.Errorf("unexpected set members in namespace %q: %v", ns.Name(), members)
+				}
+				keys := ns.Keys()
+				sort.Strings(keys)
+				if len(keys) != 2 || keys[0] != "key" || keys[1] != "set" {
+					t.Errorf("unexpected keys of namespace %q: %v", ns.Name(), keys)
+				}
+			}
+			if keys, _ := small.KeysMask("k*"); len(keys) != 1 || keys[0] != "key" {
+				t.Errorf("unexpected keys by mask: %v", keys)
+			}
+			small.Remove("key")
+			if _, ok := def.Get("key"); !ok {
+				t.Error("key is removed from another namespace")
+			}
+
+			// namespace limits exclude its name
+			if err := small.Set("long", []byte("12345678"), time.Minute); err != nil {
+				t.Errorf("key within namespace limits is rejected: %s", err)
+			}
+			if err := small.Set("long1", []byte("1"), time.Minute); err != ErrKeyTooLong {
+				t.Errorf("unexpected error for long key: %v", err)
+			}
+			if err := small.Set("big", []byte("123456789"), time.Minute); err != ErrValueTooBig {
+				t.Errorf("unexpected error for big value: %v", err)
+			}
+			if err := def.Set("bad"+NAMESPACE_SEPARATOR+"key", []byte("1"), time.Minute); err != ErrKeyChars {
+				t.Errorf("unexpected error for key with separator: %v", err)
+			}
+
+			counts := NamespaceKeys(store, []string{"small", "other"})
+			if len(counts) != 3 || counts[DEFAULT_NAMESPACE] != 2 || counts["small"] != 2 || counts["other"] != 2 {
+				t.Errorf("unexpected key counts: %v", counts)
+			}
+			if counts := NamespaceKeys(store, []string{"small"}); counts[DEFAULT_NAMESPACE] != 4 || counts["small"] != 2 {
+				t.Errorf("unexpected key counts of configured namespaces: %v", counts)
+			}
+			if keys := store.KeysPrefix(namespacePrefix("other")); len(keys) != 2 {
+				t.Errorf("unexpected keys by prefix: %v", keys)
+			}
+
+			// namespaces are kept in snapshots
+			dump, err := store.DumpStorage()
+			if err != nil {
+				t.Fatalf("dump: %s", err)
+			}
+			restored, err := NewStoreFromDump(conf, dump)
+			if err != nil {
+				t.Fatalf("restore: %s", err)
+			}
+			defer restored.Close()
+			if value, ok := NewNamespace(restored, "other").Get("key"); !ok || value[0] != 2 {
+				t.Errorf("namespace is not restored: %v", value)
+			}
+		})
+	}
+}
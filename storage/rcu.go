@@ -0,0 +1,622 @@
+package storage
+
+import (
+	"github.com/dgtony/gcache/metrics"
+	"github.com/dgtony/gcache/utils"
+	"github.com/gobwas/glob"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+RCUMap is storage engine for read-heavy nodes with rare writes, e.g. slaves
+changed only with master snapshots. Each shard keeps immutable copy of its data:
+readers load it atomically without any locks, while writers copy changed maps,
+apply the change and publish new copy (read-copy-update).
+Write cost grows with shard size, so use enough shards for big datasets.
+*/
+type RCUMap struct {
+	shards  []*rcuShard
+	sweeper *sweeper
+	// snapshots only, values are never compressed
+	compression compression
+	limits      limits
+}
+
+type rcuShard struct {
+	// *shardData, never changed after publishing
+	data atomic.Value
+	// changed by writers only, under shard lock
+	keyExpiration ExpireQueue
+	meta          metaSlots
+	// serializes writers, readers don't lock
+	sync.Mutex
+}
+
+// Shard content, key map and structures are read-only once published.
+type shardData struct {
+	// plain values and structures in one map, so that write copies single map
+	keys map[string]rcuKey
+	// the only mutable part, key metadata fields are changed atomically
+	meta []*metaChunk
+	// kept by writers, so stats are collected without walking keys
+	stats ShardStats
+}
+
+// Plain value or structure with its metadata slot.
+// Slot of removed key may be reused while stale readers still update it,
+// so few hits could be counted to another key.
+type rcuKey struct {
+	value     []byte
+	structure *Structure
+	meta      int32
+}
+
+/* Storage methods */
+
+func MakeRCUStorageEmpty(conf *utils.Config) (*RCUMap, error) {
+	init_logger()
+
+	numShards := conf.Storage.NumShards
+	logger.Debugf("create RCU storage with %d shards", numShards)
+	if numShards < 1 || numShards > MAX_SHARDS {
+		return nil, ErrBadShardNumber
+	}
+
+	c := &RCUMap{
+		shards:      make([]*rcuShard, numShards),
+		compression: newCompression(conf),
+		limits:      newLimits(conf)}
+	for i := range c.shards {
+		c.shards[i] = &rcuShard{keyExpiration: NewExpireQueue()}
+		c.shards[i].replace(nil, nil, nil)
+	}
+	c.runExpKeyCleaning(time.Duration(conf.Storage.ExpiredKeyCheckInterval) * time.Second)
+	return c, nil
+}
+
+func MakeRCUStorageFromDump(conf *utils.Config, snapshot []byte) (*RCUMap, error) {
+	init_logger()
+
+	compression := newCompression(conf)
+	storageDump, err := deserializeDump(snapshot, compression.snapshotMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &RCUMap{
+		shards:      make([]*rcuShard, len(storageDump)),
+		compression: compression,
+		limits:      newLimits(conf)}
+	for i, shardDump := range storageDump {
+		c.shards[i] = &rcuShard{keyExpiration: shardDump.KeyExpiration}
+		c.shards[i].replace(shardDump.getItems(), shardDump.getStructures(), shardDump.getMeta())
+	}
+	c.runExpKeyCleaning(time.Duration(conf.Storage.ExpiredKeyCheckInterval) * time.Second)
+	return c, nil
+}
+
+func (c *RCUMap) Get(key string) ([]byte, bool) {
+	defer opGet.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return nil, false
+	}
+	d := shard.load()
+	k, ok := d.keys[key]
+	ok = ok && k.structure == nil
+	if ok {
+		d.keyMeta(k).accessed()
+	}
+	countLookup(ok)
+	return k.value, ok
+}
+
+func (c *RCUMap) Set(key string, value []byte, ttl time.Duration) error {
+	defer opSet.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return err
+	}
+	if err := c.limits.checkValues(key, value); err != nil {
+		return err
+	}
+
+	shard.Lock()
+	d := shard.load()
+	stats := d.stats
+	k, exists := d.keys[key]
+	if exists {
+		stats.Bytes -= k.size(key)
+	} else {
+		stats.Keys++
+	}
+	k.value, k.structure = value, nil
+	stats.Bytes += k.size(key)
+	shard.written(&k, exists, key, ttl)
+	keys := copyKeys(d.keys)
+	keys[key] = k
+	shard.publish(&shardData{keys: keys, meta: shard.meta.chunks, stats: stats})
+	shard.Unlock()
+	return nil
+}
+
+func (c *RCUMap) Remove(key string) {
+	defer opRemove.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return
+	}
+
+	shard.Lock()
+	shard.remove([]string{key})
+	shard.Unlock()
+}
+
+func (c *RCUMap) Keys() []string {
+	return c.KeysPrefix("")
+}
+
+// Return keys starting with given prefix, filtered on shard snapshots.
+func (c *RCUMap) KeysPrefix(prefix string) []string {
+	defer opKeys.track(prefix, time.Now())
+	keys := make([]string, 0)
+	for _, shard := range c.shards {
+		for k := range shard.load().keys {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+// return keys according to given mask
+// use glob pattern matching
+func (c *RCUMap) KeysMask(mask string) ([]string, bool) {
+	return matchKeys(c.Keys(), mask)
+}
+
+// Return type of value stored with given key
+func (c *RCUMap) Type(key string) ValueType {
+	defer opType.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return TYPE_NONE
+	}
+
+	k, ok := shard.load().keys[key]
+	if !ok {
+		return TYPE_NONE
+	}
+	if k.structure == nil {
+		return TYPE_STRING
+	}
+	return k.structure.Type
+}
+
+func (c *RCUMap) LPush(key string, ttl time.Duration, values ...[]byte) (int, error) {
+	defer opLPush.track(key, time.Now())
+	return c.push(key, true, ttl, values)
+}
+
+func (c *RCUMap) RPush(key string, ttl time.Duration, values ...[]byte) (int, error) {
+	defer opRPush.track(key, time.Now())
+	return c.push(key, false, ttl, values)
+}
+
+func (c *RCUMap) LRange(key string, start, stop int) ([][]byte, error) {
+	defer opLRange.track(key, time.Now())
+	s, err := c.view(key, TYPE_LIST)
+	if err != nil || s == nil {
+		return [][]byte{}, err
+	}
+	return s.lrange(start, stop), nil
+}
+
+func (c *RCUMap) HSet(key string, ttl time.Duration, fields map[string][]byte) (int, error) {
+	defer opHSet.track(key, time.Now())
+	if err := c.limits.checkKey(key); err != nil {
+		return 0, err
+	}
+	// field names are limited as set members
+	for field, value := range fields {
+		if err := c.limits.checkMembers(key, field); err != nil {
+			return 0, err
+		}
+		if err := c.limits.checkValues(key, value); err != nil {
+			return 0, err
+		}
+	}
+	return c.update(key, TYPE_HASH, ttl, func(s *Structure) int {
+		return s.hset(fields)
+	})
+}
+
+func (c *RCUMap) HGet(key, field string) ([]byte, bool, error) {
+	defer opHGet.track(key, time.Now())
+	s, err := c.view(key, TYPE_HASH)
+	if err != nil || s == nil {
+		return nil, false, err
+	}
+	value, ok := s.Hash[field]
+	return value, ok, nil
+}
+
+func (c *RCUMap) HGetAll(key string) (map[string][]byte, error) {
+	defer opHGetAll.track(key, time.Now())
+	s, err := c.view(key, TYPE_HASH)
+	if err != nil || s == nil {
+		return map[string][]byte{}, err
+	}
+	return s.hgetall(), nil
+}
+
+func (c *RCUMap) SAdd(key string, ttl time.Duration, members ...string) (int, error) {
+	defer opSAdd.track(key, time.Now())
+	if err := c.limits.checkKey(key); err != nil {
+		return 0, err
+	}
+	if err := c.limits.checkMembers(key, members...); err != nil {
+		return 0, err
+	}
+	return c.update(key, TYPE_SET, ttl, func(s *Structure) int {
+		return s.sadd(members)
+	})
+}
+
+func (c *RCUMap) SIsMember(key, member string) (bool, error) {
+	defer opSIsMember.track(key, time.Now())
+	s, err := c.view(key, TYPE_SET)
+	if err != nil || s == nil {
+		return false, err
+	}
+	return s.Set[member], nil
+}
+
+func (c *RCUMap) SMembers(key string) ([]string, error) {
+	defer opSMembers.track(key, time.Now())
+	s, err := c.view(key, TYPE_SET)
+	if err != nil || s == nil {
+		return []string{}, err
+	}
+	return s.smembers(), nil
+}
+
+func (c *RCUMap) ZAdd(key string, ttl time.Duration, members ...ZMember) (int, error) {
+	defer opZAdd.track(key, time.Now())
+	if err := c.limits.checkKey(key); err != nil {
+		return 0, err
+	}
+	for _, m := range members {
+		if !validScore(m.Score) {
+			return 0, ErrInvalidValue
+		}
+		if err := c.limits.checkMembers(key, m.Member); err != nil {
+			return 0, err
+		}
+	}
+	return c.update(key, TYPE_ZSET, ttl, func(s *Structure) int {
+		return s.zadd(members)
+	})
+}
+
+func (c *RCUMap) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	defer opZRangeByScore.track(key, time.Now())
+	s, err := c.view(key, TYPE_ZSET)
+	if err != nil || s == nil {
+		return []ZMember{}, err
+	}
+	return s.zrangeByScore(min, max), nil
+}
+
+// Return metadata of stored key, access time and hit count are not changed.
+func (c *RCUMap) Object(key string) (KeyInfo, bool) {
+	shard, err := c.getShard(key)
+	if err != nil {
+		return KeyInfo{}, false
+	}
+
+	d := shard.load()
+	k, ok := d.keys[key]
+	if !ok {
+		return KeyInfo{}, false
+	}
+	info := d.keyMeta(k).info()
+	if k.structure == nil {
+		info.Type = TYPE_STRING
+		info.Size = len(k.value)
+	} else {
+		info.Type = k.structure.Type
+		info.Size = k.structure.size()
+	}
+	return info, true
+}
+
+// Get current storage snapshot, in the same format as ConcurrentMap
+func (c *RCUMap) DumpStorage() ([]byte, error) {
+	fullDump := make(StorageDump, len(c.shards))
+	for i, shard := range c.shards {
+		// published values are immutable, lock keeps metadata slots and expiration queue
+		shard.Lock()
+		d := shard.load()
+		fullDump[i].Items = make(map[string][]byte)
+		fullDump[i].Structures = make(map[string]*Structure)
+		fullDump[i].Meta = make(map[string]KeyMeta, len(d.keys))
+		for key, k := range d.keys {
+			if k.structure == nil {
+				fullDump[i].Items[key] = k.value
+			} else {
+				fullDump[i].Structures[key] = k.structure
+			}
+			fullDump[i].Meta[key] = d.keyMeta(k).load()
+		}
+		fullDump[i].KeyExpiration = copyKeyExp(shard.keyExpiration)
+		shard.Unlock()
+	}
+	return serializeDump(fullDump, c.compression.snapshots)
+}
+
+// Restore entire storage from snapshot
+// All stored data will be completely replaced
+func (c *RCUMap) RestoreFromDump(snapshot []byte) error {
+	storageDump, err := deserializeDump(snapshot, c.compression.snapshotMaxSize)
+	if err != nil {
+		return err
+	}
+	if len(c.shards) != len(storageDump) {
+		// storage is left intact
+		return &ShardMismatchError{Storage: len(c.shards), Dump: len(storageDump)}
+	}
+
+	for i, shardDump := range storageDump {
+		shard := c.shards[i]
+		shard.Lock()
+		shard.replace(shardDump.getItems(), shardDump.getStructures(), shardDump.getMeta())
+		shard.keyExpiration = shardDump.KeyExpiration
+		shard.Unlock()
+	}
+	return nil
+}
+
+// Remove all keys, locking one shard at a time.
+func (c *RCUMap) Flush() int {
+	defer opFlush.track("", time.Now())
+	removed := 0
+	for _, shard := range c.shards {
+		shard.Lock()
+		removed += len(shard.load().keys)
+		shard.replace(nil, nil, nil)
+		shard.keyExpiration = NewExpireQueue()
+		shard.Unlock()
+	}
+	return removed
+}
+
+// Remove keys matching glob mask, locking one shard at a time.
+func (c *RCUMap) RemoveMask(mask string) (int, bool) {
+	defer opRemoveMask.track(mask, time.Now())
+	g, err := glob.Compile(mask)
+	if err != nil {
+		return 0, false
+	}
+	removed := 0
+	for _, shard := range c.shards {
+		shard.Lock()
+		keys := make([]string, 0)
+		for k := range shard.load().keys {
+			if g.Match(k) {
+				keys = append(keys, k)
+			}
+		}
+		shard.remove(keys)
+		shard.keyExpiration.removeMatching(g.Match)
+		shard.Unlock()
+		removed += len(keys)
+	}
+	return removed, true
+}
+
+// Change expiration sweep interval, applied since the next sweep.
+func (c *RCUMap) SetSweepInterval(interval time.Duration) {
+	c.sweeper.setInterval(interval)
+}
+
+func (c *RCUMap) SweepInterval() time.Duration {
+	return c.sweeper.getInterval()
+}
+
+// Collect key count and memory estimate of each shard.
+func (c *RCUMap) ShardStats() []ShardStats {
+	stats := make([]ShardStats, len(c.shards))
+	for i, shard := range c.shards {
+		stats[i] = shard.load().stats
+	}
+	return stats
+}
+
+// Collect storage size metrics per shard, computed on demand.
+func (c *RCUMap) CollectMetrics() []metrics.Collector {
+	return shardMetrics(c.ShardStats())
+}
+
+// Stop background expiration sweeps, stored data is still accessible.
+func (c *RCUMap) Close() {
+	c.sweeper.close()
+}
+
+/* internals */
+
+func (c *RCUMap) runExpKeyCleaning(cleanPeriod time.Duration) {
+	c.sweeper = newSweeper(cleanPeriod)
+	c.sweeper.run(len(c.shards), func(i int) int {
+		shard := c.shards[i]
+		shard.Lock()
+		_, keys := shard.keyExpiration.GetExpiredKeys()
+		if len(keys) > 0 {
+			shard.remove(keys)
+		}
+		shard.Unlock()
+		return len(keys)
+	})
+}
+
+// Return shard for given key, error if key is invalid
+func (c *RCUMap) getShard(key string) (*rcuShard, error) {
+	if err := c.limits.checkKey(key); err != nil {
+		return nil, err
+	}
+	return c.shards[uint(utils.FNVSum64(key))%uint(len(c.shards))], nil
+}
+
+func (c *RCUMap) push(key string, left bool, ttl time.Duration, values [][]byte) (int, error) {
+	if err := c.limits.checkKey(key); err != nil {
+		return 0, err
+	}
+	if err := c.limits.checkValues(key, values...); err != nil {
+		return 0, err
+	}
+	return c.update(key, TYPE_LIST, ttl, func(s *Structure) int {
+		return s.push(left, values)
+	})
+}
+
+// Return published structure of given type, nil if key doesn't exist.
+// Returned structure must not be changed!
+func (c *RCUMap) view(key string, t ValueType) (*Structure, error) {
+	shard, err := c.getShard(key)
+	if err != nil {
+		return nil, err
+	}
+	d := shard.load()
+	k, ok := d.keys[key]
+	if ok && k.structure == nil {
+		return nil, ErrWrongType
+	}
+	countLookup(ok)
+	if !ok {
+		return nil, nil
+	}
+	if k.structure.Type != t {
+		return nil, ErrWrongType
+	}
+	d.keyMeta(k).accessed()
+	return k.structure, nil
+}
+
+// Apply change to copy of structure stored with the key and publish it,
+// structure is created if key doesn't exist, key TTL is updated.
+func (c *RCUMap) update(key string, t ValueType, ttl time.Duration, change func(s *Structure) int) (int, error) {
+	shard, err := c.getShard(key)
+	if err != nil {
+		return 0, err
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	d := shard.load()
+	k, exists := d.keys[key]
+	if exists && (k.structure == nil || k.structure.Type != t) {
+		return 0, ErrWrongType
+	}
+	stats := d.stats
+	if exists {
+		stats.Bytes -= k.size(key)
+		k.structure = k.structure.clone()
+	} else {
+		stats.Keys++
+		k.structure = newStructure(t)
+	}
+
+	res := change(k.structure)
+	stats.Bytes += k.size(key)
+	shard.written(&k, exists, key, ttl)
+	keys := copyKeys(d.keys)
+	keys[key] = k
+	shard.publish(&shardData{keys: keys, meta: shard.meta.chunks, stats: stats})
+	return res, nil
+}
+
+func (s *rcuShard) load() *shardData {
+	return s.data.Load().(*shardData)
+}
+
+func (s *rcuShard) publish(d *shardData) {
+	s.data.Store(d)
+}
+
+// Replace shard content as a whole, stats are counted once.
+// shard lock must be held
+func (s *rcuShard) replace(items map[string][]byte, structures map[string]*Structure, meta map[string]KeyMeta) {
+	// fresh slots, stale readers keep updating the old ones
+	s.meta = metaSlots{}
+	keys := make(map[string]rcuKey, len(items)+len(structures))
+	add := func(key string, k rcuKey) {
+		k.meta = s.meta.alloc(0)
+		*metaSlot(s.meta.chunks, k.meta) = meta[key]
+		keys[key] = k
+	}
+	for key, v := range items {
+		add(key, rcuKey{value: v})
+	}
+	for key, st := range structures {
+		add(key, rcuKey{structure: st})
+	}
+	s.publish(&shardData{keys: keys, meta: s.meta.chunks, stats: dataStats(items, structures)})
+}
+
+// remove keys and publish the change, shard lock must be held
+func (s *rcuShard) remove(keys []string) {
+	d := s.load()
+	data, stats, copied := d.keys, d.stats, false
+	for _, key := range keys {
+		k, ok := data[key]
+		if !ok {
+			continue
+		}
+		if !copied {
+			data, copied = copyKeys(data), true
+		}
+		delete(data, key)
+		s.meta.release(k.meta)
+		stats.Keys--
+		stats.Bytes -= k.size(key)
+	}
+	if copied {
+		s.publish(&shardData{keys: data, meta: s.meta.chunks, stats: stats})
+	}
+}
+
+// Record key write, new key gets metadata slot.
+// shard lock must be held
+func (s *rcuShard) written(k *rcuKey, exists bool, key string, ttl time.Duration) {
+	now := time.Now().UnixNano()
+	if !exists {
+		k.meta = s.meta.alloc(now)
+	}
+	metaSlot(s.meta.chunks, k.meta).written(now, ttl)
+	s.keyExpiration.InsertKey(key, ttl)
+}
+
+// metadata is updated atomically, no lock is needed
+func (d *shardData) keyMeta(k rcuKey) *KeyMeta {
+	return metaSlot(d.meta, k.meta)
+}
+
+func (k rcuKey) size(key string) int {
+	if k.structure == nil {
+		return itemSize(key, k.value)
+	}
+	return structureSize(key, k.structure)
+}
+
+// structures themselves are not copied, they are immutable once published
+func copyKeys(keys map[string]rcuKey) map[string]rcuKey {
+	c := make(map[string]rcuKey, len(keys)+1)
+	for key, k := range keys {
+		c[key] = k
+	}
+	return c
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"encoding/binary"
+	"github.com/dgtony/gcache/utils"
+)
+
+const (
+	// initial slab capacity per shard, bytes
+	SLAB_INITIAL_SIZE = 64 * 1024
+	// entry header: key length and value length
+	SLAB_HEADER_SIZE = 8
+	// slab is compacted when garbage exceeds both the limit and half of slab
+	SLAB_COMPACT_MIN = 1024 * 1024
+)
+
+/*
+Plain values of the shard packed into single byte slab.
+
+Each entry is stored as key and value lengths followed by key and value bytes.
+Entries are never changed in place: updated value is appended as a new entry,
+while old one becomes garbage, reclaimed by compaction into a new slab.
+Index of entry offsets by key hash contains no pointers, so GC
+doesn't scan keys and values in slab. Only value pointers are removed this way:
+keys are still kept as strings by metadata index and expiration queue.
+
+Returned values refer to slab memory directly and must not be modified.
+do not use outside - not thread-safe!
+*/
+type slab struct {
+	buf []byte
+	// entry offsets by key hash
+	index map[uint64]int
+	// keys with hash of another stored key, rare
+	overflow map[string][]byte
+	// bytes taken by overwritten and removed entries
+	garbage int
+	hash    func(key string) uint64
+}
+
+func newSlab(capacity int) *slab {
+	return &slab{
+		buf:      make([]byte, 0, capacity),
+		index:    make(map[uint64]int),
+		overflow: make(map[string][]byte),
+		hash:     utils.FNVSum64}
+}
+
+// Pack values into new slab.
+func newSlabFromItems(items map[string][]byte) *slab {
+	size := SLAB_INITIAL_SIZE
+	for k, v := range items {
+		size += entrySize(k, v)
+	}
+	s := newSlab(size)
+	for k, v := range items {
+		s.set(k, v)
+	}
+	return s
+}
+
+func (s *slab) get(key string) ([]byte, bool) {
+	if offset, ok := s.index[s.hash(key)]; ok {
+		if k, v := s.entry(offset); string(k) == key {
+			return v, true
+		}
+	}
+	value, ok := s.overflow[key]
+	return value, ok
+}
+
+func (s *slab) set(key string, value []byte) {
+	if _, ok := s.overflow[key]; ok {
+		s.overflow[key] = copyBytes(value)
+		return
+	}
+	h := s.hash(key)
+	if offset, ok := s.index[h]; ok {
+		k, v := s.entry(offset)
+		if string(k) != key {
+			// hash collision
+			s.overflow[key] = copyBytes(value)
+			return
+		}
+		s.garbage += SLAB_HEADER_SIZE + len(k) + len(v)
+	}
+	s.index[h] = s.append(key, value)
+	s.compactIfNeeded()
+}
+
+func (s *slab) remove(key string) {
+	h := s.hash(key)
+	if offset, ok := s.index[h]; ok {
+		if k, v := s.entry(offset); string(k) == key {
+			s.garbage += SLAB_HEADER_SIZE + len(k) + len(v)
+			delete(s.index, h)
+			s.compactIfNeeded()
+			return
+		}
+	}
+	delete(s.overflow, key)
+}
+
+func (s *slab) len() int {
+	return len(s.index) + len(s.overflow)
+}
+
+// bytes taken by stored keys and values
+func (s *slab) size() int {
+	size := len(s.buf) - s.garbage
+	for k, v := range s.overflow {
+		size += len(k) + len(v) + ELEMENT_OVERHEAD
+	}
+	return size
+}
+
+func (s *slab) each(f func(key string, value []byte)) {
+	for _, offset := range s.index {
+		k, v := s.entry(offset)
+		f(string(k), v)
+	}
+	for k, v := range s.overflow {
+		f(k, v)
+	}
+}
+
+/* internals */
+
+func (s *slab) entry(offset int) (key, value []byte) {
+	keyLen := int(binary.LittleEndian.Uint32(s.buf[offset:]))
+	valueLen := int(binary.LittleEndian.Uint32(s.buf[offset+4:]))
+	keyStart := offset + SLAB_HEADER_SIZE
+	valueStart := keyStart + keyLen
+	valueEnd := valueStart + valueLen
+	// capacity is limited, so append on value never overwrites the next entry
+	return s.buf[keyStart:valueStart:valueStart], s.buf[valueStart:valueEnd:valueEnd]
+}
+
+func (s *slab) append(key string, value []byte) int {
+	offset := len(s.buf)
+	var header [SLAB_HEADER_SIZE]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(key)))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(value)))
+	s.buf = append(s.buf, header[:]...)
+	s.buf = append(s.buf, key...)
+	s.buf = append(s.buf, value...)
+	return offset
+}
+
+func (s *slab) compactIfNeeded() {
+	if s.garbage > SLAB_COMPACT_MIN && s.garbage > len(s.buf)/2 {
+		s.compact()
+	}
+}
+
+// Move live entries into new slab. Old slab stays untouched,
+// so values returned before compaction remain valid.
+func (s *slab) compact() {
+	live := s.buf
+	s.buf = make([]byte, 0, len(live)-s.garbage+SLAB_INITIAL_SIZE)
+	for h, offset := range s.index {
+		keyLen := int(binary.LittleEndian.Uint32(live[offset:]))
+		valueLen := int(binary.LittleEndian.Uint32(live[offset+4:]))
+		s.index[h] = len(s.buf)
+		s.buf = append(s.buf, live[offset:offset+SLAB_HEADER_SIZE+keyLen+valueLen]...)
+	}
+	s.garbage = 0
+	slabCompactions.With().Inc()
+}
+
+func entrySize(key string, value []byte) int {
+	return SLAB_HEADER_SIZE + len(key) + len(value)
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSlabValues(t *testing.T) {
+	s := newSlab(16)
+	if _, ok := s.get("missing"); ok {
+		t.Error("missing key is found")
+	}
+	s.remove("missing")
+
+	for k, v := range getTestKV() {
+		s.set(k, v)
+	}
+	s.set("key1", []byte("updated"))
+	s.remove("key2")
+	if s.len() != 4 {
+		t.Errorf("unexpected number of values: %d", s.len())
+	}
+	if value, ok := s.get("key1"); !ok || string(value) != "updated" {
+		t.Errorf("value is not updated: %q", value)
+	}
+	if _, ok := s.get("key2"); ok {
+		t.Error("value is not removed")
+	}
+	if s.size() != 4*SLAB_HEADER_SIZE+4*len("key1")+len("updated")+3*len("value1") {
+		t.Errorf("unexpected size: %d", s.size())
+	}
+
+	values := make(map[string]string)
+	s.each(func(k string, v []byte) {
+		values[k] = string(v)
+	})
+	if len(values) != 4 || values["key3"] != "value3" {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
+
+func TestSlabCollisions(t *testing.T) {
+	s := newSlab(16)
+	s.hash = func(string) uint64 { return 1 }
+	for k, v := range getTestKV() {
+		s.set(k, v)
+	}
+	if s.len() != 5 || len(s.overflow) != 4 {
+		t.Fatalf("unexpected number of values: %d, overflow: %d", s.len(), len(s.overflow))
+	}
+	for k, v := range getTestKV() {
+		if value, ok := s.get(k); !ok || string(value) != string(v) {
+			t.Errorf("unexpected value of %s: %q", k, value)
+		}
+	}
+
+	// keys in slab and in overflow are updated and removed in place
+	for k := range getTestKV() {
+		s.set(k, []byte("new"))
+		if value, _ := s.get(k); string(value) != "new" {
+			t.Errorf("value of %s is not updated: %q", k, value)
+		}
+		s.remove(k)
+		if _, ok := s.get(k); ok {
+			t.Errorf("value of %s is not removed", k)
+		}
+	}
+	if s.len() != 0 {
+		t.Errorf("%d values left", s.len())
+	}
+}
+
+func TestSlabCompaction(t *testing.T) {
+	s := newSlab(16)
+	s.set("stable", []byte("value"))
+	value := make([]byte, 64*1024)
+	for i := 0; i < 100; i++ {
+		value[0] = byte(i)
+		s.set("key", value)
+	}
+	old, _ := s.get("key")
+
+	for i := 100; i < 200; i++ {
+		value[0] = byte(i)
+		s.set("key", value)
+	}
+	if len(s.buf) > 2*SLAB_COMPACT_MIN || s.garbage > len(s.buf) {
+		t.Errorf("slab is not compacted: %d bytes, %d garbage", len(s.buf), s.garbage)
+	}
+	if old[0] != 99 {
+		t.Error("value is changed by compaction")
+	}
+	if v, _ := s.get("key"); v[0] != 199 {
+		t.Errorf("unexpected value after compaction: %d", v[0])
+	}
+	if v, _ := s.get("stable"); string(v) != "value" {
+		t.Errorf("value is lost on compaction: %q", v)
+	}
+}
+
+func TestCoreSlabValues(t *testing.T) {
+	setup_logger()
+	conf := getTestConfig(4)
+	conf.Storage.SlabValues = true
+	core, err := MakeStorageEmpty(conf)
+	if err != nil {
+		t.Fatalf("create storage: %s", err)
+	}
+	defer core.Close()
+
+	for k, v := range getTestKV() {
+		core.Set(k, v, time.Minute)
+	}
+	dump, err := core.DumpStorage()
+	if err != nil {
+		t.Fatalf("dump: %s", err)
+	}
+	restored, err := MakeStorageFromDump(conf, dump)
+	if err != nil {
+		t.Fatalf("restore: %s", err)
+	}
+	defer restored.Close()
+	for _, shard := range *restored {
+		if shard.slab == nil || shard.Items != nil {
+			t.Fatal("restored storage doesn't keep values in slabs")
+		}
+	}
+	for k, v := range getTestKV() {
+		if value, ok := restored.Get(k); !ok || string(value) != string(v) {
+			t.Errorf("unexpected value of %s: %q", k, value)
+		}
+	}
+}
+
+/*
+GC cost with many plain values kept in maps and in slabs:
+
+	go test ./storage -run ^$ -bench GC -benchtime 20x
+
+Values are written as usual, with TTL, so pointers of expiration queue
+and key strings of maps are marked in both modes.
+*/
+func BenchmarkCoreGC(b *testing.B) {
+	setup_logger()
+	for _, slabValues := range []bool{false, true} {
+		name := "map"
+		if slabValues {
+			name = "slab"
+		}
+		b.Run(name, func(b *testing.B) {
+			// expiration queue lookup is linear in its size, keep shards small
+			conf := getTestConfig(MAX_SHARDS)
+			conf.Storage.SlabValues = slabValues
+			core, err := MakeStorageEmpty(conf)
+			if err != nil {
+				b.Fatalf("create storage: %s", err)
+			}
+			defer core.Close()
+			for i := 0; i < 1000000; i++ {
+				core.Set(fmt.Sprintf("key%d", i), []byte("value of moderate size"), time.Hour)
+			}
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			if cycles := after.NumGC - before.NumGC; cycles > 0 {
+				b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(cycles), "pause-ns/gc")
+			}
+			runtime.KeepAlive(core)
+		})
+	}
+}
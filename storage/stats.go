@@ -0,0 +1,89 @@
+package storage
+
+const (
+	// rough per-element overhead of maps and slices, bytes
+	ELEMENT_OVERHEAD = 16
+	// score storage in sorted sets
+	SCORE_SIZE = 8
+)
+
+type ShardStats struct {
+	Keys int
+	// estimated size of keys and values, bytes
+	Bytes int
+}
+
+// Collect key count and memory estimate of each shard.
+func (c ConcurrentMap) ShardStats() []ShardStats {
+	stats := make([]ShardStats, len(c))
+	for i, shard := range c {
+		shard.RLock()
+		stats[i] = shard.stats()
+		shard.RUnlock()
+	}
+	return stats
+}
+
+/* internals */
+
+// Counters are kept by writers, so stats are collected without walking keys.
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) stats() ShardStats {
+	stats := ShardStats{
+		Keys:  c.itemCount() + len(c.Structures),
+		Bytes: c.itemBytes + c.structBytes}
+	if c.slab != nil {
+		stats.Bytes += c.slab.size()
+	}
+	return stats
+}
+
+// walk all keys, used once data is replaced as a whole
+func dataStats(items map[string][]byte, structures map[string]*Structure) ShardStats {
+	stats := ShardStats{Keys: len(items) + len(structures)}
+	for k, v := range items {
+		stats.Bytes += itemSize(k, v)
+	}
+	for k, s := range structures {
+		stats.Bytes += structureSize(k, s)
+	}
+	return stats
+}
+
+func itemSize(key string, value []byte) int {
+	return len(key) + len(value) + ELEMENT_OVERHEAD
+}
+
+func structureSize(key string, s *Structure) int {
+	return len(key) + s.size() + ELEMENT_OVERHEAD
+}
+
+// estimated memory taken by structure elements
+func (s *Structure) size() int {
+	return s.bytes
+}
+
+// walk all elements to estimate structure size
+func (s *Structure) measure() int {
+	size := 0
+	switch s.Type {
+	case TYPE_LIST:
+		for _, v := range s.List {
+			size += len(v) + ELEMENT_OVERHEAD
+		}
+	case TYPE_HASH:
+		for f, v := range s.Hash {
+			size += len(f) + len(v) + ELEMENT_OVERHEAD
+		}
+	case TYPE_SET:
+		for m := range s.Set {
+			size += len(m) + ELEMENT_OVERHEAD
+		}
+	case TYPE_ZSET:
+		// members are kept both in ordered slice and score index
+		for m := range s.ZScores {
+			size += 2*(len(m)+SCORE_SIZE) + ELEMENT_OVERHEAD
+		}
+	}
+	return size
+}
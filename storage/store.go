@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/dgtony/gcache/metrics"
+	"github.com/dgtony/gcache/utils"
+	"time"
+)
+
+// storage engines
+const (
+	ENGINE_SHARDED = "sharded"
+	ENGINE_RCU     = "rcu"
+)
+
+// Keyspace is a set of data operations, on the whole storage or single namespace.
+type Keyspace interface {
+	// plain values
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration) error
+	Remove(key string)
+	Keys() []string
+	KeysMask(mask string) ([]string, bool)
+	Type(key string) ValueType
+	// metadata of any value type
+	Object(key string) (KeyInfo, bool)
+
+	// lists
+	LPush(key string, ttl time.Duration, values ...[]byte) (int, error)
+	RPush(key string, ttl time.Duration, values ...[]byte) (int, error)
+	LRange(key string, start, stop int) ([][]byte, error)
+
+	// hashes
+	HSet(key string, ttl time.Duration, fields map[string][]byte) (int, error)
+	HGet(key, field string) ([]byte, bool, error)
+	HGetAll(key string) (map[string][]byte, error)
+
+	// sets
+	SAdd(key string, ttl time.Duration, members ...string) (int, error)
+	SIsMember(key, member string) (bool, error)
+	SMembers(key string) ([]string, error)
+
+	// sorted sets
+	ZAdd(key string, ttl time.Duration, members ...ZMember) (int, error)
+	ZRangeByScore(key string, min, max float64) ([]ZMember, error)
+}
+
+/*
+Store is key-value storage with expiration used by REST and replication layers.
+Snapshots made by any engine could be restored by any other one.
+*/
+type Store interface {
+	Keyspace
+
+	// snapshots
+	DumpStorage() ([]byte, error)
+	RestoreFromDump(snapshot []byte) error
+
+	// bulk removal, shard by shard, number of removed keys is returned
+	Flush() int
+	RemoveMask(mask string) (int, bool)
+
+	// keys starting with prefix, e.g. keys of named namespace
+	KeysPrefix(prefix string) []string
+
+	// memory analysis, shard by shard
+	Analyze(opts AnalyzeOptions) *Analysis
+
+	// maintenance
+	SetSweepInterval(interval time.Duration)
+	SweepInterval() time.Duration
+	ShardStats() []ShardStats
+	CollectMetrics() []metrics.Collector
+	Close()
+}
+
+var (
+	_ Store = (*ConcurrentMap)(nil)
+	_ Store = (*RCUMap)(nil)
+)
+
+// Create empty storage of configured engine.
+func NewStore(conf *utils.Config) (Store, error) {
+	switch conf.Storage.Engine {
+	case "", ENGINE_SHARDED:
+		m, err := MakeStorageEmpty(conf)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case ENGINE_RCU:
+		m, err := MakeRCUStorageEmpty(conf)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported storage engine %q", conf.Storage.Engine)
+}
+
+// Create storage of configured engine from snapshot, number of shards is taken from snapshot.
+func NewStoreFromDump(conf *utils.Config, snapshot []byte) (Store, error) {
+	switch conf.Storage.Engine {
+	case "", ENGINE_SHARDED:
+		m, err := MakeStorageFromDump(conf, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case ENGINE_RCU:
+		m, err := MakeRCUStorageFromDump(conf, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported storage engine %q", conf.Storage.Engine)
+}
@@ -0,0 +1,450 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgtony/gcache/utils"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Conformance suite, every storage engine must pass it.
+
+var testEngines = []string{ENGINE_SHARDED, testSlabEngine, ENGINE_RCU}
+
+// sharded engine with values in slabs
+const testSlabEngine = "sharded-slab"
+
+func TestStoreEngines(t *testing.T) {
+	setup_logger()
+	conf := getTestConfig(4)
+	conf.Storage.Engine = "unknown"
+	if _, err := NewStore(conf); err == nil {
+		t.Error("no error for unknown engine")
+	}
+	for _, engine := range testEngines {
+		for _, numShards := range []int{0, MAX_SHARDS + 1} {
+			if _, err := NewStore(getEngineConfig(engine, numShards)); err != ErrBadShardNumber {
+				t.Errorf("%s: expected bad shard number error for %d shards, got: %v", engine, numShards, err)
+			}
+		}
+	}
+}
+
+func TestStoreValues(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		if keys := store.Keys(); len(keys) != 0 {
+			t.Errorf("keys in empty storage: %v", keys)
+		}
+		if _, ok := store.Get("missing"); ok {
+			t.Error("missing key is found")
+		}
+		store.Remove("missing")
+
+		for k, v := range getTestKV() {
+			if err := store.Set(k, v, time.Minute); err != nil {
+				t.Errorf("cannot set %s: %s", k, err)
+			}
+		}
+		for k, v := range getTestKV() {
+			if value, ok := store.Get(k); !ok || string(value) != string(v) {
+				t.Errorf("unexpected value of %s: %q", k, value)
+			}
+			if store.Type(k) != TYPE_STRING {
+				t.Errorf("unexpected type of %s: %s", k, store.Type(k))
+			}
+		}
+		if err := store.Set("key1", []byte("updated"), time.Minute); err != nil {
+			t.Errorf("cannot update key: %s", err)
+		}
+		if value, _ := store.Get("key1"); string(value) != "updated" {
+			t.Errorf("value is not updated: %q", value)
+		}
+
+		store.Remove("key1")
+		if _, ok := store.Get("key1"); ok || store.Type("key1") != TYPE_NONE {
+			t.Error("key is not removed")
+		}
+		checkKeys(t, store.Keys(), "key2", "key3", "key4", "key5")
+
+		store.Set("other", []byte("v"), time.Minute)
+		if keys, ok := store.KeysMask("key[23]"); !ok {
+			t.Error("mask is rejected")
+		} else {
+			checkKeys(t, keys, "key2", "key3")
+		}
+		if _, ok := store.KeysMask("[bad"); ok {
+			t.Error("bad mask is accepted")
+		}
+
+		// limits
+		longKey := strings.Repeat("k", KEY_MAX_LEN+1)
+		if err := store.Set(longKey, []byte("v"), time.Minute); err != ErrKeyTooLong {
+			t.Errorf("too long key is accepted: %v", err)
+		}
+		if _, ok := store.Get(longKey); ok {
+			t.Error("too long key is found")
+		}
+		if err := store.Set("big", make([]byte, VALUE_MAX_SIZE+1), time.Minute); err != ErrValueTooBig {
+			t.Errorf("too big value is accepted: %v", err)
+		}
+		if err := store.Set("", []byte("v"), time.Minute); err != ErrEmptyKey {
+			t.Errorf("empty key is accepted: %v", err)
+		}
+		if err := store.Set("bad\x00key", []byte("v"), time.Minute); err != ErrKeyChars {
+			t.Errorf("binary key is accepted: %v", err)
+		}
+		if _, err := store.RPush("list", time.Minute, make([]byte, VALUE_MAX_SIZE+1)); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("too big list element is accepted: %v", err)
+		}
+		if _, err := store.HSet(longKey, time.Minute, map[string][]byte{"f": []byte("v")}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("hash with too long key is accepted: %v", err)
+		}
+		bigMember := string(make([]byte, VALUE_MAX_SIZE+1))
+		if _, err := store.SAdd("set", time.Minute, "a", bigMember); err != ErrValueTooBig {
+			t.Errorf("too big set member is accepted: %v", err)
+		}
+		if _, err := store.ZAdd("zset", time.Minute, ZMember{Member: bigMember, Score: 1}); err != ErrValueTooBig {
+			t.Errorf("too big sorted set member is accepted: %v", err)
+		}
+		if _, err := store.HSet("hash", time.Minute, map[string][]byte{bigMember: []byte("v")}); err != ErrValueTooBig {
+			t.Errorf("too big hash field name is accepted: %v", err)
+		}
+		if store.Type("set") != TYPE_NONE || store.Type("zset") != TYPE_NONE || store.Type("hash") != TYPE_NONE {
+			t.Error("structure is created with rejected members")
+		}
+	})
+}
+
+func TestStoreStructures(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		if n, err := store.RPush("list", time.Minute, []byte("b"), []byte("c")); err != nil || n != 2 {
+			t.Errorf("rpush => %d, %v", n, err)
+		}
+		if n, err := store.LPush("list", time.Minute, []byte("a")); err != nil || n != 3 {
+			t.Errorf("lpush => %d, %v", n, err)
+		}
+		if values, err := store.LRange("list", 0, -1); err != nil || !compareValues(values, []string{"a", "b", "c"}) {
+			t.Errorf("lrange => %q, %v", values, err)
+		}
+
+		if n, err := store.HSet("hash", time.Minute, map[string][]byte{"f1": []byte("v1"), "f2": []byte("v2")}); err != nil || n != 2 {
+			t.Errorf("hset => %d, %v", n, err)
+		}
+		if n, _ := store.HSet("hash", time.Minute, map[string][]byte{"f1": []byte("new")}); n != 0 {
+			t.Errorf("existing field is counted as new")
+		}
+		if value, ok, err := store.HGet("hash", "f1"); err != nil || !ok || string(value) != "new" {
+			t.Errorf("hget => %q, %t, %v", value, ok, err)
+		}
+		if fields, err := store.HGetAll("hash"); err != nil || len(fields) != 2 {
+			t.Errorf("hgetall => %v, %v", fields, err)
+		}
+
+		if n, err := store.SAdd("set", time.Minute, "a", "b", "a"); err != nil || n != 2 {
+			t.Errorf("sadd => %d, %v", n, err)
+		}
+		if ok, err := store.SIsMember("set", "b"); err != nil || !ok {
+			t.Errorf("sismember => %t, %v", ok, err)
+		}
+		if members, err := store.SMembers("set"); err != nil || len(members) != 2 {
+			t.Errorf("smembers => %v, %v", members, err)
+		}
+
+		if n, err := store.ZAdd("zset", time.Minute, ZMember{"a", 3}, ZMember{"b", 1}, ZMember{"c", 2}); err != nil || n != 3 {
+			t.Errorf("zadd => %d, %v", n, err)
+		}
+		if members, err := store.ZRangeByScore("zset", 1, 2); err != nil || len(members) != 2 || members[0].Member != "b" {
+			t.Errorf("zrangebyscore => %v, %v", members, err)
+		}
+
+		for key, valueType := range map[string]ValueType{"list": TYPE_LIST, "hash": TYPE_HASH, "set": TYPE_SET, "zset": TYPE_ZSET} {
+			if store.Type(key) != valueType {
+				t.Errorf("unexpected type of %s: %s", key, store.Type(key))
+			}
+		}
+
+		// wrong types
+		store.Set("plain", []byte("v"), time.Minute)
+		if _, err := store.LPush("plain", time.Minute, []byte("a")); err != ErrWrongType {
+			t.Errorf("lpush on plain value => %v", err)
+		}
+		if _, err := store.SMembers("hash"); err != ErrWrongType {
+			t.Errorf("smembers on hash => %v", err)
+		}
+
+		// missing keys
+		if members, err := store.SMembers("missing"); err != nil || len(members) != 0 {
+			t.Errorf("smembers on missing key => %v, %v", members, err)
+		}
+
+		// plain value replaces structure
+		store.Set("list", []byte("v"), time.Minute)
+		if store.Type("list") != TYPE_STRING {
+			t.Errorf("structure is not replaced: %s", store.Type("list"))
+		}
+		store.Remove("hash")
+		if store.Type("hash") != TYPE_NONE {
+			t.Error("structure is not removed")
+		}
+	})
+}
+
+func TestStoreExpiration(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		store.Set("short", []byte("v"), 10*time.Millisecond)
+		store.SAdd("short-set", 10*time.Millisecond, "a")
+		store.Set("long", []byte("v"), time.Minute)
+		store.SetSweepInterval(20 * time.Millisecond)
+		if store.SweepInterval() != 20*time.Millisecond {
+			t.Errorf("sweep interval is not changed: %s", store.SweepInterval())
+		}
+
+		// first sweep waits for configured interval
+		time.Sleep(1200 * time.Millisecond)
+		checkKeys(t, store.Keys(), "long")
+	})
+}
+
+func TestStoreFlush(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		store.Set("user:42:name", []byte("v"), time.Minute)
+		store.SAdd("user:42:roles", time.Minute, "admin")
+		store.Set("user:43:name", []byte("v"), time.Minute)
+		store.Set("other", []byte("v"), time.Minute)
+
+		if _, ok := store.RemoveMask("user:[42"); ok {
+			t.Error("bad mask is accepted")
+		}
+		if removed, ok := store.RemoveMask("user:42:*"); !ok || removed != 2 {
+			t.Errorf("unexpected number of removed keys: %d", removed)
+		}
+		checkKeys(t, store.Keys(), "other", "user:43:name")
+
+		if removed := store.Flush(); removed != 2 {
+			t.Errorf("unexpected number of flushed keys: %d", removed)
+		}
+		checkKeys(t, store.Keys())
+		store.Set("other", []byte("v"), time.Minute)
+		checkKeys(t, store.Keys(), "other")
+	})
+}
+
+func TestStoreSnapshots(t *testing.T) {
+	setup_logger()
+	for _, source := range testEngines {
+		store := newTestStore(t, source, 4)
+		store.Set("value", []byte("v"), time.Minute)
+		store.HSet("hash", time.Minute, map[string][]byte{"f": []byte("v")})
+		store.ZAdd("zset", time.Minute, ZMember{"a", 1})
+		dump, err := store.DumpStorage()
+		if err != nil {
+			t.Fatalf("%s: dump: %s", source, err)
+		}
+		store.Close()
+
+		// snapshot of any engine is restored by any other
+		for _, target := range testEngines {
+			restored, err := NewStoreFromDump(getEngineConfig(target, 4), dump)
+			if err != nil {
+				t.Fatalf("%s -> %s: make from dump: %s", source, target, err)
+			}
+			checkKeys(t, restored.Keys(), "hash", "value", "zset")
+			if value, ok, _ := restored.HGet("hash", "f"); !ok || string(value) != "v" {
+				t.Errorf("%s -> %s: hash is not restored", source, target)
+			}
+			restored.Close()
+
+			replaced := newTestStore(t, target, 4)
+			replaced.Set("old", []byte("v"), time.Minute)
+			if err := replaced.RestoreFromDump(dump); err != nil {
+				t.Errorf("%s -> %s: restore: %s", source, target, err)
+			}
+			checkKeys(t, replaced.Keys(), "hash", "value", "zset")
+			replaced.Close()
+
+			mismatched := newTestStore(t, target, 2)
+			mismatched.Set("old", []byte("v"), time.Minute)
+			if _, ok := mismatched.RestoreFromDump(dump).(*ShardMismatchError); !ok {
+				t.Errorf("%s -> %s: shard mismatch is not reported", source, target)
+			}
+			checkKeys(t, mismatched.Keys(), "old")
+			mismatched.Close()
+		}
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		store.Set("key", []byte("value"), time.Minute)
+		store.RPush("list", time.Minute, []byte("a"))
+		keys, bytes := 0, 0
+		for _, stats := range store.ShardStats() {
+			keys += stats.Keys
+			bytes += stats.Bytes
+		}
+		if keys != 2 || bytes == 0 {
+			t.Errorf("unexpected stats: %d keys, %d bytes", keys, bytes)
+		}
+		if len(store.CollectMetrics()) == 0 {
+			t.Error("no storage metrics")
+		}
+	})
+}
+
+func TestStoreStatsCounters(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		check := func(step string) {
+			t.Helper()
+			if stats, counted := store.ShardStats(), countStats(store); !reflect.DeepEqual(stats, counted) {
+				t.Errorf("%s: stats %+v differ from counted %+v", step, stats, counted)
+			}
+		}
+
+		store.Set("key", []byte("value"), time.Minute)
+		store.Set("key", []byte("longer value"), time.Minute)
+		store.Set("gone", []byte("value"), time.Minute)
+		store.RPush("list", time.Minute, []byte("a"), []byte("b"))
+		store.LPush("list", time.Minute, []byte("c"))
+		store.HSet("hash", time.Minute, map[string][]byte{"f": []byte("v"), "g": []byte("v")})
+		store.HSet("hash", time.Minute, map[string][]byte{"f": []byte("longer value")})
+		store.SAdd("set", time.Minute, "a", "b")
+		store.SAdd("set", time.Minute, "b", "c")
+		store.ZAdd("zset", time.Minute, ZMember{Member: "a", Score: 1}, ZMember{Member: "b", Score: 2})
+		store.ZAdd("zset", time.Minute, ZMember{Member: "a", Score: 3})
+		check("write")
+
+		store.Set("list", []byte("replaced"), time.Minute)
+		store.Remove("gone")
+		store.Remove("hash")
+		store.RemoveMask("zs*")
+		check("remove")
+
+		dump, err := store.DumpStorage()
+		if err != nil {
+			t.Fatalf("dump: %s", err)
+		}
+		store.Flush()
+		check("flush")
+		if err := store.RestoreFromDump(dump); err != nil {
+			t.Fatalf("restore: %s", err)
+		}
+		check("restore")
+		store.SAdd("set", time.Minute, "d")
+		check("restored write")
+	})
+}
+
+// meaningful with race detector
+func TestStoreConcurrentAccess(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, store Store) {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					key := fmt.Sprintf("key%d", j%20)
+					store.Set(key, []byte("v"), time.Minute)
+					store.Get(key)
+					store.HSet("hash"+key, time.Minute, map[string][]byte{"f": []byte("v")})
+					store.HGetAll("hash" + key)
+					store.Remove(key)
+					if j%50 == 0 {
+						if dump, err := store.DumpStorage(); err == nil {
+							store.RestoreFromDump(dump)
+						}
+						store.Keys()
+						store.ShardStats()
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+/* helpers */
+
+// walk all keys and structure elements, as stats did before counters
+func countStats(store Store) []ShardStats {
+	count := func(items map[string][]byte, structures map[string]*Structure) ShardStats {
+		stats := ShardStats{Keys: len(items) + len(structures)}
+		for k, v := range items {
+			stats.Bytes += itemSize(k, v)
+		}
+		for k, s := range structures {
+			stats.Bytes += len(k) + s.measure() + ELEMENT_OVERHEAD
+		}
+		return stats
+	}
+
+	var res []ShardStats
+	switch m := store.(type) {
+	case *ConcurrentMap:
+		for _, shard := range *m {
+			shard.RLock()
+			stats := count(shard.Items, shard.Structures)
+			if shard.slab != nil {
+				stats.Keys += shard.slab.len()
+				stats.Bytes += shard.slab.size()
+			}
+			shard.RUnlock()
+			res = append(res, stats)
+		}
+	case *RCUMap:
+		for _, shard := range m.shards {
+			items, structures := make(map[string][]byte), make(map[string]*Structure)
+			for key, k := range shard.load().keys {
+				if k.structure == nil {
+					items[key] = k.value
+				} else {
+					structures[key] = k.structure
+				}
+			}
+			res = append(res, count(items, structures))
+		}
+	}
+	return res
+}
+
+func forEachEngine(t *testing.T, test func(t *testing.T, store Store)) {
+	setup_logger()
+	for _, engine := range testEngines {
+		t.Run(engine, func(t *testing.T) {
+			store := newTestStore(t, engine, 4)
+			defer store.Close()
+			test(t, store)
+		})
+	}
+}
+
+func newTestStore(t *testing.T, engine string, numShards int) Store {
+	store, err := NewStore(getEngineConfig(engine, numShards))
+	if err != nil {
+		t.Fatalf("create %s storage: %s", engine, err)
+	}
+	return store
+}
+
+func getEngineConfig(engine string, numShards int) *utils.Config {
+	conf := getTestConfig(numShards)
+	conf.Storage.Engine = engine
+	if engine == testSlabEngine {
+		conf.Storage.Engine = ENGINE_SHARDED
+		conf.Storage.SlabValues = true
+	}
+	conf.Storage.ExpiredKeyCheckInterval = 1
+	return conf
+}
+
+func checkKeys(t *testing.T, keys []string, expected ...string) {
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected keys => expected: %v, got: %v", expected, keys)
+	}
+}
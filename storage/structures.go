@@ -0,0 +1,569 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+type ValueType uint8
+
+const (
+	TYPE_NONE ValueType = iota
+	// plain value, usually JSON blob
+	TYPE_STRING
+	TYPE_LIST
+	TYPE_HASH
+	TYPE_SET
+	TYPE_ZSET
+)
+
+var (
+	ErrWrongType      = errors.New("operation against a key holding the wrong kind of value")
+	ErrInvalidKey     = errors.New("invalid key")
+	ErrInvalidValue   = errors.New("invalid value")
+	ErrBadShardNumber = errors.New("wrong number of shards")
+)
+
+var valueTypeNames = map[ValueType]string{
+	TYPE_NONE:   "none",
+	TYPE_STRING: "string",
+	TYPE_LIST:   "list",
+	TYPE_HASH:   "hash",
+	TYPE_SET:    "set",
+	TYPE_ZSET:   "zset"}
+
+func (t ValueType) String() string {
+	if name, ok := valueTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+/*
+Structure keeps value of complex type natively.
+Only field corresponding to the structure type is used.
+Note: Structure is not thread-safe and must be changed only under shard lock!
+*/
+type Structure struct {
+	Type ValueType
+	List [][]byte
+	Hash map[string][]byte
+	Set  map[string]bool
+	// sorted set members ordered by score and member
+	ZSet []ZMember
+	// sorted set member scores
+	ZScores map[string]float64
+	// estimated size of elements, kept by changes, see size()
+	bytes int
+}
+
+func newStructure(t ValueType) *Structure {
+	s := &Structure{Type: t}
+	switch t {
+	case TYPE_HASH:
+		s.Hash = make(map[string][]byte)
+	case TYPE_SET:
+		s.Set = make(map[string]bool)
+	case TYPE_ZSET:
+		s.ZScores = make(map[string]float64)
+	}
+	return s
+}
+
+/* lists */
+
+// insert values at the head (left) or tail of the list, return new list length
+func (s *Structure) push(left bool, values [][]byte) int {
+	for _, v := range values {
+		s.bytes += len(v) + ELEMENT_OVERHEAD
+	}
+	if !left {
+		s.List = append(s.List, values...)
+		return len(s.List)
+	}
+
+	// each value is inserted at the head, so values are reversed
+	newList := make([][]byte, 0, len(values)+len(s.List))
+	for i := len(values) - 1; i >= 0; i-- {
+		newList = append(newList, values[i])
+	}
+	s.List = append(newList, s.List...)
+	return len(s.List)
+}
+
+// return list elements between start and stop indexes inclusive,
+// negative index means offset from the end of the list
+func (s *Structure) lrange(start, stop int) [][]byte {
+	length := len(s.List)
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return [][]byte{}
+	}
+
+	res := make([][]byte, stop-start+1)
+	copy(res, s.List[start:stop+1])
+	return res
+}
+
+/* hashes */
+
+// set hash fields, return number of newly created fields
+func (s *Structure) hset(fields map[string][]byte) int {
+	added := 0
+	for field, value := range fields {
+		if old, ok := s.Hash[field]; ok {
+			s.bytes -= len(field) + len(old) + ELEMENT_OVERHEAD
+		} else {
+			added++
+		}
+		s.Hash[field] = value
+		s.bytes += len(field) + len(value) + ELEMENT_OVERHEAD
+	}
+	return added
+}
+
+func (s *Structure) hgetall() map[string][]byte {
+	res := make(map[string][]byte, len(s.Hash))
+	for field, value := range s.Hash {
+		res[field] = value
+	}
+	return res
+}
+
+/* sets */
+
+// add members to set, return number of new members
+func (s *Structure) sadd(members []string) int {
+	added := 0
+	for _, member := range members {
+		if !s.Set[member] {
+			s.Set[member] = true
+			s.bytes += len(member) + ELEMENT_OVERHEAD
+			added++
+		}
+	}
+	return added
+}
+
+func (s *Structure) smembers() []string {
+	res := make([]string, 0, len(s.Set))
+	for member := range s.Set {
+		res = append(res, member)
+	}
+	return res
+}
+
+/* sorted sets */
+
+// add members or update scores of existing ones, return number of new members
+func (s *Structure) zadd(members []ZMember) int {
+	added := 0
+	for _, m := range members {
+		score, ok := s.ZScores[m.Member]
+		if ok {
+			if score == m.Score {
+				continue
+			}
+			s.zremove(ZMember{Member: m.Member, Score: score})
+		} else {
+			s.bytes += 2*(len(m.Member)+SCORE_SIZE) + ELEMENT_OVERHEAD
+			added++
+		}
+		s.zinsert(m)
+		s.ZScores[m.Member] = m.Score
+	}
+	return added
+}
+
+// return members with scores between min and max inclusive
+func (s *Structure) zrangeByScore(min, max float64) []ZMember {
+	from := sort.Search(len(s.ZSet), func(i int) bool {
+		return s.ZSet[i].Score >= min
+	})
+	res := make([]ZMember, 0)
+	for i := from; i < len(s.ZSet) && s.ZSet[i].Score <= max; i++ {
+		res = append(res, s.ZSet[i])
+	}
+	return res
+}
+
+func (s *Structure) zsearch(m ZMember) int {
+	return sort.Search(len(s.ZSet), func(i int) bool {
+		return !zless(s.ZSet[i], m)
+	})
+}
+
+func (s *Structure) zinsert(m ZMember) {
+	i := s.zsearch(m)
+	s.ZSet = append(s.ZSet, ZMember{})
+	copy(s.ZSet[i+1:], s.ZSet[i:])
+	s.ZSet[i] = m
+}
+
+func (s *Structure) zremove(m ZMember) {
+	i := s.zsearch(m)
+	if i < len(s.ZSet) && s.ZSet[i] == m {
+		s.ZSet = append(s.ZSet[:i], s.ZSet[i+1:]...)
+	}
+}
+
+func zless(a, b ZMember) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.Member < b.Member
+}
+
+/* common */
+
+// deep copy, element values are immutable and shared
+func (s *Structure) clone() *Structure {
+	c := newStructure(s.Type)
+	c.bytes = s.bytes
+	switch s.Type {
+	case TYPE_LIST:
+		c.List = make([][]byte, len(s.List))
+		copy(c.List, s.List)
+	case TYPE_HASH:
+		c.Hash = s.hgetall()
+	case TYPE_SET:
+		for member := range s.Set {
+			c.Set[member] = true
+		}
+	case TYPE_ZSET:
+		c.ZSet = make([]ZMember, len(s.ZSet))
+		copy(c.ZSet, s.ZSet)
+		for member, score := range s.ZScores {
+			c.ZScores[member] = score
+		}
+	}
+	return c
+}
+
+// recreate empty containers omitted in serialized snapshot and element size
+func (s *Structure) restoreEmpty() {
+	s.bytes = s.measure()
+	switch {
+	case s.Type == TYPE_HASH && s.Hash == nil:
+		s.Hash = make(map[string][]byte)
+	case s.Type == TYPE_SET && s.Set == nil:
+		s.Set = make(map[string]bool)
+	case s.Type == TYPE_ZSET && s.ZScores == nil:
+		s.ZScores = make(map[string]float64)
+	}
+}
+
+func validScore(score float64) bool {
+	return !math.IsNaN(score)
+}
+
+/* storage methods */
+
+// Return type of value stored with given key
+func (c *ConcurrentMap) Type(key string) ValueType {
+	defer opType.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return TYPE_NONE
+	}
+
+	valueType := TYPE_NONE
+	shard.RLock()
+	if shard.hasItem(key) {
+		valueType = TYPE_STRING
+	} else if s, ok := shard.Structures[key]; ok {
+		valueType = s.Type
+	}
+	shard.RUnlock()
+	return valueType
+}
+
+// Insert values at the head of the list, return new list length.
+// List will be created if key doesn't exist, key TTL is updated.
+func (c *ConcurrentMap) LPush(key string, ttl time.Duration, values ...[]byte) (int, error) {
+	defer opLPush.track(key, time.Now())
+	return c.push(key, true, ttl, values)
+}
+
+// Insert values at the tail of the list, return new list length.
+// List will be created if key doesn't exist, key TTL is updated.
+func (c *ConcurrentMap) RPush(key string, ttl time.Duration, values ...[]byte) (int, error) {
+	defer opRPush.track(key, time.Now())
+	return c.push(key, false, ttl, values)
+}
+
+// Return list elements between start and stop indexes inclusive.
+// Negative index is an offset from the end, e.g. -1 is the last element.
+func (c *ConcurrentMap) LRange(key string, start, stop int) ([][]byte, error) {
+	defer opLRange.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return nil, err
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+	s, err := shard.getStructure(key, TYPE_LIST, false)
+	if err != nil || s == nil {
+		return [][]byte{}, err
+	}
+	return s.lrange(start, stop), nil
+}
+
+// Set hash fields, return number of new fields.
+// Hash will be created if key doesn't exist, key TTL is updated.
+func (c *ConcurrentMap) HSet(key string, ttl time.Duration, fields map[string][]byte) (int, error) {
+	defer opHSet.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return 0, err
+	}
+	// field names are limited as set members
+	for field, value := range fields {
+		if err := shard.limits.checkMembers(key, field); err != nil {
+			return 0, err
+		}
+		if err := shard.limits.checkValues(key, value); err != nil {
+			return 0, err
+		}
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.update(key, TYPE_HASH, ttl, func(s *Structure) int {
+		return s.hset(fields)
+	})
+}
+
+func (c *ConcurrentMap) HGet(key, field string) ([]byte, bool, error) {
+	defer opHGet.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+	s, err := shard.getStructure(key, TYPE_HASH, false)
+	if err != nil || s == nil {
+		return nil, false, err
+	}
+	value, ok := s.Hash[field]
+	return value, ok, nil
+}
+
+func (c *ConcurrentMap) HGetAll(key string) (map[string][]byte, error) {
+	defer opHGetAll.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return nil, err
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+	s, err := shard.getStructure(key, TYPE_HASH, false)
+	if err != nil || s == nil {
+		return map[string][]byte{}, err
+	}
+	return s.hgetall(), nil
+}
+
+// Add members to set, return number of new members.
+// Set will be created if key doesn't exist, key TTL is updated.
+func (c *ConcurrentMap) SAdd(key string, ttl time.Duration, members ...string) (int, error) {
+	defer opSAdd.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := shard.limits.checkMembers(key, members...); err != nil {
+		return 0, err
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.update(key, TYPE_SET, ttl, func(s *Structure) int {
+		return s.sadd(members)
+	})
+}
+
+func (c *ConcurrentMap) SIsMember(key, member string) (bool, error) {
+	defer opSIsMember.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return false, err
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+	s, err := shard.getStructure(key, TYPE_SET, false)
+	if err != nil || s == nil {
+		return false, err
+	}
+	return s.Set[member], nil
+}
+
+func (c *ConcurrentMap) SMembers(key string) ([]string, error) {
+	defer opSMembers.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return nil, err
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+	s, err := shard.getStructure(key, TYPE_SET, false)
+	if err != nil || s == nil {
+		return []string{}, err
+	}
+	return s.smembers(), nil
+}
+
+// Add members to sorted set or update scores of existing ones,
+// return number of new members.
+// Sorted set will be created if key doesn't exist, key TTL is updated.
+func (c *ConcurrentMap) ZAdd(key string, ttl time.Duration, members ...ZMember) (int, error) {
+	defer opZAdd.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range members {
+		if !validScore(m.Score) {
+			return 0, ErrInvalidValue
+		}
+		if err := shard.limits.checkMembers(key, m.Member); err != nil {
+			return 0, err
+		}
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.update(key, TYPE_ZSET, ttl, func(s *Structure) int {
+		return s.zadd(members)
+	})
+}
+
+// Return sorted set members with score between min and max inclusive
+func (c *ConcurrentMap) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	defer opZRangeByScore.track(key, time.Now())
+	shard, err := c.getShard(key)
+	if err != nil {
+		return nil, err
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+	s, err := shard.getStructure(key, TYPE_ZSET, false)
+	if err != nil || s == nil {
+		return []ZMember{}, err
+	}
+	return s.zrangeByScore(min, max), nil
+}
+
+/* internals */
+
+func (c *ConcurrentMap) push(key string, left bool, ttl time.Duration, values [][]byte) (int, error) {
+	shard, err := c.getShard(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := shard.limits.checkValues(key, values...); err != nil {
+		return 0, err
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.update(key, TYPE_LIST, ttl, func(s *Structure) int {
+		return s.push(left, values)
+	})
+}
+
+// Apply change to structure of given type stored with the key, record write
+// and keep shard size. Structure is created if key doesn't exist.
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) update(key string, t ValueType, ttl time.Duration, change func(s *Structure) int) (int, error) {
+	_, exists := c.Structures[key]
+	s, err := c.getStructure(key, t, true)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		c.structBytes += structureSize(key, s)
+	}
+	before := s.size()
+	res := change(s)
+	c.structBytes += s.size() - before
+	c.written(key, ttl)
+	return res, nil
+}
+
+// Replace all structures.
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) setStructures(structures map[string]*Structure) {
+	c.Structures = structures
+	c.structBytes = dataStats(nil, structures).Bytes
+}
+
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) removeStructure(key string) {
+	if s, ok := c.Structures[key]; ok {
+		c.structBytes -= structureSize(key, s)
+		delete(c.Structures, key)
+	}
+}
+
+// Return structure of given type stored with the key.
+// If key doesn't exist new structure is created with create flag,
+// otherwise nil is returned and existing structure is accessed for reading.
+// do not use outside - not thread-safe!
+func (c *ConcurrentMapShard) getStructure(key string, t ValueType, create bool) (*Structure, error) {
+	s, err := lookupStructure(c.hasItem(key), c.Structures, key, t, create)
+	if s != nil && !create {
+		c.accessed(key)
+	}
+	return s, err
+}
+
+// Return structure of given type, new structure is added to the map with create flag.
+func lookupStructure(plain bool, structures map[string]*Structure, key string, t ValueType, create bool) (*Structure, error) {
+	if plain {
+		return nil, ErrWrongType
+	}
+
+	s, ok := structures[key]
+	if !create {
+		countLookup(ok)
+	}
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		s = newStructure(t)
+		structures[key] = s
+		return s, nil
+	}
+
+	if s.Type != t {
+		return nil, ErrWrongType
+	}
+	return s, nil
+}
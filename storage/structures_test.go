@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStructuresList(t *testing.T) {
+	setup_logger()
+	core, err := MakeStorageEmpty(getTestConfig(4))
+	if err != nil {
+		t.Errorf("create empty storage: %s", err)
+	}
+
+	if length, err := core.RPush("list", time.Minute, []byte("b"), []byte("c")); err != nil || length != 2 {
+		t.Errorf("rpush failure => length: %d, error: %v", length, err)
+	}
+	if length, err := core.LPush("list", time.Minute, []byte("a"), []byte("z")); err != nil || length != 4 {
+		t.Errorf("lpush failure => length: %d, error: %v", length, err)
+	}
+	if core.Type("list") != TYPE_LIST {
+		t.Errorf("wrong value type: %s", core.Type("list"))
+	}
+
+	testCases := []struct {
+		Start, Stop int
+		Expected    []string
+	}{
+		{0, -1, []string{"z", "a", "b", "c"}},
+		{1, 2, []string{"a", "b"}},
+		{-2, -1, []string{"b", "c"}},
+		{2, 100, []string{"b", "c"}},
+		{3, 1, []string{}},
+		{-100, 0, []string{"z"}},
+	}
+	for _, c := range testCases {
+		values, err := core.LRange("list", c.Start, c.Stop)
+		if err != nil || !compareValues(values, c.Expected) {
+			t.Errorf("lrange %d..%d failure => expected: %v, get: %q, error: %v", c.Start, c.Stop, c.Expected, values, err)
+		}
+	}
+
+	// nonexistent list is empty
+	if values, err := core.LRange("nolist", 0, -1); err != nil || len(values) != 0 {
+		t.Errorf("nonexistent list is not empty: %q, error: %v", values, err)
+	}
+}
+
+func TestStructuresHashAndSet(t *testing.T) {
+	setup_logger()
+	core, err := MakeStorageEmpty(getTestConfig(4))
+	if err != nil {
+		t.Errorf("create empty storage: %s", err)
+	}
+
+	// hash
+	added, err := core.HSet("hash", time.Minute, map[string][]byte{"f1": []byte("1"), "f2": []byte("2")})
+	if err != nil || added != 2 {
+		t.Errorf("hset failure => added: %d, error: %v", added, err)
+	}
+	added, _ = core.HSet("hash", time.Minute, map[string][]byte{"f2": []byte("22"), "f3": []byte("3")})
+	if added != 1 {
+		t.Errorf("hset update failure => added: %d", added)
+	}
+	if value, ok, err := core.HGet("hash", "f2"); !ok || err != nil || string(value) != "22" {
+		t.Errorf("hget failure => value: %s, error: %v", value, err)
+	}
+	if fields, err := core.HGetAll("hash"); err != nil || len(fields) != 3 || string(fields["f1"]) != "1" {
+		t.Errorf("hgetall failure => fields: %q, error: %v", fields, err)
+	}
+
+	// set
+	added, err = core.SAdd("set", time.Minute, "a", "b", "a")
+	if err != nil || added != 2 {
+		t.Errorf("sadd failure => added: %d, error: %v", added, err)
+	}
+	if isMember, err := core.SIsMember("set", "b"); !isMember || err != nil {
+		t.Errorf("sismember failure for existing member, error: %v", err)
+	}
+	if isMember, _ := core.SIsMember("set", "c"); isMember {
+		t.Error("sismember failure for nonexistent member")
+	}
+	if members, _ := core.SMembers("set"); len(members) != 2 {
+		t.Errorf("smembers failure: %v", members)
+	}
+}
+
+func TestStructuresSortedSet(t *testing.T) {
+	setup_logger()
+	core, err := MakeStorageEmpty(getTestConfig(4))
+	if err != nil {
+		t.Errorf("create empty storage: %s", err)
+	}
+
+	added, err := core.ZAdd("zset", time.Minute,
+		ZMember{Member: "c", Score: 3},
+		ZMember{Member: "a", Score: 1},
+		ZMember{Member: "b", Score: 2},
+		ZMember{Member: "bb", Score: 2})
+	if err != nil || added != 4 {
+		t.Errorf("zadd failure => added: %d, error: %v", added, err)
+	}
+
+	// update score
+	if added, _ := core.ZAdd("zset", time.Minute, ZMember{Member: "a", Score: 10}); added != 0 {
+		t.Errorf("zadd update failure => added: %d", added)
+	}
+
+	members, err := core.ZRangeByScore("zset", 2, 10)
+	expected := []ZMember{{"b", 2}, {"bb", 2}, {"c", 3}, {"a", 10}}
+	if err != nil || len(members) != len(expected) {
+		t.Fatalf("zrangebyscore failure => members: %v, error: %v", members, err)
+	}
+	for i := range expected {
+		if members[i] != expected[i] {
+			t.Errorf("wrong sorted set order => expected: %v, get: %v", expected, members)
+		}
+	}
+
+	if members, _ := core.ZRangeByScore("zset", math.Inf(-1), 1); len(members) != 0 {
+		t.Errorf("zrangebyscore returns members out of range: %v", members)
+	}
+	if _, err := core.ZAdd("zset", time.Minute, ZMember{Member: "n", Score: math.NaN()}); err != ErrInvalidValue {
+		t.Error("no error for NaN score")
+	}
+}
+
+func TestStructuresWrongType(t *testing.T) {
+	setup_logger()
+	core, err := MakeStorageEmpty(getTestConfig(4))
+	if err != nil {
+		t.Errorf("create empty storage: %s", err)
+	}
+
+	core.Set("plain", []byte("value"), time.Minute)
+	core.SAdd("set", time.Minute, "a")
+
+	if _, err := core.LPush("plain", time.Minute, []byte("a")); err != ErrWrongType {
+		t.Error("no wrong type error for list operation on plain value")
+	}
+	if _, err := core.HGetAll("set"); err != ErrWrongType {
+		t.Error("no wrong type error for hash operation on set")
+	}
+	if _, ok := core.Get("set"); ok {
+		t.Error("plain value returned for set")
+	}
+
+	// plain value replaces structure
+	core.Set("set", []byte("value"), time.Minute)
+	if core.Type("set") != TYPE_STRING {
+		t.Errorf("structure is not replaced, type: %s", core.Type("set"))
+	}
+
+	// removed structure
+	core.Remove("plain")
+	if core.Type("plain") != TYPE_NONE {
+		t.Error("removed key still exists")
+	}
+}
+
+func TestStructuresDumpRestore(t *testing.T) {
+	setup_logger()
+	core, err := MakeStorageEmpty(getTestConfig(4))
+	if err != nil {
+		t.Errorf("create empty storage: %s", err)
+	}
+
+	core.RPush("list", time.Minute, []byte("a"), []byte("b"))
+	core.HSet("hash", time.Minute, map[string][]byte{"f": []byte("v")})
+	core.SAdd("set", time.Minute, "m")
+	core.ZAdd("zset", time.Minute, ZMember{Member: "m", Score: 1})
+
+	dump, err := core.DumpStorage()
+	if err != nil {
+		t.Errorf("make dump: %s", err)
+	}
+
+	restored, err := MakeStorageFromDump(getTestConfig(4), dump)
+	if err != nil {
+		t.Errorf("restore from dump: %s", err)
+	}
+
+	if values, _ := restored.LRange("list", 0, -1); !compareValues(values, []string{"a", "b"}) {
+		t.Errorf("restored list doesn't match: %q", values)
+	}
+	if value, ok, _ := restored.HGet("hash", "f"); !ok || string(value) != "v" {
+		t.Error("restored hash doesn't match")
+	}
+	if isMember, _ := restored.SIsMember("set", "m"); !isMember {
+		t.Error("restored set doesn't match")
+	}
+	if members, _ := restored.ZRangeByScore("zset", 0, 2); len(members) != 1 || members[0].Member != "m" {
+		t.Error("restored sorted set doesn't match")
+	}
+
+	// restored structures must be modifiable
+	if _, err := restored.HSet("hash", time.Minute, map[string][]byte{"f2": []byte("v2")}); err != nil {
+		t.Errorf("cannot modify restored hash: %s", err)
+	}
+	if len(restored.Keys()) != 4 {
+		t.Errorf("wrong number of restored keys: %d", len(restored.Keys()))
+	}
+}
+
+/* helpers */
+
+func compareValues(values [][]byte, expected []string) bool {
+	if len(values) != len(expected) {
+		return false
+	}
+	for i := range values {
+		if string(values[i]) != expected[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,26 +1,86 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"github.com/BurntSushi/toml"
+	"github.com/gobwas/glob"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// upper limit of storage shards, used by storage engines as well
+const MAX_SHARDS = 4096
+
 type Config struct {
 	General     GeneralSettings     `toml:"general"`
 	Storage     StorageSettings     `toml:"storage"`
+	Limits      LimitsSettings      `toml:"limits"`
 	Replication ReplicationSettings `toml:"replication"`
 	ClientHTTP  ClientHTTPSettings  `toml:"client-HTTP"`
+	PubSub      PubSubSettings      `toml:"pubsub"`
+	Auth        AuthSettings        `toml:"auth"`
+	Admin       AdminSettings       `toml:"admin"`
+	SlowLog     SlowLogSettings     `toml:"slowlog"`
+	Audit       AuditSettings       `toml:"audit"`
 }
 
 type GeneralSettings struct {
 	LogLevel  string `toml:"log_level"`
 	LogFormat string `toml:"log_format"`
 	LogOut    string `toml:"log_out"`
+	// file output with rotation
+	LogFile string `toml:"log_file"`
+	// megabytes
+	LogMaxSize    int `toml:"log_max_size"`
+	LogMaxBackups int `toml:"log_max_backups"`
+	// levels overriding log_level for given modules
+	LogModuleLevels map[string]string `toml:"log_modules"`
 }
 
 type StorageSettings struct {
-	NumShards               int `toml:"shards"`
-	ExpiredKeyCheckInterval int `toml:"key_exp_check_interval"`
+	// storage implementation: sharded or rcu
+	Engine                  string `toml:"engine"`
+	NumShards               int    `toml:"shards"`
+	ExpiredKeyCheckInterval int    `toml:"key_exp_check_interval"`
+	// keep plain values in per-shard byte slabs, sharded engine only
+	SlabValues bool `toml:"slab_values"`
+	// compress plain values of at least given size in bytes, 0 disables, sharded engine only
+	CompressThreshold int  `toml:"compress_threshold"`
+	CompressSnapshots bool `toml:"compress_snapshots"`
+	// limit of decompressed snapshot size in megabytes
+	SnapshotMaxSize int `toml:"snapshot_max_size"`
+	// named namespaces besides default one
+	Namespaces []NamespaceSettings `toml:"namespaces"`
+}
+
+type NamespaceSettings struct {
+	Name string `toml:"name"`
+	// zero means common limit
+	KeyMaxLen    int `toml:"key_max_len"`
+	ValueMaxSize int `toml:"value_max_size"`
+	// TTL of keys set without one, seconds, zero requires TTL in every request
+	DefaultTTL int `toml:"default_ttl"`
+}
+
+type LimitsSettings struct {
+	// bytes
+	KeyMaxLen    int `toml:"key_max_len"`
+	ValueMaxSize int `toml:"value_max_size"`
+	// allowed key characters: any or printable
+	KeyChars string `toml:"key_chars"`
+	// limits for keys with given prefixes
+	Prefixes []PrefixLimits `toml:"prefixes"`
+}
+
+type PrefixLimits struct {
+	Prefix string `toml:"prefix"`
+	// zero means common limit
+	KeyMaxLen    int `toml:"key_max_len"`
+	ValueMaxSize int `toml:"value_max_size"`
 }
 
 type ReplicationSettings struct {
@@ -32,6 +92,17 @@ type ReplicationSettings struct {
 	DumpUpdatePeriod     int    `toml:"dump_update_period"`
 	MasterAddr           string `toml:"master_addr"`
 	MasterSecret         string `toml:"master_secret"`
+	MasterSecretPrevious string `toml:"master_secret_previous"`
+	SecretGracePeriod    int    `toml:"secret_grace_period"`
+	// slave reconnection, sec, never give up if zero
+	ReconnectMaxWait int    `toml:"reconnect_max_wait"`
+	ReconnectGiveUp  int    `toml:"reconnect_give_up"`
+	TLSEnabled       bool   `toml:"tls"`
+	TLSCert          string `toml:"tls_cert"`
+	TLSKey           string `toml:"tls_key"`
+	TLSCA            string `toml:"tls_ca"`
+	TLSClientAuth    bool   `toml:"tls_client_auth"`
+	TLSServerName    string `toml:"tls_server_name"`
 }
 
 type ClientHTTPSettings struct {
@@ -39,17 +110,327 @@ type ClientHTTPSettings struct {
 	Port        string `toml:"port"`
 	RoutePrefix string `toml:"prefix"`
 	IdleTimeout int    `toml:"idle_timeout"`
+	TLSCert     string `toml:"tls_cert"`
+	TLSKey      string `toml:"tls_key"`
+}
+
+type AdminSettings struct {
+	Enabled bool   `toml:"enabled"`
+	Addr    string `toml:"address"`
+	Port    string `toml:"port"`
+}
+
+type SlowLogSettings struct {
+	Enabled bool `toml:"enabled"`
+	// microseconds
+	Threshold int `toml:"threshold"`
+	MaxLen    int `toml:"max_len"`
+}
+
+type AuditSettings struct {
+	Enabled bool   `toml:"enabled"`
+	File    string `toml:"file"`
+	// megabytes
+	MaxSize    int `toml:"max_size"`
+	MaxBackups int `toml:"max_backups"`
+	// glob patterns of keys logged, all keys if empty
+	Keys      []string `toml:"keys"`
+	QueueSize int      `toml:"queue_size"`
+}
+
+type PubSubSettings struct {
+	QueueSize int `toml:"queue_size"`
+}
+
+type AuthSettings struct {
+	Enabled    bool            `toml:"enabled"`
+	HMACSecret string          `toml:"hmac_secret"`
+	Tokens     []TokenSettings `toml:"tokens"`
+}
+
+type TokenSettings struct {
+	Name       string   `toml:"name"`
+	Token      string   `toml:"token"`
+	Permission string   `toml:"permission"`
+	Prefixes   []string `toml:"prefixes"`
+	Namespaces []string `toml:"namespaces"`
+}
+
+// Default value for every setting.
+func DefaultConfig() *Config {
+	return &Config{
+		General: GeneralSettings{
+			LogLevel:      "info",
+			LogFormat:     "short",
+			LogOut:        "stdout",
+			LogFile:       "./gcache.log",
+			LogMaxSize:    100,
+			LogMaxBackups: 5},
+		Storage: StorageSettings{
+			Engine:                  "sharded",
+			NumShards:               16,
+			ExpiredKeyCheckInterval: 10,
+			CompressSnapshots:       true,
+			SnapshotMaxSize:         4096},
+		Limits: LimitsSettings{
+			KeyMaxLen:    2048,
+			ValueMaxSize: 10485760,
+			KeyChars:     "any"},
+		Replication: ReplicationSettings{
+			NodeRole:          "standalone",
+			CacheFile:         "./cache_dump.dat",
+			FileWritePeriod:   30,
+			DumpUpdatePeriod:  20,
+			MasterAddr:        ":4545",
+			SecretGracePeriod: 3600,
+			ReconnectMaxWait:  60},
+		ClientHTTP: ClientHTTPSettings{
+			Addr:        "0.0.0.0",
+			Port:        "8080",
+			RoutePrefix: "cache/v1",
+			IdleTimeout: 900},
+		PubSub: PubSubSettings{
+			QueueSize: 256},
+		Admin: AdminSettings{
+			Enabled: false,
+			Addr:    "127.0.0.1",
+			Port:    "8081"},
+		SlowLog: SlowLogSettings{
+			Enabled:   true,
+			Threshold: 10000,
+			MaxLen:    128},
+		Audit: AuditSettings{
+			File:       "./audit.log",
+			MaxSize:    100,
+			MaxBackups: 10,
+			QueueSize:  4096}}
 }
 
-func ReadConfig(configFile string) (*Config, error) {
-	_, err := os.Stat(configFile)
-	if err != nil {
+/*
+Read configuration: defaults are overridden with config file,
+then GCACHE_* environment variables and then given overrides
+(usually command-line flags). Config file is skipped if name is empty.
+*/
+func ReadConfig(configFile string, overrides Overrides) (*Config, error) {
+	config := DefaultConfig()
+	if configFile != "" {
+		if _, err := os.Stat(configFile); err != nil {
+			return nil, err
+		}
+		meta, err := toml.DecodeFile(configFile, config)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return nil, fmt.Errorf("unknown settings in %s: %s", configFile, strings.Join(keys, ", "))
+		}
+	}
+
+	if err := applyEnvOverrides(config, os.Environ()); err != nil {
+		return nil, err
+	}
+	if err := applyOverrides(config, overrides); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
+	return config, nil
+}
+
+// Check settings consistency, all found problems are reported.
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	check := func(ok bool, setting, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, setting+": "+fmt.Sprintf(format, args...))
+		}
+	}
 
-	var config Config
-	if _, err := toml.DecodeFile(configFile, &config); err != nil {
-		return &Config{}, err
+	g := c.General
+	check(oneOf(g.LogLevel, "debug", "info", "notice", "warning", "error", "critical"), "general.log_level",
+		"unsupported level %q, expected debug/info/notice/warning/error/critical", g.LogLevel)
+	check(oneOf(g.LogFormat, "short", "long", "json"), "general.log_format",
+		"unsupported format %q, expected short/long/json", g.LogFormat)
+	check(oneOf(g.LogOut, "stdout", "stderr", "file"), "general.log_out",
+		"unsupported output %q, expected stdout/stderr/file", g.LogOut)
+	if g.LogOut == "file" {
+		check(g.LogFile != "", "general.log_file", "must be set for file output")
+		check(g.LogMaxSize >= 0, "general.log_max_size", "must not be negative, got %d", g.LogMaxSize)
+		check(g.LogMaxBackups >= 0, "general.log_max_backups", "must not be negative, got %d", g.LogMaxBackups)
 	}
-	return &config, nil
+	for module, level := range g.LogModuleLevels {
+		check(oneOf(module, LogModules...), "general.log_modules",
+			"unknown module %q, expected one of %s", module, strings.Join(LogModules, "/"))
+		check(oneOf(level, "debug", "info", "notice", "warning", "error", "critical"), "general.log_modules",
+			"unsupported level %q for module %s", level, module)
+	}
+
+	s := c.Storage
+	check(oneOf(s.Engine, "sharded", "rcu"), "storage.engine",
+		"unsupported engine %q, expected sharded/rcu", s.Engine)
+	check(!s.SlabValues || s.Engine == "sharded", "storage.slab_values",
+		"supported by sharded engine only, got %s", s.Engine)
+	check(s.CompressThreshold >= 0, "storage.compress_threshold",
+		"must not be negative, got %d", s.CompressThreshold)
+	check(s.CompressThreshold == 0 || s.Engine == "sharded", "storage.compress_threshold",
+		"supported by sharded engine only, got %s", s.Engine)
+	names := make(map[string]bool)
+	for i, ns := range s.Namespaces {
+		check(validNamespace(ns.Name), "storage.namespaces",
+			"namespace #%d has invalid name %q, expected letters, digits, '_', '-' or '.'", i+1, ns.Name)
+		check(!names[ns.Name], "storage.namespaces", "duplicate namespace %q", ns.Name)
+		check(ns.KeyMaxLen >= 0 && ns.ValueMaxSize >= 0 && ns.DefaultTTL >= 0, "storage.namespaces",
+			"limits and TTL of namespace %q must not be negative", ns.Name)
+		names[ns.Name] = true
+	}
+	check(s.NumShards >= 1 && s.NumShards <= MAX_SHARDS, "storage.shards",
+		"must be in range 1..%d, got %d", MAX_SHARDS, s.NumShards)
+	check(s.ExpiredKeyCheckInterval > 0, "storage.key_exp_check_interval",
+		"must be positive, got %d", s.ExpiredKeyCheckInterval)
+	check(s.SnapshotMaxSize >= 1, "storage.snapshot_max_size",
+		"must be positive, got %d", s.SnapshotMaxSize)
+
+	l := c.Limits
+	check(l.KeyMaxLen >= 1, "limits.key_max_len", "must be positive, got %d", l.KeyMaxLen)
+	check(l.ValueMaxSize >= 1, "limits.value_max_size", "must be positive, got %d", l.ValueMaxSize)
+	check(oneOf(l.KeyChars, "any", "printable"), "limits.key_chars",
+		"unsupported policy %q, expected any/printable", l.KeyChars)
+	for i, p := range l.Prefixes {
+		check(p.Prefix != "", "limits.prefixes", "prefix #%d is empty", i+1)
+		check(p.KeyMaxLen >= 0 && p.ValueMaxSize >= 0, "limits.prefixes",
+			"limits of prefix %q must not be negative", p.Prefix)
+	}
+
+	r := c.Replication
+	check(oneOf(r.NodeRole, "standalone", "master", "slave"), "replication.node_role",
+		"unsupported role %q, expected standalone/master/slave", r.NodeRole)
+	check(r.DumpUpdatePeriod > 0, "replication.dump_update_period",
+		"must be positive, got %d", r.DumpUpdatePeriod)
+	if r.SaveCacheToFile {
+		check(r.FileWritePeriod > 0, "replication.file_write_period",
+			"must be positive when save_to_file is enabled, got %d", r.FileWritePeriod)
+	}
+	if r.SaveCacheToFile || r.RestoreCacheFromFile {
+		check(r.CacheFile != "", "replication.cache_file", "must be set to save or restore cache")
+	}
+	if r.NodeRole == "master" || r.NodeRole == "slave" {
+		check(r.MasterAddr != "", "replication.master_addr", "must be set for %s node", r.NodeRole)
+		// replication link is authenticated with HMAC keyed by secret
+		check(r.MasterSecret != "", "replication.master_secret", "must be set for %s node", r.NodeRole)
+	}
+	check(r.ReconnectMaxWait > 0, "replication.reconnect_max_wait",
+		"must be positive, got %d", r.ReconnectMaxWait)
+	check(r.ReconnectGiveUp >= 0, "replication.reconnect_give_up",
+		"must not be negative, got %d", r.ReconnectGiveUp)
+	check(r.SecretGracePeriod >= 0, "replication.secret_grace_period",
+		"must not be negative, got %d", r.SecretGracePeriod)
+	check((r.TLSCert == "") == (r.TLSKey == ""), "replication.tls_cert",
+		"certificate and key must be set together")
+	if r.TLSEnabled && r.NodeRole == "master" {
+		check(r.TLSCert != "", "replication.tls_cert", "must be set for master with TLS enabled")
+	}
+	if r.TLSClientAuth {
+		check(r.TLSCA != "", "replication.tls_ca", "must be set to verify slave certificates")
+	}
+
+	h := c.ClientHTTP
+	check(validPort(h.Port), "client-HTTP.port", "invalid port %q", h.Port)
+	check(h.IdleTimeout > 0, "client-HTTP.idle_timeout", "must be positive, got %d", h.IdleTimeout)
+	check((h.TLSCert == "") == (h.TLSKey == ""), "client-HTTP.tls_cert",
+		"certificate and key must be set together")
+
+	if c.Admin.Enabled {
+		check(validPort(c.Admin.Port), "admin.port", "invalid port %q", c.Admin.Port)
+	}
+
+	check(c.SlowLog.Threshold >= 0, "slowlog.threshold", "must not be negative, got %d", c.SlowLog.Threshold)
+	check(c.SlowLog.MaxLen >= 1, "slowlog.max_len", "must be positive, got %d", c.SlowLog.MaxLen)
+
+	if a := c.Audit; a.Enabled {
+		check(a.File != "", "audit.file", "must be set for enabled audit log")
+		check(a.MaxSize >= 0, "audit.max_size", "must not be negative, got %d", a.MaxSize)
+		check(a.MaxBackups >= 0, "audit.max_backups", "must not be negative, got %d", a.MaxBackups)
+		check(a.QueueSize >= 1, "audit.queue_size", "must be positive, got %d", a.QueueSize)
+		for _, pattern := range a.Keys {
+			_, err := glob.Compile(pattern)
+			check(err == nil, "audit.keys", "bad key pattern %q", pattern)
+		}
+	}
+
+	check(c.PubSub.QueueSize >= 1, "pubsub.queue_size", "must be positive, got %d", c.PubSub.QueueSize)
+
+	for i, t := range c.Auth.Tokens {
+		check(t.Token != "", "auth.tokens", "token #%d (%s) is empty", i+1, t.Name)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("bad configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Names of settings in form "section.key" differing in two configs.
+func ChangedSettings(old, new *Config) []string {
+	oldValues, newValues := settingValues(old), settingValues(new)
+	changed := make([]string, 0)
+	for name, v := range newValues {
+		if !reflect.DeepEqual(v.Interface(), oldValues[name].Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Set named setting in dst to its value in src.
+func CopySetting(dst, src *Config, name string) {
+	if v, ok := settingValues(dst)[name]; ok {
+		v.Set(settingValues(src)[name])
+	}
+}
+
+/* internals */
+
+func settingValues(c *Config) map[string]reflect.Value {
+	values := make(map[string]reflect.Value)
+	conf := reflect.ValueOf(c).Elem()
+	for i := 0; i < conf.NumField(); i++ {
+		section := conf.Type().Field(i).Tag.Get("toml")
+		fields := conf.Field(i)
+		for j := 0; j < fields.NumField(); j++ {
+			values[section+"."+fields.Type().Field(j).Tag.Get("toml")] = fields.Field(j)
+		}
+	}
+	return values
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+func validNamespace(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func validPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p < 65536
 }
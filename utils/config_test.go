@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChangedSettings(t *testing.T) {
+	old := &Config{
+		Storage: StorageSettings{NumShards: 4, ExpiredKeyCheckInterval: 10},
+		Auth:    AuthSettings{Tokens: []TokenSettings{TokenSettings{Name: "reader", Token: "token"}}}}
+	updated := &Config{
+		Storage: StorageSettings{NumShards: 8, ExpiredKeyCheckInterval: 10},
+		Auth:    AuthSettings{Tokens: []TokenSettings{TokenSettings{Name: "reader", Token: "new-token"}}},
+		SlowLog: SlowLogSettings{Enabled: true}}
+
+	changed := ChangedSettings(old, updated)
+	if !reflect.DeepEqual(changed, []string{"auth.tokens", "slowlog.enabled", "storage.shards"}) {
+		t.Errorf("unexpected changed settings: %v", changed)
+	}
+
+	CopySetting(updated, old, "storage.shards")
+	CopySetting(updated, old, "unknown.setting")
+	if updated.Storage.NumShards != 4 {
+		t.Errorf("setting is not copied: %d", updated.Storage.NumShards)
+	}
+	if changed := ChangedSettings(old, updated); len(changed) != 2 {
+		t.Errorf("unexpected changed settings after copy: %v", changed)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	confFile := writeTestConfig(t, `
+[storage]
+shards = 4
+
+[replication]
+node_role = "master"
+`)
+	defer os.Remove(confFile)
+
+	os.Setenv("GCACHE_REPLICATION_MASTER_SECRET", "env-secret")
+	os.Setenv("GCACHE_CLIENT_HTTP_PORT", "9090")
+	os.Setenv("GCACHE_AUDIT_KEYS", "user:*, session:*,")
+	os.Setenv("GCACHE_STORAGE_SHARD", "8")
+	defer os.Unsetenv("GCACHE_REPLICATION_MASTER_SECRET")
+	defer os.Unsetenv("GCACHE_CLIENT_HTTP_PORT")
+	defer os.Unsetenv("GCACHE_AUDIT_KEYS")
+	defer os.Unsetenv("GCACHE_STORAGE_SHARD")
+
+	// flags override environment
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides := ConfigFlags(fs)
+	if err := fs.Parse([]string{"-client-HTTP.port=9191", "-admin.enabled", "-replication.save_to_file"}); err != nil {
+		t.Fatalf("parse flags: %s", err)
+	}
+
+	conf, err := ReadConfig(confFile, overrides)
+	if err != nil {
+		t.Fatalf("read config: %s", err)
+	}
+	expected := DefaultConfig()
+	expected.Storage.NumShards = 4
+	expected.Replication.NodeRole = "master"
+	expected.Replication.MasterSecret = "env-secret"
+	expected.ClientHTTP.Port = "9191"
+	expected.Admin.Enabled = true
+	expected.Replication.SaveCacheToFile = true
+	expected.Audit.Keys = []string{"user:*", "session:*"}
+	if !reflect.DeepEqual(conf, expected) {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+
+	// defaults only
+	if conf, err := ReadConfig("", nil); err != nil || conf.ClientHTTP.Port != "9090" {
+		t.Errorf("cannot read config without file => config: %+v, error: %v", conf, err)
+	}
+
+	// unknown variables are ignored
+	if unknown := UnknownEnvVars(os.Environ()); !reflect.DeepEqual(unknown, []string{"GCACHE_STORAGE_SHARD"}) {
+		t.Errorf("unexpected unknown environment variables: %v", unknown)
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	testCases := []struct {
+		Config    string
+		Overrides Overrides
+		Err       string
+	}{
+		{"[storage]\nshard = 4", nil, "unknown settings in"},
+		{"[replication]\nnode_role = \"primary\"", nil, "replication.node_role: unsupported role \"primary\""},
+		{"[replication]\ndump_update_period = 0", nil, "replication.dump_update_period: must be positive"},
+		{"[replication]\nnode_role = \"slave\"", nil, "replication.master_secret: must be set for slave node"},
+		{"[storage]\nshards = 0\n[client-HTTP]\nport = \"http\"", nil, "storage.shards: must be in range 1..4096, got 0; client-HTTP.port: invalid port \"http\""},
+		{"", Overrides{"storage.shards": "many"}, "storage.shards: integer expected"},
+		{"", Overrides{"storage.engine": "rcu", "storage.slab_values": "true"}, "storage.slab_values: supported by sharded engine only"},
+		{"[[storage.namespaces]]\nname = \"a b\"", nil, "storage.namespaces: namespace #1 has invalid name \"a b\""},
+		{"[[storage.namespaces]]\nname = \"a\"\n[[storage.namespaces]]\nname = \"a\"", nil, "storage.namespaces: duplicate namespace \"a\""},
+		{"", Overrides{"auth.tokens": "token"}, "auth.tokens: cannot be overridden"},
+		{"", Overrides{"limits.prefixes": "user:"}, "limits.prefixes: cannot be overridden"},
+		{"", Overrides{"storage.unknown": "1"}, "unknown setting storage.unknown"},
+	}
+	for _, c := range testCases {
+		confFile := writeTestConfig(t, c.Config)
+		_, err := ReadConfig(confFile, c.Overrides)
+		os.Remove(confFile)
+		if err == nil || !strings.Contains(err.Error(), c.Err) {
+			t.Errorf("unexpected error for config %q => %v", c.Config, err)
+		}
+	}
+}
+
+/* helpers */
+
+func writeTestConfig(t *testing.T, data string) string {
+	f, err := ioutil.TempFile("", "gcache-config")
+	if err != nil {
+		t.Fatalf("create config file: %s", err)
+	}
+	f.WriteString(data)
+	f.Close()
+	return f.Name()
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/op/go-logging"
+	"io"
+	"time"
+)
+
+/*
+RequestLogger adds request ID to every record,
+so that all records of request could be found.
+*/
+type RequestLogger struct {
+	logger *logging.Logger
+	ID     string
+}
+
+func NewRequestLogger(module, requestID string) *RequestLogger {
+	logger := GetLogger(module)
+	// skip RequestLogger methods in caller info
+	logger.ExtraCalldepth = 2
+	return &RequestLogger{logger: logger, ID: requestID}
+}
+
+func (l *RequestLogger) Debugf(format string, args ...interface{}) {
+	l.log(logging.DEBUG, format, args)
+}
+
+func (l *RequestLogger) Infof(format string, args ...interface{}) {
+	l.log(logging.INFO, format, args)
+}
+
+func (l *RequestLogger) Noticef(format string, args ...interface{}) {
+	l.log(logging.NOTICE, format, args)
+}
+
+func (l *RequestLogger) Warningf(format string, args ...interface{}) {
+	l.log(logging.WARNING, format, args)
+}
+
+func (l *RequestLogger) Errorf(format string, args ...interface{}) {
+	l.log(logging.ERROR, format, args)
+}
+
+func (l *RequestLogger) Criticalf(format string, args ...interface{}) {
+	l.log(logging.CRITICAL, format, args)
+}
+
+/* internals */
+
+func (l *RequestLogger) log(level logging.Level, format string, args []interface{}) {
+	if !l.logger.IsEnabledFor(level) {
+		return
+	}
+	msg := requestMessage{requestID: l.ID, text: fmt.Sprintf(format, args...)}
+	switch level {
+	case logging.DEBUG:
+		l.logger.Debug(msg)
+	case logging.INFO:
+		l.logger.Info(msg)
+	case logging.NOTICE:
+		l.logger.Notice(msg)
+	case logging.WARNING:
+		l.logger.Warning(msg)
+	case logging.ERROR:
+		l.logger.Error(msg)
+	default:
+		l.logger.Critical(msg)
+	}
+}
+
+// message with request ID, kept separately for structured formats
+type requestMessage struct {
+	requestID string
+	text      string
+}
+
+func (m requestMessage) String() string {
+	if m.requestID == "" {
+		return m.text
+	}
+	return "[" + m.requestID + "] " + m.text
+}
+
+type jsonRecord struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Module    string `json:"module"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// one JSON object per line
+type jsonFormatter struct{}
+
+func (jsonFormatter) Format(calldepth int, r *logging.Record, w io.Writer) error {
+	record := jsonRecord{
+		Time:   r.Time.UTC().Format(time.RFC3339Nano),
+		Level:  r.Level.String(),
+		Module: r.Module}
+	if msg, ok := requestRecord(r); ok {
+		record.Message, record.RequestID = msg.text, msg.requestID
+	} else {
+		record.Message = r.Message()
+	}
+
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func requestRecord(r *logging.Record) (requestMessage, bool) {
+	if len(r.Args) != 1 {
+		return requestMessage{}, false
+	}
+	msg, ok := r.Args[0].(requestMessage)
+	return msg, ok
+}
@@ -2,50 +2,81 @@ package utils
 
 import (
 	"github.com/op/go-logging"
+	"io"
 	"os"
+	"strings"
+	"sync"
 )
 
-var defaultBackend logging.LeveledBackend
+// shared by all loggers, so that settings could be changed live
+var defaultBackend = &switchableBackend{}
 
-func SetupLoggers(c *Config) {
-	var format logging.Formatter
-	switch c.General.LogFormat {
-	case "long":
-		format = logging.MustStringFormatter(
-			`%{color}%{time:15:04:05.000} %{module:8s} %{shortfunc} ▶ %{level:.6s} %{id:03x}%{color:reset} %{message}`)
-	default:
-		format = logging.MustStringFormatter(`%{color}%{time:15:04:05.000} %{module:8s} ▶ %{level:.6s}%{color:reset} %{message}`)
+// Node logger modules, levels could be set per module
+var LogModules = []string{"Cache", "Storage", "REST", "Replicator", "PubSub", "TLS"}
+
+var logLevels = map[string]logging.Level{
+	"debug":    logging.DEBUG,
+	"info":     logging.INFO,
+	"notice":   logging.NOTICE,
+	"warning":  logging.WARNING,
+	"error":    logging.ERROR,
+	"critical": logging.CRITICAL}
+
+func SetupLoggers(c *Config) error {
+	backend, err := NewLogBackend(c)
+	if err != nil {
+		return err
+	}
+	backend.Install()
+	return nil
+}
+
+// Backend of all loggers built from settings, not used until installed.
+type LogBackend struct {
+	backend logging.LeveledBackend
+	file    *RotatingFile
+}
+
+// Build backend, opening log file if needed.
+func NewLogBackend(c *Config) (*LogBackend, error) {
+	var format logging.Formatter = jsonFormatter{}
+	if c.General.LogFormat != "json" {
+		layout := textFormat(c.General.LogFormat)
+		if c.General.LogOut == "file" {
+			// no colors in files
+			layout = strings.NewReplacer("%{color}", "", "%{color:reset}", "").Replace(layout)
+		}
+		format = logging.MustStringFormatter(layout)
 	}
 
-	var backend *logging.LogBackend
+	var out io.Writer
+	var logFile *RotatingFile
 	switch c.General.LogOut {
+	case "file":
+		var err error
+		logFile, err = OpenRotatingFile(c.General.LogFile, int64(c.General.LogMaxSize)*1024*1024, c.General.LogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = logFile
 	case "stderr":
-		backend = logging.NewLogBackend(os.Stderr, "", 0)
+		out = os.Stderr
 	default:
-		backend = logging.NewLogBackend(os.Stdout, "", 0)
-	}
-	backendFormatter := logging.NewBackendFormatter(backend, format)
-
-	logLevelS := c.General.LogLevel
-	var logLevel logging.Level
-	switch logLevelS {
-	case "debug":
-		logLevel = logging.DEBUG
-	case "notice":
-		logLevel = logging.NOTICE
-	case "warning":
-		logLevel = logging.WARNING
-	case "error":
-		logLevel = logging.ERROR
-	case "critical":
-		logLevel = logging.CRITICAL
-	default:
-		logLevel = logging.INFO
+		out = os.Stdout
 	}
+	backendFormatter := logging.NewBackendFormatter(logging.NewLogBackend(out, "", 0), format)
 
 	backendLeveled := logging.AddModuleLevel(backendFormatter)
-	backendLeveled.SetLevel(logLevel, "")
-	defaultBackend = backendLeveled
+	backendLeveled.SetLevel(parseLogLevel(c.General.LogLevel), "")
+	for module, level := range c.General.LogModuleLevels {
+		backendLeveled.SetLevel(parseLogLevel(level), module)
+	}
+	return &LogBackend{backend: backendLeveled, file: logFile}, nil
+}
+
+// Switch all loggers to the backend, previous log file is closed.
+func (b *LogBackend) Install() {
+	defaultBackend.set(b.backend, b.file)
 }
 
 func GetLogger(name string) *logging.Logger {
@@ -53,3 +84,71 @@ func GetLogger(name string) *logging.Logger {
 	logger.SetBackend(defaultBackend)
 	return logger
 }
+
+/* internals */
+
+func textFormat(name string) string {
+	if name == "long" {
+		return `%{color}%{time:15:04:05.000} %{module:8s} %{shortfunc} ▶ %{level:.6s} %{id:03x}%{color:reset} %{message}`
+	}
+	return `%{color}%{time:15:04:05.000} %{module:8s} ▶ %{level:.6s}%{color:reset} %{message}`
+}
+
+// INFO for unknown levels, config validation reports them
+func parseLogLevel(name string) logging.Level {
+	if level, ok := logLevels[name]; ok {
+		return level
+	}
+	return logging.INFO
+}
+
+// LeveledBackend delegating to replaceable backend
+type switchableBackend struct {
+	backend logging.LeveledBackend
+	// log file of current backend if any, closed on switch
+	file *RotatingFile
+	sync.RWMutex
+}
+
+func (b *switchableBackend) set(backend logging.LeveledBackend, file *RotatingFile) {
+	b.Lock()
+	prevFile := b.file
+	b.backend, b.file = backend, file
+	b.Unlock()
+	if prevFile != nil && prevFile != file {
+		prevFile.Close()
+	}
+}
+
+func (b *switchableBackend) get() logging.LeveledBackend {
+	b.RLock()
+	defer b.RUnlock()
+	return b.backend
+}
+
+func (b *switchableBackend) Log(level logging.Level, calldepth int, record *logging.Record) error {
+	if backend := b.get(); backend != nil {
+		return backend.Log(level, calldepth+1, record)
+	}
+	return nil
+}
+
+func (b *switchableBackend) GetLevel(module string) logging.Level {
+	if backend := b.get(); backend != nil {
+		return backend.GetLevel(module)
+	}
+	return logging.INFO
+}
+
+func (b *switchableBackend) SetLevel(level logging.Level, module string) {
+	if backend := b.get(); backend != nil {
+		backend.SetLevel(level, module)
+	}
+}
+
+func (b *switchableBackend) IsEnabledFor(level logging.Level, module string) bool {
+	if backend := b.get(); backend != nil {
+		return backend.IsEnabledFor(level, module)
+	}
+	return false
+}
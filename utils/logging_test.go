@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggingJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gcache-logs")
+	if err != nil {
+		t.Fatalf("create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := DefaultConfig()
+	conf.General.LogFormat = "json"
+	conf.General.LogOut = "file"
+	conf.General.LogFile = filepath.Join(dir, "gcache.log")
+	conf.General.LogLevel = "warning"
+	conf.General.LogModuleLevels = map[string]string{"REST": "debug"}
+	if err := SetupLoggers(conf); err != nil {
+		t.Fatalf("setup loggers: %s", err)
+	}
+	defer SetupLoggers(DefaultConfig())
+
+	GetLogger("Storage").Info("filtered out")
+	GetLogger("Storage").Warningf("storage %s", "warning")
+	NewRequestLogger("REST", "req-1").Debugf("request %d", 1)
+
+	f, err := os.Open(conf.General.LogFile)
+	if err != nil {
+		t.Fatalf("open log file: %s", err)
+	}
+	defer f.Close()
+
+	var records []jsonRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record jsonRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("bad JSON record %q: %s", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if r := records[0]; r.Module != "Storage" || r.Level != "WARNING" || r.Message != "storage warning" || r.RequestID != "" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if r := records[1]; r.Module != "REST" || r.Level != "DEBUG" || r.Message != "request 1" || r.RequestID != "req-1" {
+		t.Errorf("unexpected request record: %+v", r)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gcache-logs")
+	if err != nil {
+		t.Fatalf("create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "test.log")
+	f, err := OpenRotatingFile(name, 10, 2)
+	if err != nil {
+		t.Fatalf("open file: %s", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+	}
+	f.Close()
+
+	// each line exceeds limit with the previous one
+	for name, expected := range map[string]string{name: "fourth\n", name + ".1": "third\n", name + ".2": "second\n"} {
+		if data, err := ioutil.ReadFile(name); err != nil || string(data) != expected {
+			t.Errorf("unexpected content of %s => %q, error: %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Error("backups over the limit are kept")
+	}
+	if _, err := f.Write([]byte("closed")); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("write to closed file => %v", err)
+	}
+}
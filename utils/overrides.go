@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ENV_PREFIX = "GCACHE_"
+
+// Setting values by name in form "section.key"
+type Overrides map[string]string
+
+/*
+Register command-line flag for every setting, e.g. -storage.shards=32,
+values set with flags are collected in returned overrides after parsing.
+*/
+func ConfigFlags(fs *flag.FlagSet) Overrides {
+	overrides := make(Overrides)
+	values := settingValues(DefaultConfig())
+	for _, name := range settingNames(values) {
+		if !overridable(values[name]) {
+			continue
+		}
+		isBool := values[name].Kind() == reflect.Bool
+		fs.Var(&overrideFlag{name: name, isBool: isBool, overrides: overrides}, name,
+			fmt.Sprintf("override %s setting (env %s)", name, EnvName(name)))
+	}
+	return overrides
+}
+
+// Environment variable overriding setting, e.g. GCACHE_CLIENT_HTTP_PORT for client-HTTP.port
+func EnvName(setting string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(setting))
+}
+
+/*
+Environment variables with settings prefix that match no setting, environment
+is given as "KEY=value" pairs. Such variables are ignored by ReadConfig,
+so that node could be started in environment prepared for other version.
+*/
+func UnknownEnvVars(environ []string) []string {
+	_, unknown := envOverrides(environ)
+	return unknown
+}
+
+/* internals */
+
+type overrideFlag struct {
+	name string
+	// allows -setting without value
+	isBool    bool
+	overrides Overrides
+}
+
+func (f *overrideFlag) String() string {
+	if f.overrides == nil {
+		return ""
+	}
+	return f.overrides[f.name]
+}
+
+func (f *overrideFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func (f *overrideFlag) Set(value string) error {
+	f.overrides[f.name] = value
+	return nil
+}
+
+func applyEnvOverrides(c *Config, environ []string) error {
+	overrides, _ := envOverrides(environ)
+	return applyOverrides(c, overrides)
+}
+
+func envOverrides(environ []string) (Overrides, []string) {
+	byEnv := make(map[string]string)
+	for name := range settingValues(DefaultConfig()) {
+		byEnv[EnvName(name)] = name
+	}
+
+	overrides := make(Overrides)
+	unknown := make([]string, 0)
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], ENV_PREFIX) {
+			continue
+		}
+		if name, ok := byEnv[parts[0]]; ok {
+			overrides[name] = parts[1]
+		} else {
+			unknown = append(unknown, parts[0])
+		}
+	}
+	return overrides, unknown
+}
+
+func applyOverrides(c *Config, overrides Overrides) error {
+	values := settingValues(c)
+	for name, value := range overrides {
+		v, ok := values[name]
+		if !ok {
+			return fmt.Errorf("unknown setting %s", name)
+		}
+		if err := setValue(v, value); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// tables, such as namespaces or prefix limits, are set in config file only
+func overridable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func setValue(v reflect.Value, value string) error {
+	if !overridable(v) {
+		return fmt.Errorf("cannot be overridden, set it in config file")
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("integer expected, got %q", value)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("boolean expected, got %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		// comma-separated list, empty value clears it
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+func settingNames(values map[string]reflect.Value) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+RotatingFile is a writer appending to file, which is rotated
+when its size exceeds the limit: file is renamed to <name>.1,
+older backups are shifted to <name>.2 and so on, backups over
+the limit are removed.
+*/
+type RotatingFile struct {
+	name       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	sync.Mutex
+}
+
+// no rotation if maxSize < 1
+func OpenRotatingFile(name string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{name: name, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(data []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+/* internals */
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.maxBackups < 1 {
+		os.Remove(f.name)
+	} else {
+		os.Remove(f.backupName(f.maxBackups))
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(f.backupName(i), f.backupName(i+1))
+		}
+		if err := os.Rename(f.name, f.backupName(1)); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", f.name, n)
+}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// period of certificate files modification check
+	CERT_CHECK_PERIOD = 10 * time.Second
+)
+
+/*
+CertReloader keeps certificate loaded from files and reloads it
+as soon as certificate or key file is modified.
+*/
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	sync.RWMutex
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile, stopCh: make(chan struct{})}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	go c.watch(CERT_CHECK_PERIOD)
+	return c, nil
+}
+
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert, nil
+}
+
+func (c *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert, nil
+}
+
+// stop watching certificate files, safe to call on nil reloader
+func (c *CertReloader) Close() {
+	if c == nil {
+		return
+	}
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+/*
+TLS settings for server, client certificates are required and verified with CA if clientAuth is set.
+Returned reloader watches certificate files and must be closed when config is not used anymore.
+*/
+func ServerTLSConfig(certFile, keyFile, caFile string, clientAuth bool) (*tls.Config, *CertReloader, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientAuth {
+		if caFile == "" {
+			return nil, nil, errors.New("CA file is required for client authentication")
+		}
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf.GetCertificate = reloader.GetCertificate
+	return conf, reloader, nil
+}
+
+// TLS settings for client, server certificate is verified with CA or system roots if CA is not set,
+// client certificate is presented if set. Reloader is nil without client certificate.
+func ClientTLSConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, *CertReloader, error) {
+	conf := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		conf.RootCAs = pool
+	}
+
+	var reloader *CertReloader
+	if certFile != "" {
+		var err error
+		if reloader, err = NewCertReloader(certFile, keyFile); err != nil {
+			return nil, nil, err
+		}
+		conf.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return conf, reloader, nil
+}
+
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in CA file")
+	}
+	return pool, nil
+}
+
+/* internals */
+
+func (c *CertReloader) watch(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if reloaded, err := c.reload(); err != nil {
+				GetLogger("TLS").Errorf("cannot reload certificate %s: %s", c.certFile, err)
+			} else if reloaded {
+				GetLogger("TLS").Infof("certificate reloaded: %s", c.certFile)
+			}
+		}
+	}
+}
+
+// load certificate if files were modified since last load
+func (c *CertReloader) reload() (bool, error) {
+	modTime, err := lastModTime(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.RLock()
+	unchanged := c.cert != nil && !modTime.After(c.modTime)
+	c.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.Unlock()
+	return true, nil
+}
+
+func lastModTime(files ...string) (time.Time, error) {
+	var last time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return last, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// boilerplate for tests: self-signed certificate valid for localhost,
+// could be used as its own CA
+func GenerateSelfSignedCert(commonName string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM, nil
+}
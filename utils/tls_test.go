@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUtilsTLSCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gcache-tls")
+	if err != nil {
+		t.Fatalf("create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := writeTestCert(certFile, keyFile, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("write certificate: %s", err)
+	}
+
+	c, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load certificate: %s", err)
+	}
+	defer c.Close()
+	first, _ := c.GetCertificate(nil)
+
+	// files are not modified
+	if reloaded, err := c.reload(); reloaded || err != nil {
+		t.Errorf("unexpected reload of unchanged certificate => reloaded: %v, error: %v", reloaded, err)
+	}
+
+	// replace certificate
+	if err := writeTestCert(certFile, keyFile, time.Now()); err != nil {
+		t.Fatalf("write certificate: %s", err)
+	}
+	if reloaded, err := c.reload(); !reloaded || err != nil {
+		t.Errorf("certificate is not reloaded => reloaded: %v, error: %v", reloaded, err)
+	}
+	second, _ := c.GetClientCertificate(nil)
+	if bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Error("certificate has not changed after reload")
+	}
+
+	// broken key keeps previous certificate
+	ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+	os.Chtimes(keyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if _, err := c.reload(); err == nil {
+		t.Error("no error for broken key")
+	}
+	if current, _ := c.GetCertificate(nil); current != second {
+		t.Error("certificate changed after failed reload")
+	}
+}
+
+func TestUtilsTLSConfig(t *testing.T) {
+	if _, _, err := ServerTLSConfig("missing.pem", "missing.key", "", false); err == nil {
+		t.Error("no error for missing certificate")
+	}
+	if _, _, err := ServerTLSConfig("missing.pem", "missing.key", "", true); err == nil {
+		t.Error("no error for client authentication without CA")
+	}
+	if _, _, err := ClientTLSConfig("", "", "missing-ca.pem", ""); err == nil {
+		t.Error("no error for missing CA")
+	}
+	conf, reloader, err := ClientTLSConfig("", "", "", "localhost")
+	if err != nil || conf.ServerName != "localhost" || reloader != nil {
+		t.Errorf("bad client TLS config => config: %+v, reloader: %v, error: %v", conf, reloader, err)
+	}
+	// nothing to stop without client certificate
+	reloader.Close()
+}
+
+/* helpers */
+
+func writeTestCert(certFile, keyFile string, modTime time.Time) error {
+	certPEM, keyPEM, err := GenerateSelfSignedCert("localhost")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	os.Chtimes(certFile, modTime, modTime)
+	return os.Chtimes(keyFile, modTime, modTime)
+}